@@ -0,0 +1,466 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	flag "github.com/spf13/pflag"
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+
+	catalogue "github.com/fromanirh/virt-profiles/pkg/catalogue"
+	profiler "github.com/fromanirh/virt-profiles/pkg/profiler"
+)
+
+func main() {
+	conf := Config{}
+	conf.ParseFlags()
+
+	var err error
+	if conf.Extract != "" {
+		err = runExtract(&conf)
+	} else if conf.Offline {
+		err = runOffline(&conf)
+	} else {
+		err = runOnline(&conf)
+	}
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+type Config struct {
+	Host       string
+	Port       int
+	Offline    bool
+	Profiles   string
+	VMI        string
+	XMLNames   []string
+	ProfNames  []string
+	DNS        []string
+	DNSSearch  []string
+	ResolvConf bool
+	StopAfter  string
+	BaseDisk   string
+	Emulation  bool
+	Timeout    time.Duration
+	Extract    string
+	Name       string
+	OutputDir  string
+	Layout     string
+	LayoutFile string
+	HostCaps   string
+	SecretDir  string
+	Secrets    []string
+	Volumes    []string
+	Claims     []string
+	HostDevs   string
+	HostCPUs   string
+	Firmware   string
+}
+
+func (c *Config) ParseFlags() {
+	flag.StringVar(&c.Host, "host", "localhost", "set the virtprofilesd host to connect to")
+	flag.IntVar(&c.Port, "port", 8080, "set the virtprofilesd port to connect to")
+	flag.BoolVar(&c.Offline, "offline", false, "run the profiler pipeline in-process, without virtprofilesd")
+	flag.StringVar(&c.Profiles, "profiles", "/usr/share/virt-profiles", "set the libvirt profiles directory (offline mode)")
+	flag.StringVar(&c.VMI, "vmi", "", "VirtualMachineInstance YAML to process, '-' for stdin (offline mode)")
+	flag.StringSliceVar(&c.ProfNames, "profile", []string{}, "profile to use in the translation, can be repeated (offline mode)")
+	flag.StringSliceVar(&c.DNS, "dns", []string{}, "DNS server of the VM, can be repeated (offline mode)")
+	flag.StringSliceVar(&c.DNSSearch, "dns-search", []string{}, "DNS search domain of the VM, can be repeated (offline mode)")
+	flag.BoolVar(&c.ResolvConf, "dns-from-resolv-conf", false, "use the local resolv.conf for the DNS settings not given on the command line (offline mode)")
+	flag.StringSliceVar(&c.XMLNames, "xml-profile", []string{}, "stage3 XML profile to apply, can be repeated (offline mode)")
+	flag.StringVar(&c.StopAfter, "stop-after", string(profiler.StageComplete), "stop after the given stage and dump its output: presets, translate, profiles, complete (offline mode)")
+	flag.StringVar(&c.BaseDisk, "base-disk-path", "", "set the base path for the VM disks (offline mode)")
+	flag.BoolVar(&c.Emulation, "use-emulation", false, "fall back to software emulation if the host has no hardware virtualization (offline mode)")
+	flag.DurationVar(&c.Timeout, "stage-timeout", 0, "maximum running time of each stage, 0 means no timeout (offline mode)")
+	flag.StringVar(&c.Layout, "path-layout", "kubevirt", "layout of the VM files rooted in the base disk path: kubevirt, host (offline mode)")
+	flag.StringVar(&c.LayoutFile, "path-layout-file", "", "JSON or YAML PathLayout overriding the paths of the --path-layout (offline mode)")
+	flag.StringSliceVar(&c.Secrets, "secret", []string{}, "Kubernetes Secret YAML referenced by the VM, can be repeated (offline mode)")
+	flag.StringSliceVar(&c.Volumes, "pv", []string{}, "Kubernetes PersistentVolume YAML bound to a claim of the VM, to reach the iSCSI volumes; can be repeated (offline mode)")
+	flag.StringSliceVar(&c.Claims, "pvc", []string{}, "Kubernetes PersistentVolumeClaim YAML used by the VM, to find the block mode volumes; can be repeated (offline mode)")
+	flag.StringVar(&c.SecretDir, "secrets-dir", "", "directory to write the libvirt secrets needed by the VM into (offline mode)")
+	flag.StringVar(&c.HostCaps, "host-caps", "", "capabilities XML of the host which will run the VM; probe the local host if missing (offline mode)")
+	flag.StringVar(&c.HostDevs, "host-devices", "", "JSON list of the PCI devices of the host which will run the VM, available for passthrough (offline mode)")
+	flag.StringVar(&c.HostCPUs, "host-cpuset", "", "host CPUs the VM can be pinned to, like 2-7 (offline mode)")
+	flag.StringVar(&c.Firmware, "firmware-dir", profiler.DefaultFirmwareDir, "directory of the QEMU firmware descriptors of the host which will run the VM (offline mode)")
+	flag.StringVar(&c.Extract, "extract", "", "extract a profile from the given libvirt domain XML, '-' for stdin")
+	flag.StringVar(&c.Name, "name", "", "name of the extracted profile (extract mode)")
+	flag.StringVar(&c.OutputDir, "output-dir", ".", "directory to write the extracted profile into (extract mode)")
+	flag.Parse()
+}
+
+func (c *Config) ServerAddress() string {
+	return fmt.Sprintf("http://%s:%d", c.Host, c.Port)
+}
+
+func runOnline(conf *Config) error {
+	resp, err := http.Get(conf.ServerAddress() + "/profiles")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	entries := []string{}
+	dec := json.NewDecoder(resp.Body)
+	err = dec.Decode(&entries)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fmt.Println(entry)
+	}
+	return nil
+}
+
+func runOffline(conf *Config) error {
+	vmi, err := readVMI(conf.VMI)
+	if err != nil {
+		return err
+	}
+
+	cat, err := catalogue.NewCatalogue(conf.Profiles)
+	if err != nil {
+		return err
+	}
+	presets, err := cat.PresetsFor(vmi)
+	if err != nil {
+		return err
+	}
+	// the profiles asked by name come last, so they override the ones matching the VM labels
+	profiles, err := cat.ProfilesFor(vmi)
+	if err != nil {
+		return err
+	}
+	named, err := cat.Profiles(conf.ProfNames)
+	if err != nil {
+		return err
+	}
+	profiles = append(profiles, named...)
+	xmlProfiles, err := cat.XMLProfiles(conf.XMLNames)
+	if err != nil {
+		return err
+	}
+
+	p, err := newProfiler(conf)
+	if err != nil {
+		return err
+	}
+
+	req := &profiler.Request{
+		Presets:     presets,
+		XMLProfiles: xmlProfiles,
+		Profiles:    profiles,
+		HostCPUSet:  conf.HostCPUs,
+		StopAfter:   profiler.Stage(conf.StopAfter),
+		Timeouts:    make(map[profiler.Stage]time.Duration),
+	}
+	for _, stage := range profiler.Stages {
+		req.Timeouts[stage] = conf.Timeout
+	}
+	if len(conf.DNS) > 0 || len(conf.DNSSearch) > 0 || conf.ResolvConf {
+		req.DNS = &profiler.DNSConfig{
+			Nameservers:    conf.DNS,
+			SearchDomains:  conf.DNSSearch,
+			FromResolvConf: conf.ResolvConf,
+		}
+	}
+	res, err := p.Run(context.Background(), vmi, req)
+	for _, warning := range res.Warnings {
+		log.Printf("%s", warning)
+	}
+	if err != nil {
+		return err
+	}
+
+	if conf.SecretDir != "" {
+		err = writeSecrets(conf.SecretDir, res.Secrets)
+		if err != nil {
+			return err
+		}
+	}
+
+	dom, domSpec := res.Output()
+	if dom == nil {
+		return dumpDomainSpec(domSpec)
+	}
+	return dumpDomain(dom)
+}
+
+func newProfiler(conf *Config) (*profiler.Profiler, error) {
+	p := profiler.NewProfiler(conf.BaseDisk)
+	p.SetUseEmulation(conf.Emulation)
+	switch conf.Layout {
+	case "kubevirt":
+		p.SetPathLayout(profiler.KubeVirtPathLayout())
+	case "host":
+		p.SetPathLayout(profiler.HostPathLayout())
+	default:
+		return nil, fmt.Errorf("unknown path layout: %s", conf.Layout)
+	}
+	if conf.LayoutFile != "" {
+		layout, err := readPathLayout(conf.Layout, conf.LayoutFile)
+		if err != nil {
+			return nil, err
+		}
+		p.SetPathLayout(layout)
+	}
+
+	var host *profiler.Host
+	var err error
+	if conf.HostCaps != "" {
+		var data []byte
+		data, err = readInput(conf.HostCaps)
+		if err != nil {
+			return nil, err
+		}
+		host, err = profiler.NewHostFromCapabilities(string(data))
+	} else {
+		host, err = profiler.LocalHost()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conf.HostDevs != "" {
+		data, err := readInput(conf.HostDevs)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(data, &host.PCIDevices)
+		if err != nil {
+			return nil, fmt.Errorf("malformed host devices: %v", err)
+		}
+	}
+	host.Firmwares, err = profiler.LoadFirmwareDescriptors(conf.Firmware)
+	if err != nil {
+		return nil, err
+	}
+	p.SetHost(host)
+
+	for _, path := range conf.Secrets {
+		secret, err := readSecret(path)
+		if err != nil {
+			return nil, err
+		}
+		p.AddSecret(fmt.Sprintf("%s/%s", secret.Namespace, secret.Name), secret)
+	}
+	for _, path := range conf.Volumes {
+		volume, err := readVolume(path)
+		if err != nil {
+			return nil, err
+		}
+		p.AddPersistentVolume(volume.Name, volume)
+	}
+	for _, path := range conf.Claims {
+		claim, err := readClaim(path)
+		if err != nil {
+			return nil, err
+		}
+		p.AddPersistentVolumeClaim(fmt.Sprintf("%s/%s", claim.Namespace, claim.Name), claim)
+	}
+	return p, nil
+}
+
+func runExtract(conf *Config) error {
+	if conf.Name == "" {
+		return fmt.Errorf("missing profile name, use --name")
+	}
+	// the name is also the base name of the output files
+	if errs := validation.IsDNS1123Label(conf.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid profile name %q: %s", conf.Name, strings.Join(errs, ", "))
+	}
+	data, err := readInput(conf.Extract)
+	if err != nil {
+		return err
+	}
+	dom := &libvirtxml.Domain{}
+	err = dom.Unmarshal(string(data))
+	if err != nil {
+		return err
+	}
+
+	p, err := newProfiler(conf)
+	if err != nil {
+		return err
+	}
+	prof, warnings, err := p.ExtractProfile(conf.Name, dom)
+	for _, warning := range warnings {
+		log.Printf("%s", warning)
+	}
+	if err != nil {
+		return err
+	}
+	for _, unmapped := range prof.Unmapped {
+		log.Printf("not carried by the extracted profiles: %s", unmapped)
+	}
+
+	presetData, err := yaml.Marshal(prof.Preset)
+	if err != nil {
+		return err
+	}
+	presetPath := filepath.Join(conf.OutputDir, conf.Name+".yaml")
+	err = ioutil.WriteFile(presetPath, presetData, 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("preset written to %s", presetPath)
+
+	profilePath := filepath.Join(conf.OutputDir, conf.Name+".xml")
+	err = ioutil.WriteFile(profilePath, []byte(prof.XMLProfile+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("XML profile written to %s", profilePath)
+	return nil
+}
+
+// writeSecrets writes each secret definition in a XML file, and its value, base64 encoded, in a file with the same name
+func writeSecrets(dir string, secrets []profiler.Secret) error {
+	for idx, secret := range secrets {
+		data, err := secret.Definition.Marshal()
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, fmt.Sprintf("secret%d", idx))
+		err = ioutil.WriteFile(name+".xml", []byte(data+"\n"), 0600)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(name+".value", []byte(base64.StdEncoding.EncodeToString(secret.Value)), 0600)
+		if err != nil {
+			return err
+		}
+		log.Printf("secret written to %s.xml", name)
+	}
+	return nil
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+func readVMI(path string) (*k6tv1.VirtualMachineInstance, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing VirtualMachineInstance, use --vmi")
+	}
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	vmi := &k6tv1.VirtualMachineInstance{}
+	err = yaml.Unmarshal(data, vmi)
+	if err != nil {
+		return nil, err
+	}
+	return vmi, nil
+}
+
+func readSecret(path string) (*k8sv1.Secret, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &k8sv1.Secret{}
+	err = yaml.Unmarshal(data, secret)
+	if err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func readVolume(path string) (*k8sv1.PersistentVolume, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	volume := &k8sv1.PersistentVolume{}
+	err = yaml.Unmarshal(data, volume)
+	if err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// readPathLayout reads a PathLayout from a file; the paths missing in the file come from the named layout
+func readPathLayout(name, path string) (*profiler.PathLayout, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := profiler.KubeVirtPathLayout()
+	if name == "host" {
+		layout = profiler.HostPathLayout()
+	}
+	err = yaml.Unmarshal(data, layout)
+	if err != nil {
+		return nil, fmt.Errorf("path layout %s: %v", path, err)
+	}
+	return layout, nil
+}
+
+func readClaim(path string) (*k8sv1.PersistentVolumeClaim, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, err
+	}
+
+	claim := &k8sv1.PersistentVolumeClaim{}
+	err = yaml.Unmarshal(data, claim)
+	if err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+func dumpDomainSpec(domSpec *k6tv1.DomainSpec) error {
+	data, err := yaml.Marshal(domSpec)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+func dumpDomain(dom *libvirtxml.Domain) error {
+	data, err := dom.Marshal()
+	if err != nil {
+		return err
+	}
+	fmt.Println(data)
+	return nil
+}
@@ -32,7 +32,7 @@ func main() {
 	log.Printf("profiles from %s", conf.Profiles)
 	app, err := profilerapp.NewProfilerApp(conf.Profiles)
 	if err != nil {
-		log.Fatal("%v", err)
+		log.Fatalf("%v", err)
 	}
 
 	log.Printf("listening on %s", conf.ListenAddress())
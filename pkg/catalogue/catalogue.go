@@ -6,16 +6,98 @@
 package virtprofiles
 
 import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+
+	profiler "github.com/fromanirh/virt-profiles/pkg/profiler"
 )
 
 // Catalogue manages a collection of virt profiles.
 type Catalogue struct {
+	profilesDir string
+	presets     map[string]*k6tv1.VirtualMachineInstancePreset
+	profiles    map[string]*profiler.Profile
+	xmlProfiles map[string]string
 }
 
 func NewCatalogue(profilesDir string) (*Catalogue, error) {
-	// TODO: make sure profilesDir is abspath
-	return &Catalogue{}, nil
+	profilesDir, err := filepath.Abs(profilesDir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Catalogue{
+		profilesDir: profilesDir,
+		presets:     make(map[string]*k6tv1.VirtualMachineInstancePreset),
+		profiles:    make(map[string]*profiler.Profile),
+		xmlProfiles: make(map[string]string),
+	}
+	err = c.load()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads all the profiles found in the profiles directory.
+// YAML and JSON files are expected to hold presets or profiles, depending on their kind,
+// while XML files hold the stage3 profiles. Any other file is ignored.
+func (c *Catalogue) load() error {
+	entries, err := ioutil.ReadDir(c.profilesDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		data, err := ioutil.ReadFile(filepath.Join(c.profilesDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		switch ext {
+		case ".yaml", ".yml", ".json":
+			typeMeta := metav1.TypeMeta{}
+			err = yaml.Unmarshal(data, &typeMeta)
+			if err != nil {
+				return fmt.Errorf("malformed file %s: %v", entry.Name(), err)
+			}
+			if typeMeta.Kind == profiler.ProfileKind {
+				prof := &profiler.Profile{}
+				err = yaml.Unmarshal(data, prof)
+				if err != nil {
+					return fmt.Errorf("malformed profile %s: %v", entry.Name(), err)
+				}
+				if prof.Name == "" {
+					prof.Name = name
+				}
+				c.profiles[name] = prof
+				continue
+			}
+			preset := &k6tv1.VirtualMachineInstancePreset{}
+			err = yaml.Unmarshal(data, preset)
+			if err != nil {
+				return fmt.Errorf("malformed preset %s: %v", entry.Name(), err)
+			}
+			if preset.Name == "" {
+				preset.Name = name
+			}
+			c.presets[name] = preset
+		case ".xml":
+			c.xmlProfiles[name] = string(data)
+		}
+	}
+	return nil
 }
 
 // Names return the names of all the profiles in the Catalogue
@@ -24,17 +106,122 @@ func NewCatalogue(profilesDir string) (*Catalogue, error) {
 // refer to profiles.
 func (c *Catalogue) Names() ([]string, error) {
 	entries := []string{}
+	for name := range c.presets {
+		entries = append(entries, name)
+	}
+	for name := range c.profiles {
+		entries = append(entries, name)
+	}
+	for name := range c.xmlProfiles {
+		entries = append(entries, name)
+	}
+	sort.Strings(entries)
 	return entries, nil
 }
 
-func (c *Catalogue) AddPreset(preset k6tv1.DomainPresetSpec) error {
+func (c *Catalogue) AddPreset(preset k6tv1.DomainSpec) error {
 	return nil
 }
 
+// Get returns the profile registered with the given name: either a *VirtualMachineInstancePreset,
+// a *Profile or a string holding a stage3 XML profile.
 func (c *Catalogue) Get(name string) (interface{}, error) {
-	return nil, nil
+	if preset, ok := c.presets[name]; ok {
+		return preset, nil
+	}
+	if prof, ok := c.profiles[name]; ok {
+		return prof, nil
+	}
+	if xmlProfile, ok := c.xmlProfiles[name]; ok {
+		return xmlProfile, nil
+	}
+	return nil, fmt.Errorf("unknown profile: %s", name)
 }
 
 func (c *Catalogue) GetAll(names []string) ([]interface{}, error) {
-	return nil, nil
+	ret := []interface{}{}
+	for _, name := range names {
+		obj, err := c.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, obj)
+	}
+	return ret, nil
+}
+
+// PresetsFor returns all the presets whose selector matches the given VirtualMachineInstance
+func (c *Catalogue) PresetsFor(vmi *k6tv1.VirtualMachineInstance) ([]k6tv1.VirtualMachineInstancePreset, error) {
+	ret := []k6tv1.VirtualMachineInstancePreset{}
+	for _, name := range c.sortedPresetNames() {
+		preset := c.presets[name]
+		selector, err := metav1.LabelSelectorAsSelector(&preset.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("preset %s has an invalid selector: %v", name, err)
+		}
+		if selector.Matches(labels.Set(vmi.Labels)) {
+			ret = append(ret, *preset)
+		}
+	}
+	return ret, nil
+}
+
+// Profiles returns the profiles with the given names
+func (c *Catalogue) Profiles(names []string) ([]*profiler.Profile, error) {
+	ret := []*profiler.Profile{}
+	for _, name := range names {
+		prof, ok := c.profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile: %s", name)
+		}
+		ret = append(ret, prof)
+	}
+	return ret, nil
+}
+
+// ProfilesFor returns the profiles whose selector matches the given VirtualMachineInstance, sorted by name
+func (c *Catalogue) ProfilesFor(vmi *k6tv1.VirtualMachineInstance) ([]*profiler.Profile, error) {
+	names := []string{}
+	for name := range c.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := []*profiler.Profile{}
+	for _, name := range names {
+		prof := c.profiles[name]
+		if prof.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(prof.Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s has an invalid selector: %v", name, err)
+		}
+		if selector.Matches(labels.Set(vmi.Labels)) {
+			ret = append(ret, prof)
+		}
+	}
+	return ret, nil
+}
+
+// XMLProfiles returns the content of the stage3 XML profiles with the given names
+func (c *Catalogue) XMLProfiles(names []string) ([]string, error) {
+	ret := []string{}
+	for _, name := range names {
+		xmlProfile, ok := c.xmlProfiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown XML profile: %s", name)
+		}
+		ret = append(ret, xmlProfile)
+	}
+	return ret, nil
+}
+
+func (c *Catalogue) sortedPresetNames() []string {
+	names := []string{}
+	for name := range c.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,120 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// Well known channel names
+const (
+	GuestAgentChannel = "org.qemu.guest_agent.0"
+	SpiceAgentChannel = "com.redhat.spice.0"
+)
+
+// convert_api_Channels_To_api_Channels adds the virtio-serial controller and the channels to the guest agents.
+// Every VM gets the QEMU guest agent channel, on the socket of the path layout, unless a profile disables it;
+// the SPICE agent and the custom channels are opt-in.
+func convert_api_Channels_To_api_Channels(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.Channels
+	if conf == nil {
+		conf = &ChannelsConfig{}
+	}
+	channels := []libvirtxml.DomainChannel{}
+
+	if !conf.DisableGuestAgent {
+		agentPath, err := c.Paths.GuestAgentSocket()
+		if err != nil {
+			return err
+		}
+		channels = append(channels, unixChannel(GuestAgentChannel, agentPath))
+	}
+
+	if conf.SpiceAgent {
+		if !hasSpiceGraphics(domain) {
+			return fmt.Errorf("the SPICE agent channel needs SPICE graphics")
+		}
+		channels = append(channels, libvirtxml.DomainChannel{
+			Source: &libvirtxml.DomainChardevSource{
+				SpiceVMC: &libvirtxml.DomainChardevSourceSpiceVMC{},
+			},
+			Target: &libvirtxml.DomainChannelTarget{
+				VirtIO: &libvirtxml.DomainChannelTargetVirtIO{
+					Name: SpiceAgentChannel,
+				},
+			},
+		})
+	}
+
+	seen := map[string]bool{
+		GuestAgentChannel: true,
+		SpiceAgentChannel: true,
+	}
+	for _, name := range conf.Custom {
+		if name == "" || strings.ContainsAny(name, "/ ") {
+			return fmt.Errorf("invalid channel name %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate channel %s", name)
+		}
+		seen[name] = true
+		channelPath, err := c.Paths.ChannelSocket(name)
+		if err != nil {
+			return err
+		}
+		channels = append(channels, unixChannel(name, channelPath))
+	}
+
+	if len(channels) == 0 {
+		return nil
+	}
+	index := uint(0)
+	domain.Devices.Controllers = append(domain.Devices.Controllers, libvirtxml.DomainController{
+		Type:  "virtio-serial",
+		Index: &index,
+	})
+	domain.Devices.Channels = append(domain.Devices.Channels, channels...)
+	return nil
+}
+
+func unixChannel(name, path string) libvirtxml.DomainChannel {
+	return libvirtxml.DomainChannel{
+		Source: &libvirtxml.DomainChardevSource{
+			UNIX: &libvirtxml.DomainChardevSourceUNIX{
+				Mode: "bind",
+				Path: path,
+			},
+		},
+		Target: &libvirtxml.DomainChannelTarget{
+			VirtIO: &libvirtxml.DomainChannelTargetVirtIO{
+				Name: name,
+			},
+		},
+	}
+}
+
+func hasSpiceGraphics(domain *libvirtxml.Domain) bool {
+	for _, graphics := range domain.Devices.Graphics {
+		if graphics.Spice != nil {
+			return true
+		}
+	}
+	return false
+}
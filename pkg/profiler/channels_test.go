@@ -0,0 +1,154 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestConvertChannels(t *testing.T) {
+	socketDir := testBaseDiskPath + "/default/testvmi/sockets/"
+	spice := []libvirtxml.DomainGraphic{{Spice: &libvirtxml.DomainGraphicSpice{}}}
+	vnc := []libvirtxml.DomainGraphic{{VNC: &libvirtxml.DomainGraphicVNC{}}}
+
+	tests := []struct {
+		name     string
+		conf     *ChannelsConfig
+		graphics []libvirtxml.DomainGraphic
+		// wantChannels are the channel names, with the socket path of the unix ones
+		wantChannels [][2]string
+		wantErr      bool
+	}{
+		{
+			name:         "no channels profile",
+			wantChannels: [][2]string{{GuestAgentChannel, socketDir + "guest-agent"}},
+		},
+		{
+			name:         "empty channels profile",
+			conf:         &ChannelsConfig{},
+			wantChannels: [][2]string{{GuestAgentChannel, socketDir + "guest-agent"}},
+		},
+		{
+			name: "guest agent disabled",
+			conf: &ChannelsConfig{DisableGuestAgent: true},
+		},
+		{
+			name:     "SPICE agent",
+			conf:     &ChannelsConfig{SpiceAgent: true},
+			graphics: spice,
+			wantChannels: [][2]string{
+				{GuestAgentChannel, socketDir + "guest-agent"},
+				{SpiceAgentChannel, ""},
+			},
+		},
+		{
+			name:         "SPICE agent without the guest agent",
+			conf:         &ChannelsConfig{DisableGuestAgent: true, SpiceAgent: true},
+			graphics:     spice,
+			wantChannels: [][2]string{{SpiceAgentChannel, ""}},
+		},
+		{
+			name:     "SPICE agent without SPICE graphics",
+			conf:     &ChannelsConfig{SpiceAgent: true},
+			graphics: vnc,
+			wantErr:  true,
+		},
+		{
+			name: "custom channels after the agents",
+			conf: &ChannelsConfig{Custom: []string{"org.example.agent.0", "org.example.agent.1"}},
+			wantChannels: [][2]string{
+				{GuestAgentChannel, socketDir + "guest-agent"},
+				{"org.example.agent.0", socketDir + "channel-org.example.agent.0"},
+				{"org.example.agent.1", socketDir + "channel-org.example.agent.1"},
+			},
+		},
+		{
+			name:    "empty custom name",
+			conf:    &ChannelsConfig{Custom: []string{""}},
+			wantErr: true,
+		},
+		{
+			name:    "custom name with a slash",
+			conf:    &ChannelsConfig{Custom: []string{"org/example"}},
+			wantErr: true,
+		},
+		{
+			name:    "custom name with a space",
+			conf:    &ChannelsConfig{Custom: []string{"org example"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate custom channel",
+			conf:    &ChannelsConfig{Custom: []string{"org.example.agent.0", "org.example.agent.0"}},
+			wantErr: true,
+		},
+		{
+			name:    "custom channel named like the guest agent",
+			conf:    &ChannelsConfig{Custom: []string{GuestAgentChannel}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{Graphics: tt.graphics}}
+			err := convert_api_Channels_To_api_Channels(domain, newTestContext(&ProfileSpec{Channels: tt.conf}))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			channels := domain.Devices.Channels
+			if len(channels) != len(tt.wantChannels) {
+				t.Fatalf("got channels %+v, want %v", channels, tt.wantChannels)
+			}
+			for i, want := range tt.wantChannels {
+				if channels[i].Target == nil || channels[i].Target.VirtIO == nil || channels[i].Target.VirtIO.Name != want[0] {
+					t.Errorf("got channel target %+v, want %s", channels[i].Target, want[0])
+				}
+				path := ""
+				if channels[i].Source != nil && channels[i].Source.UNIX != nil {
+					path = channels[i].Source.UNIX.Path
+				}
+				if path != want[1] {
+					t.Errorf("got socket %q for %s, want %q", path, want[0], want[1])
+				}
+			}
+
+			controllers := 0
+			for _, controller := range domain.Devices.Controllers {
+				if controller.Type == "virtio-serial" {
+					controllers++
+				}
+			}
+			wantControllers := 0
+			if len(tt.wantChannels) > 0 {
+				wantControllers = 1
+			}
+			if controllers != wantControllers {
+				t.Errorf("got %d virtio-serial controllers, want %d", controllers, wantControllers)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"strconv"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// Clock offsets
+const (
+	ClockOffsetUTC       = "utc"
+	ClockOffsetLocaltime = "localtime"
+	ClockOffsetTimezone  = "timezone"
+	ClockOffsetVariable  = "variable"
+)
+
+// the settings libvirt accepts for each timer; any timer can be present or not.
+// The hpet tick policies are the ones KubeVirt allows, also accepted by the libvirt schema.
+var timerRules = map[string]struct {
+	tickPolicies []string
+	tracks       []string
+}{
+	"rtc":         {tickPolicies: []string{"delay", "catchup"}, tracks: []string{"guest", "wall"}},
+	"pit":         {tickPolicies: []string{"delay", "catchup", "discard"}},
+	"hpet":        {tickPolicies: []string{"delay", "catchup", "merge", "discard"}},
+	"kvmclock":    {},
+	"hypervclock": {},
+}
+
+// convert_v1_Clock_To_api_Clock translates the clock offset and the timers. The profile gives the offset
+// when the spec has none, and is the only way to ask for a variable offset.
+func convert_v1_Clock_To_api_Clock(source *k6tv1.Clock, clock *libvirtxml.DomainClock, c *ConverterContext) error {
+	if source == nil {
+		source = &k6tv1.Clock{}
+	}
+	if source.UTC != nil {
+		clock.Offset = ClockOffsetUTC
+		if source.UTC.OffsetSeconds != nil {
+			clock.Adjustment = strconv.Itoa(*source.UTC.OffsetSeconds)
+		} else {
+			clock.Adjustment = "reset"
+		}
+	} else if source.Timezone != nil {
+		clock.Offset = ClockOffsetTimezone
+		clock.TimeZone = string(*source.Timezone)
+	} else if conf := c.Profile.Clock; conf != nil {
+		clock.Offset = conf.Offset
+		clock.Basis = conf.Basis
+		clock.TimeZone = conf.Timezone
+		if conf.Adjustment != nil {
+			clock.Adjustment = strconv.FormatInt(*conf.Adjustment, 10)
+		}
+	}
+	err := validateClockOffset(clock)
+	if err != nil {
+		return err
+	}
+
+	if source.Timer != nil {
+		if source.Timer.RTC != nil {
+			newTimer := libvirtxml.DomainTimer{Name: "rtc"}
+			newTimer.Track = string(source.Timer.RTC.Track)
+			newTimer.TickPolicy = string(source.Timer.RTC.TickPolicy)
+			newTimer.Present = boolToYesNo(source.Timer.RTC.Enabled, true)
+			clock.Timer = append(clock.Timer, newTimer)
+		}
+		if source.Timer.PIT != nil {
+			newTimer := libvirtxml.DomainTimer{Name: "pit"}
+			newTimer.Present = boolToYesNo(source.Timer.PIT.Enabled, true)
+			newTimer.TickPolicy = string(source.Timer.PIT.TickPolicy)
+			clock.Timer = append(clock.Timer, newTimer)
+		}
+		if source.Timer.KVM != nil {
+			newTimer := libvirtxml.DomainTimer{Name: "kvmclock"}
+			newTimer.Present = boolToYesNo(source.Timer.KVM.Enabled, true)
+			clock.Timer = append(clock.Timer, newTimer)
+		}
+		if source.Timer.HPET != nil {
+			newTimer := libvirtxml.DomainTimer{Name: "hpet"}
+			newTimer.Present = boolToYesNo(source.Timer.HPET.Enabled, true)
+			newTimer.TickPolicy = string(source.Timer.HPET.TickPolicy)
+			clock.Timer = append(clock.Timer, newTimer)
+		}
+		if source.Timer.Hyperv != nil {
+			newTimer := libvirtxml.DomainTimer{Name: "hypervclock"}
+			newTimer.Present = boolToYesNo(source.Timer.Hyperv.Enabled, true)
+			clock.Timer = append(clock.Timer, newTimer)
+		}
+	}
+
+	for _, timer := range clock.Timer {
+		err := validateTimer(timer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateClockOffset checks the offset attributes go together, as libvirt wants them
+func validateClockOffset(clock *libvirtxml.DomainClock) error {
+	switch clock.Offset {
+	case "", ClockOffsetLocaltime, ClockOffsetUTC:
+		if clock.Adjustment != "" && clock.Adjustment != "reset" {
+			if _, err := strconv.ParseInt(clock.Adjustment, 10, 64); err != nil {
+				return &TranslationError{Field: "clock.adjustment", Value: clock.Adjustment, Reason: "must be a number of seconds or reset"}
+			}
+		}
+	case ClockOffsetTimezone:
+		if clock.TimeZone == "" {
+			return &TranslationError{Field: "clock.timezone", Value: clock.TimeZone, Reason: "the timezone offset needs a timezone name"}
+		}
+		if strings.ContainsAny(clock.TimeZone, " ,") {
+			return &TranslationError{Field: "clock.timezone", Value: clock.TimeZone, Reason: "not a timezone name, like Europe/Rome"}
+		}
+		if clock.Adjustment != "" {
+			return &TranslationError{Field: "clock.adjustment", Value: clock.Adjustment, Reason: "not supported with the timezone offset"}
+		}
+	case ClockOffsetVariable:
+		if clock.Basis != ClockOffsetUTC && clock.Basis != ClockOffsetLocaltime {
+			return &TranslationError{Field: "clock.basis", Value: clock.Basis, Reason: "the variable offset needs utc or localtime basis"}
+		}
+		if clock.Adjustment == "" {
+			break
+		}
+		if _, err := strconv.ParseInt(clock.Adjustment, 10, 64); err != nil {
+			return &TranslationError{Field: "clock.adjustment", Value: clock.Adjustment, Reason: "the variable offset needs a number of seconds"}
+		}
+	default:
+		return &TranslationError{Field: "clock.offset", Value: clock.Offset, Reason: "unknown offset"}
+	}
+	if clock.Offset != ClockOffsetVariable && clock.Basis != "" {
+		return &TranslationError{Field: "clock.basis", Value: clock.Basis, Reason: "only the variable offset has a basis"}
+	}
+	if clock.Offset != ClockOffsetTimezone && clock.TimeZone != "" {
+		return &TranslationError{Field: "clock.timezone", Value: clock.TimeZone, Reason: "only the timezone offset has a timezone name"}
+	}
+	return nil
+}
+
+// validateTimer checks the tick policy and the track mode against the ones the timer supports
+func validateTimer(timer libvirtxml.DomainTimer) error {
+	rules, ok := timerRules[timer.Name]
+	if !ok {
+		return &TranslationError{Field: "clock.timer", Value: timer.Name, Reason: "unknown timer"}
+	}
+	if timer.TickPolicy != "" && !containsString(rules.tickPolicies, timer.TickPolicy) {
+		return &TranslationError{
+			Field:  "clock.timer." + timer.Name + ".tickPolicy",
+			Value:  timer.TickPolicy,
+			Reason: timerSupports("tick policies", rules.tickPolicies),
+		}
+	}
+	if timer.Track != "" && !containsString(rules.tracks, timer.Track) {
+		return &TranslationError{
+			Field:  "clock.timer." + timer.Name + ".track",
+			Value:  timer.Track,
+			Reason: timerSupports("track modes", rules.tracks),
+		}
+	}
+	return nil
+}
+
+func timerSupports(what string, values []string) string {
+	if len(values) == 0 {
+		return "the timer has no " + what
+	}
+	return "the timer supports the " + what + " " + strings.Join(values, ", ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestConvertClock(t *testing.T) {
+	offset := 3600
+	adjustment := int64(-60)
+	rome := k6tv1.ClockOffsetTimezone("Europe/Rome")
+	badZone := k6tv1.ClockOffsetTimezone("Europe Rome")
+	disabled := false
+
+	tests := []struct {
+		name      string
+		source    *k6tv1.Clock
+		conf      *ClockConfig
+		want      libvirtxml.DomainClock
+		wantField string
+	}{
+		{
+			name: "no clock",
+		},
+		{
+			name:   "utc resets the clock",
+			source: &k6tv1.Clock{ClockOffset: k6tv1.ClockOffset{UTC: &k6tv1.ClockOffsetUTC{}}},
+			want:   libvirtxml.DomainClock{Offset: "utc", Adjustment: "reset"},
+		},
+		{
+			name:   "utc with an adjustment",
+			source: &k6tv1.Clock{ClockOffset: k6tv1.ClockOffset{UTC: &k6tv1.ClockOffsetUTC{OffsetSeconds: &offset}}},
+			want:   libvirtxml.DomainClock{Offset: "utc", Adjustment: "3600"},
+		},
+		{
+			name:   "timezone",
+			source: &k6tv1.Clock{ClockOffset: k6tv1.ClockOffset{Timezone: &rome}},
+			want:   libvirtxml.DomainClock{Offset: "timezone", TimeZone: "Europe/Rome"},
+		},
+		{
+			name:      "malformed timezone",
+			source:    &k6tv1.Clock{ClockOffset: k6tv1.ClockOffset{Timezone: &badZone}},
+			wantField: "clock.timezone",
+		},
+		{
+			name:   "the spec wins over the profile",
+			source: &k6tv1.Clock{ClockOffset: k6tv1.ClockOffset{Timezone: &rome}},
+			conf:   &ClockConfig{Offset: ClockOffsetLocaltime},
+			want:   libvirtxml.DomainClock{Offset: "timezone", TimeZone: "Europe/Rome"},
+		},
+		{
+			name: "variable offset from the profile",
+			conf: &ClockConfig{Offset: ClockOffsetVariable, Basis: ClockOffsetLocaltime, Adjustment: &adjustment},
+			want: libvirtxml.DomainClock{Offset: "variable", Basis: "localtime", Adjustment: "-60"},
+		},
+		{
+			name:      "variable offset without basis",
+			conf:      &ClockConfig{Offset: ClockOffsetVariable},
+			wantField: "clock.basis",
+		},
+		{
+			name:      "basis without variable offset",
+			conf:      &ClockConfig{Offset: ClockOffsetUTC, Basis: ClockOffsetUTC},
+			wantField: "clock.basis",
+		},
+		{
+			name:      "timezone offset without timezone",
+			conf:      &ClockConfig{Offset: ClockOffsetTimezone},
+			wantField: "clock.timezone",
+		},
+		{
+			name:      "timezone offset with an adjustment",
+			conf:      &ClockConfig{Offset: ClockOffsetTimezone, Timezone: "Europe/Rome", Adjustment: &adjustment},
+			wantField: "clock.adjustment",
+		},
+		{
+			name:      "timezone name without timezone offset",
+			conf:      &ClockConfig{Offset: ClockOffsetLocaltime, Timezone: "Europe/Rome"},
+			wantField: "clock.timezone",
+		},
+		{
+			name:      "unknown offset",
+			conf:      &ClockConfig{Offset: "martian"},
+			wantField: "clock.offset",
+		},
+		{
+			name: "timers",
+			source: &k6tv1.Clock{
+				ClockOffset: k6tv1.ClockOffset{UTC: &k6tv1.ClockOffsetUTC{}},
+				Timer: &k6tv1.Timer{
+					RTC:    &k6tv1.RTCTimer{TickPolicy: "catchup", Track: "guest"},
+					PIT:    &k6tv1.PITTimer{TickPolicy: "discard"},
+					KVM:    &k6tv1.KVMTimer{},
+					HPET:   &k6tv1.HPETTimer{Enabled: &disabled},
+					Hyperv: &k6tv1.HypervTimer{},
+				},
+			},
+			want: libvirtxml.DomainClock{
+				Offset:     "utc",
+				Adjustment: "reset",
+				Timer: []libvirtxml.DomainTimer{
+					{Name: "rtc", TickPolicy: "catchup", Track: "guest", Present: "yes"},
+					{Name: "pit", TickPolicy: "discard", Present: "yes"},
+					{Name: "kvmclock", Present: "yes"},
+					{Name: "hpet", Present: "no"},
+					{Name: "hypervclock", Present: "yes"},
+				},
+			},
+		},
+		{
+			name: "invalid rtc tick policy",
+			source: &k6tv1.Clock{
+				ClockOffset: k6tv1.ClockOffset{UTC: &k6tv1.ClockOffsetUTC{}},
+				Timer:       &k6tv1.Timer{RTC: &k6tv1.RTCTimer{TickPolicy: "merge"}},
+			},
+			wantField: "clock.timer.rtc.tickPolicy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &libvirtxml.DomainClock{}
+			err := convert_v1_Clock_To_api_Clock(tt.source, clock, newTestContext(&ProfileSpec{Clock: tt.conf}))
+			if tt.wantField != "" {
+				terr, ok := err.(*TranslationError)
+				if !ok {
+					t.Fatalf("got error %v, want a translation error", err)
+				}
+				if terr.Field != tt.wantField {
+					t.Errorf("got error on %s, want on %s", terr.Field, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if clock.Offset != tt.want.Offset || clock.Basis != tt.want.Basis ||
+				clock.Adjustment != tt.want.Adjustment || clock.TimeZone != tt.want.TimeZone {
+				t.Errorf("got clock %+v, want %+v", clock, tt.want)
+			}
+			if len(clock.Timer) != len(tt.want.Timer) {
+				t.Fatalf("got timers %+v, want %+v", clock.Timer, tt.want.Timer)
+			}
+			for i := range clock.Timer {
+				got, want := clock.Timer[i], tt.want.Timer[i]
+				if got.Name != want.Name || got.Present != want.Present ||
+					got.TickPolicy != want.TickPolicy || got.Track != want.Track {
+					t.Errorf("got timer %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTimer(t *testing.T) {
+	tests := []struct {
+		name      string
+		timer     libvirtxml.DomainTimer
+		wantField string
+	}{
+		{
+			name:  "rtc catchup with wall track",
+			timer: libvirtxml.DomainTimer{Name: "rtc", TickPolicy: "catchup", Track: "wall"},
+		},
+		{
+			name:      "rtc discard",
+			timer:     libvirtxml.DomainTimer{Name: "rtc", TickPolicy: "discard"},
+			wantField: "clock.timer.rtc.tickPolicy",
+		},
+		{
+			name:      "rtc boot track",
+			timer:     libvirtxml.DomainTimer{Name: "rtc", Track: "boot"},
+			wantField: "clock.timer.rtc.track",
+		},
+		{
+			name:  "pit discard",
+			timer: libvirtxml.DomainTimer{Name: "pit", TickPolicy: "discard"},
+		},
+		{
+			name:      "pit merge",
+			timer:     libvirtxml.DomainTimer{Name: "pit", TickPolicy: "merge"},
+			wantField: "clock.timer.pit.tickPolicy",
+		},
+		{
+			name:      "pit track",
+			timer:     libvirtxml.DomainTimer{Name: "pit", Track: "guest"},
+			wantField: "clock.timer.pit.track",
+		},
+		{
+			name:  "hpet delay",
+			timer: libvirtxml.DomainTimer{Name: "hpet", TickPolicy: "delay"},
+		},
+		{
+			name:  "hpet catchup",
+			timer: libvirtxml.DomainTimer{Name: "hpet", TickPolicy: "catchup"},
+		},
+		{
+			name:  "hpet merge",
+			timer: libvirtxml.DomainTimer{Name: "hpet", TickPolicy: "merge"},
+		},
+		{
+			name:  "hpet discard",
+			timer: libvirtxml.DomainTimer{Name: "hpet", TickPolicy: "discard"},
+		},
+		{
+			name:  "kvmclock present",
+			timer: libvirtxml.DomainTimer{Name: "kvmclock", Present: "yes"},
+		},
+		{
+			name:      "kvmclock catchup",
+			timer:     libvirtxml.DomainTimer{Name: "kvmclock", TickPolicy: "catchup"},
+			wantField: "clock.timer.kvmclock.tickPolicy",
+		},
+		{
+			name:      "hypervclock delay",
+			timer:     libvirtxml.DomainTimer{Name: "hypervclock", TickPolicy: "delay"},
+			wantField: "clock.timer.hypervclock.tickPolicy",
+		},
+		{
+			name:      "unknown timer",
+			timer:     libvirtxml.DomainTimer{Name: "sundial"},
+			wantField: "clock.timer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTimer(tt.timer)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			terr, ok := err.(*TranslationError)
+			if !ok {
+				t.Fatalf("got error %v, want a translation error", err)
+			}
+			if terr.Field != tt.wantField {
+				t.Errorf("got error on %s, want on %s", terr.Field, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// convert_v1_CPU_To_api_Topology sets the vCPU count and topology. The spec gives the vCPU count as cores,
+// the profile may split them in sockets and threads, or give the full topology when the spec has none.
+func convert_v1_CPU_To_api_Topology(source *k6tv1.CPU, domain *libvirtxml.Domain, c *ConverterContext) error {
+	vcpus := 0
+	if source != nil {
+		vcpus = int(source.Cores)
+	}
+
+	conf := c.Profile.CPU
+	if conf == nil {
+		conf = &CPUConfig{}
+	}
+	sockets := int(conf.Sockets)
+	if sockets == 0 {
+		sockets = 1
+	}
+	threads := int(conf.Threads)
+	if threads == 0 {
+		threads = 1
+	}
+	cores := int(conf.Cores)
+
+	switch {
+	case vcpus == 0 && cores == 0:
+		return nil
+	case vcpus == 0:
+		vcpus = sockets * cores * threads
+	case cores == 0:
+		if vcpus%(sockets*threads) != 0 {
+			return fmt.Errorf("%d vCPUs can't be split in %d sockets with %d threads each", vcpus, sockets, threads)
+		}
+		cores = vcpus / (sockets * threads)
+	case sockets*cores*threads != vcpus:
+		return fmt.Errorf("the profile CPU topology (%d sockets, %d cores, %d threads) doesn't match %d vCPUs", sockets, cores, threads, vcpus)
+	}
+
+	domain.CPU.Topology = &libvirtxml.DomainCPUTopology{
+		Sockets: sockets,
+		Cores:   cores,
+		Threads: threads,
+	}
+	domain.VCPU = &libvirtxml.DomainVCPU{
+		Placement: "static",
+		Value:     vcpus,
+	}
+	return nil
+}
+
+// completeCPUPinning pins the vCPUs of the dedicated CPU profiles one to one on the host CPU set,
+// then pins the emulator and the IOThreads not pinned yet on the emulator CPU set.
+func completeCPUPinning(dom *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.CPU
+	if conf == nil || (!conf.Dedicated && conf.Realtime == nil) {
+		return nil
+	}
+	if dom.VCPU == nil || dom.VCPU.Value == 0 {
+		return fmt.Errorf("dedicated CPUs need an explicit vCPU count")
+	}
+	vcpus := dom.VCPU.Value
+	if dom.CPUTune == nil {
+		dom.CPUTune = &libvirtxml.DomainCPUTune{}
+	}
+
+	if conf.Dedicated {
+		if c.Host == nil || c.Host.CPUSet == "" {
+			return fmt.Errorf("dedicated CPUs need the host CPU set")
+		}
+		hostCPUs, err := ParseCPUSet(c.Host.CPUSet)
+		if err != nil {
+			return err
+		}
+		if len(hostCPUs) < vcpus {
+			return fmt.Errorf("%d vCPUs don't fit in the host CPU set %s", vcpus, c.Host.CPUSet)
+		}
+
+		dom.CPUTune.VCPUPin = []libvirtxml.DomainCPUTuneVCPUPin{}
+		for vcpu := 0; vcpu < vcpus; vcpu++ {
+			dom.CPUTune.VCPUPin = append(dom.CPUTune.VCPUPin, libvirtxml.DomainCPUTuneVCPUPin{
+				VCPU:   uint(vcpu),
+				CPUSet: strconv.Itoa(int(hostCPUs[vcpu])),
+			})
+		}
+
+		emulatorSet := conf.EmulatorCPUSet
+		if emulatorSet == "" && len(hostCPUs) > vcpus {
+			emulatorSet = FormatCPUSet(hostCPUs[vcpus:])
+		}
+		if emulatorSet == "" {
+			c.warn("No CPU left for the emulator threads, sharing the vCPU ones")
+			emulatorSet = c.Host.CPUSet
+		}
+		dom.CPUTune.EmulatorPin = &libvirtxml.DomainCPUTuneEmulatorPin{
+			CPUSet: emulatorSet,
+		}
+
+		pinned := map[uint]bool{}
+		for _, pin := range dom.CPUTune.IOThreadPin {
+			pinned[pin.IOThread] = true
+		}
+		for id := uint(1); id <= dom.IOThreads; id++ {
+			if pinned[id] {
+				continue
+			}
+			dom.CPUTune.IOThreadPin = append(dom.CPUTune.IOThreadPin, libvirtxml.DomainCPUTuneIOThreadPin{
+				IOThread: id,
+				CPUSet:   emulatorSet,
+			})
+		}
+	}
+
+	if conf.Realtime != nil {
+		if !conf.Dedicated {
+			c.warn("Realtime vCPUs without dedicated CPUs will compete with the host tasks")
+		}
+		if dom.MemoryBacking == nil || dom.MemoryBacking.MemoryLocked == nil {
+			c.warn("Realtime vCPUs should use locked memory")
+		}
+		rtVCPUs := conf.Realtime.VCPUs
+		if rtVCPUs == "" {
+			rtVCPUs = fmt.Sprintf("0-%d", vcpus-1)
+		}
+		scheduler := conf.Realtime.Scheduler
+		if scheduler == "" {
+			scheduler = "fifo"
+		}
+		priority := int(conf.Realtime.Priority)
+		if priority == 0 {
+			priority = 1
+		}
+		dom.CPUTune.VCPUSched = []libvirtxml.DomainCPUTuneVCPUSched{
+			{
+				VCPUs:     rtVCPUs,
+				Scheduler: scheduler,
+				Priority:  &priority,
+			},
+		}
+	}
+	return nil
+}
+
+// maxCPUSetSize bounds the ids in a CPU set: it is the largest number of CPUs a Linux kernel supports
+const maxCPUSetSize = 8192
+
+// ParseCPUSet parses a CPU set in the libvirt/cgroups syntax, like "0-3,8,10-11", sorted and without duplicates
+func ParseCPUSet(cpuset string) ([]uint, error) {
+	seen := map[uint]bool{}
+	for _, part := range strings.Split(cpuset, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed CPU set %s", cpuset)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.ParseUint(bounds[1], 10, 32)
+			if err != nil || last < first {
+				return nil, fmt.Errorf("malformed CPU set %s", cpuset)
+			}
+		}
+		if last >= maxCPUSetSize {
+			return nil, fmt.Errorf("CPU set %s exceeds the CPU %d", cpuset, maxCPUSetSize-1)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			seen[uint(cpu)] = true
+		}
+	}
+	cpus := []uint{}
+	for cpu := range seen {
+		cpus = append(cpus, cpu)
+	}
+	sort.Slice(cpus, func(i, j int) bool { return cpus[i] < cpus[j] })
+	return cpus, nil
+}
+
+// FormatCPUSet formats a sorted list of CPUs, or NUMA nodes, in the libvirt/cgroups syntax
+func FormatCPUSet(cpus []uint) string {
+	parts := []string{}
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(int(cpus[i])))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,356 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestParseCPUSet(t *testing.T) {
+	tests := []struct {
+		cpuset  string
+		want    []uint
+		wantErr bool
+	}{
+		{cpuset: "", want: []uint{}},
+		{cpuset: "3", want: []uint{3}},
+		{cpuset: "0-3,8,10-11", want: []uint{0, 1, 2, 3, 8, 10, 11}},
+		{cpuset: "8, 2-3 ,3", want: []uint{2, 3, 8}},
+		{cpuset: "3-1", wantErr: true},
+		{cpuset: "a-b", wantErr: true},
+		{cpuset: "-1", wantErr: true},
+		{cpuset: "8191", want: []uint{8191}},
+		{cpuset: "8192", wantErr: true},
+		{cpuset: "0-4294967295", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCPUSet(tt.cpuset)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.cpuset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.cpuset, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.cpuset, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCPUSet(t *testing.T) {
+	tests := []struct {
+		cpus []uint
+		want string
+	}{
+		{cpus: []uint{}, want: ""},
+		{cpus: []uint{5}, want: "5"},
+		{cpus: []uint{0, 1, 2, 3, 8, 10, 11}, want: "0-3,8,10-11"},
+		{cpus: []uint{1, 3, 5}, want: "1,3,5"},
+	}
+	for _, tt := range tests {
+		if got := FormatCPUSet(tt.cpus); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.cpus, got, tt.want)
+		}
+	}
+}
+
+func TestConvertCPUTopology(t *testing.T) {
+	tests := []struct {
+		name      string
+		cores     uint32
+		conf      *CPUConfig
+		wantVCPUs int
+		wantTopo  *libvirtxml.DomainCPUTopology
+		wantErr   bool
+	}{
+		{
+			name: "no vCPUs",
+		},
+		{
+			name:      "cores from the spec",
+			cores:     4,
+			wantVCPUs: 4,
+			wantTopo:  &libvirtxml.DomainCPUTopology{Sockets: 1, Cores: 4, Threads: 1},
+		},
+		{
+			name:      "spec vCPUs split by the profile",
+			cores:     8,
+			conf:      &CPUConfig{Sockets: 2, Threads: 2},
+			wantVCPUs: 8,
+			wantTopo:  &libvirtxml.DomainCPUTopology{Sockets: 2, Cores: 2, Threads: 2},
+		},
+		{
+			name:      "full topology from the profile",
+			conf:      &CPUConfig{Sockets: 2, Cores: 3, Threads: 2},
+			wantVCPUs: 12,
+			wantTopo:  &libvirtxml.DomainCPUTopology{Sockets: 2, Cores: 3, Threads: 2},
+		},
+		{
+			name:      "matching topology",
+			cores:     4,
+			conf:      &CPUConfig{Sockets: 2, Cores: 2},
+			wantVCPUs: 4,
+			wantTopo:  &libvirtxml.DomainCPUTopology{Sockets: 2, Cores: 2, Threads: 1},
+		},
+		{
+			name:    "vCPUs which can't be split",
+			cores:   6,
+			conf:    &CPUConfig{Sockets: 4},
+			wantErr: true,
+		},
+		{
+			name:    "mismatching topology",
+			cores:   4,
+			conf:    &CPUConfig{Sockets: 2, Cores: 4},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{CPU: tt.conf})
+			domain := &libvirtxml.Domain{CPU: &libvirtxml.DomainCPU{}}
+			var source *k6tv1.CPU
+			if tt.cores > 0 {
+				source = &k6tv1.CPU{Cores: tt.cores}
+			}
+			err := convert_v1_CPU_To_api_Topology(source, domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(domain.CPU.Topology, tt.wantTopo) {
+				t.Errorf("got topology %+v, want %+v", domain.CPU.Topology, tt.wantTopo)
+			}
+			vcpus := 0
+			if domain.VCPU != nil {
+				vcpus = domain.VCPU.Value
+			}
+			if vcpus != tt.wantVCPUs {
+				t.Errorf("got %d vCPUs, want %d", vcpus, tt.wantVCPUs)
+			}
+		})
+	}
+}
+
+func TestCompleteCPUPinning(t *testing.T) {
+	tests := []struct {
+		name         string
+		vcpus        int
+		iothreads    uint
+		hostCPUSet   string
+		conf         *CPUConfig
+		wantVCPUPins []string
+		wantEmulator string
+		wantIOPins   []string
+		wantSched    *libvirtxml.DomainCPUTuneVCPUSched
+		wantWarning  string
+		wantErr      bool
+	}{
+		{
+			name:  "not dedicated",
+			vcpus: 2,
+		},
+		{
+			name:         "dedicated",
+			vcpus:        2,
+			iothreads:    1,
+			hostCPUSet:   "2-5",
+			conf:         &CPUConfig{Dedicated: true},
+			wantVCPUPins: []string{"2", "3"},
+			wantEmulator: "4-5",
+			wantIOPins:   []string{"4-5"},
+		},
+		{
+			name:         "dedicated with the emulator CPU set",
+			vcpus:        2,
+			hostCPUSet:   "2-5",
+			conf:         &CPUConfig{Dedicated: true, EmulatorCPUSet: "0"},
+			wantVCPUPins: []string{"2", "3"},
+			wantEmulator: "0",
+			wantIOPins:   []string{},
+		},
+		{
+			name:         "no CPU left for the emulator",
+			vcpus:        2,
+			hostCPUSet:   "2,4",
+			conf:         &CPUConfig{Dedicated: true},
+			wantVCPUPins: []string{"2", "4"},
+			wantEmulator: "2,4",
+			wantIOPins:   []string{},
+			wantWarning:  "No CPU left for the emulator",
+		},
+		{
+			name:       "too many vCPUs",
+			vcpus:      4,
+			hostCPUSet: "2-3",
+			conf:       &CPUConfig{Dedicated: true},
+			wantErr:    true,
+		},
+		{
+			name:    "unknown host CPU set",
+			vcpus:   2,
+			conf:    &CPUConfig{Dedicated: true},
+			wantErr: true,
+		},
+		{
+			name:    "no vCPU count",
+			conf:    &CPUConfig{Dedicated: true},
+			wantErr: true,
+		},
+		{
+			name:         "realtime",
+			vcpus:        2,
+			hostCPUSet:   "2-5",
+			conf:         &CPUConfig{Dedicated: true, Realtime: &RealtimeConfig{}},
+			wantVCPUPins: []string{"2", "3"},
+			wantEmulator: "4-5",
+			wantIOPins:   []string{},
+			wantSched:    &libvirtxml.DomainCPUTuneVCPUSched{VCPUs: "0-1", Scheduler: "fifo"},
+			wantWarning:  "should use locked memory",
+		},
+		{
+			name:        "realtime without dedicated CPUs",
+			vcpus:       4,
+			conf:        &CPUConfig{Realtime: &RealtimeConfig{VCPUs: "2-3", Scheduler: "rr", Priority: 10}},
+			wantSched:   &libvirtxml.DomainCPUTuneVCPUSched{VCPUs: "2-3", Scheduler: "rr"},
+			wantWarning: "compete with the host tasks",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{CPU: tt.conf})
+			c.Host.CPUSet = tt.hostCPUSet
+			dom := &libvirtxml.Domain{IOThreads: tt.iothreads}
+			if tt.vcpus > 0 {
+				dom.VCPU = &libvirtxml.DomainVCPU{Value: tt.vcpus}
+			}
+			err := completeCPUPinning(dom, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.conf == nil {
+				if dom.CPUTune != nil {
+					t.Errorf("unexpected CPU tuning %+v", dom.CPUTune)
+				}
+				return
+			}
+
+			if tt.wantVCPUPins != nil {
+				pins := []string{}
+				for i, pin := range dom.CPUTune.VCPUPin {
+					if pin.VCPU != uint(i) {
+						t.Errorf("got pin %d for vCPU %d", i, pin.VCPU)
+					}
+					pins = append(pins, pin.CPUSet)
+				}
+				if !reflect.DeepEqual(pins, tt.wantVCPUPins) {
+					t.Errorf("got vCPU pins %v, want %v", pins, tt.wantVCPUPins)
+				}
+				if dom.CPUTune.EmulatorPin == nil || dom.CPUTune.EmulatorPin.CPUSet != tt.wantEmulator {
+					t.Errorf("got emulator pin %+v, want %s", dom.CPUTune.EmulatorPin, tt.wantEmulator)
+				}
+				ioPins := []string{}
+				for _, pin := range dom.CPUTune.IOThreadPin {
+					ioPins = append(ioPins, pin.CPUSet)
+				}
+				if !reflect.DeepEqual(ioPins, tt.wantIOPins) {
+					t.Errorf("got IOThread pins %v, want %v", ioPins, tt.wantIOPins)
+				}
+			}
+
+			if tt.wantSched != nil {
+				if len(dom.CPUTune.VCPUSched) != 1 {
+					t.Fatalf("got %d vCPU schedulers, want 1", len(dom.CPUTune.VCPUSched))
+				}
+				sched := dom.CPUTune.VCPUSched[0]
+				if sched.VCPUs != tt.wantSched.VCPUs || sched.Scheduler != tt.wantSched.Scheduler {
+					t.Errorf("got scheduler %s for vCPUs %s, want %s for %s", sched.Scheduler, sched.VCPUs, tt.wantSched.Scheduler, tt.wantSched.VCPUs)
+				}
+				priority := 1
+				if tt.conf.Realtime.Priority > 0 {
+					priority = int(tt.conf.Realtime.Priority)
+				}
+				if sched.Priority == nil || *sched.Priority != priority {
+					t.Errorf("got priority %v, want %d", sched.Priority, priority)
+				}
+			}
+
+			if tt.wantWarning == "" {
+				if len(c.Warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCompleteWithHostCPUSet(t *testing.T) {
+	host := DefaultHost()
+	host.CPUSet = "0-1"
+	p := newTestProfiler().SetHost(host).AddProfile(&Profile{Spec: ProfileSpec{CPU: &CPUConfig{Dedicated: true}}})
+
+	tests := []struct {
+		name       string
+		hostCPUSet string
+		want       []string
+	}{
+		{name: "host CPU set", want: []string{"0", "1"}},
+		{name: "given CPU set", hostCPUSet: "4-5", want: []string{"4", "5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dom := &libvirtxml.Domain{VCPU: &libvirtxml.DomainVCPU{Value: 2}}
+			completed, _, err := p.CompleteWithHostCPUSet(dom, tt.hostCPUSet)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			pins := []string{}
+			for _, pin := range completed.CPUTune.VCPUPin {
+				pins = append(pins, pin.CPUSet)
+			}
+			if !reflect.DeepEqual(pins, tt.want) {
+				t.Errorf("got vCPU pins %v, want %v", pins, tt.want)
+			}
+		})
+	}
+	if host.CPUSet != "0-1" {
+		t.Errorf("the host CPU set was changed to %s", host.CPUSet)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"path/filepath"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// DefaultRNGBackend is the host device feeding the virtio-rng devices
+const DefaultRNGBackend = "/dev/urandom"
+
+var (
+	inputTypes = []string{"tablet", "keyboard", "mouse"}
+	inputBuses = []string{"usb", "virtio"}
+)
+
+// convert_api_RNG_To_api_RNGs adds the virtio-rng device the profile asks for
+func convert_api_RNG_To_api_RNGs(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.RNG
+	if conf == nil {
+		return nil
+	}
+	backend := conf.Backend
+	if backend == "" {
+		backend = DefaultRNGBackend
+	}
+	if !filepath.IsAbs(backend) {
+		return fmt.Errorf("the RNG backend must be a host device path, found %s", backend)
+	}
+	rng := libvirtxml.DomainRNG{
+		Model: "virtio",
+		Backend: &libvirtxml.DomainRNGBackend{
+			Random: &libvirtxml.DomainRNGBackendRandom{
+				Device: backend,
+			},
+		},
+	}
+	if conf.RateBytes > 0 {
+		rng.Rate = &libvirtxml.DomainRNGRate{
+			Bytes:  conf.RateBytes,
+			Period: conf.RatePeriod,
+		}
+	} else if conf.RatePeriod > 0 {
+		return fmt.Errorf("the RNG rate period needs the rate bytes")
+	}
+	domain.Devices.RNGs = append(domain.Devices.RNGs, rng)
+	return nil
+}
+
+// convert_api_Balloon_To_api_MemBalloon sets the memory balloon; without a profile libvirt adds its default one
+func convert_api_Balloon_To_api_MemBalloon(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.Balloon
+	if conf == nil {
+		return nil
+	}
+	model := conf.Model
+	if model == "" {
+		model = "virtio"
+	}
+	switch model {
+	case "none":
+		if conf.Autodeflate != nil || conf.StatsPeriod > 0 {
+			c.warn("Ignoring the memory balloon settings, the balloon is disabled")
+		}
+		domain.Devices.MemBalloon = &libvirtxml.DomainMemBalloon{
+			Model: model,
+		}
+	case "virtio":
+		balloon := &libvirtxml.DomainMemBalloon{
+			Model: model,
+		}
+		if conf.Autodeflate != nil {
+			balloon.AutoDeflate = boolToOnOff(conf.Autodeflate, false)
+		}
+		if conf.StatsPeriod > 0 {
+			balloon.Stats = &libvirtxml.DomainMemBalloonStats{
+				Period: conf.StatsPeriod,
+			}
+		}
+		domain.Devices.MemBalloon = balloon
+	default:
+		return fmt.Errorf("unknown memory balloon model %s", model)
+	}
+	return nil
+}
+
+// convert_api_Inputs_To_api_Inputs adds the input devices of the profile. The PS/2 keyboard and mouse of
+// the x86 machines are always there; the USB controller can be dropped when no USB device needs it.
+func convert_api_Inputs_To_api_Inputs(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.Inputs
+	if conf == nil {
+		return nil
+	}
+	for _, input := range conf.Devices {
+		if !containsString(inputTypes, input.Type) {
+			return fmt.Errorf("unknown input type %s", input.Type)
+		}
+		bus := input.Bus
+		if bus == "" {
+			bus = "usb"
+		}
+		if !containsString(inputBuses, bus) {
+			return fmt.Errorf("unknown bus %s for the %s input", bus, input.Type)
+		}
+		if bus == "usb" && conf.DisableUSB {
+			return fmt.Errorf("the USB %s needs the USB controller", input.Type)
+		}
+		domain.Devices.Inputs = append(domain.Devices.Inputs, libvirtxml.DomainInput{
+			Type: input.Type,
+			Bus:  bus,
+		})
+	}
+	if conf.DisableUSB {
+		index := uint(0)
+		domain.Devices.Controllers = append(domain.Devices.Controllers, libvirtxml.DomainController{
+			Type:  "usb",
+			Index: &index,
+			Model: "none",
+		})
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestConvertRNG(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        *RNGConfig
+		wantBackend string
+		wantRate    libvirtxml.DomainRNGRate
+		wantErr     bool
+	}{
+		{
+			name: "no RNG profile",
+		},
+		{
+			name:        "default backend",
+			conf:        &RNGConfig{},
+			wantBackend: DefaultRNGBackend,
+		},
+		{
+			name:        "hardware backend with a rate limit",
+			conf:        &RNGConfig{Backend: "/dev/hwrng", RateBytes: 1024, RatePeriod: 2000},
+			wantBackend: "/dev/hwrng",
+			wantRate:    libvirtxml.DomainRNGRate{Bytes: 1024, Period: 2000},
+		},
+		{
+			name:    "relative backend",
+			conf:    &RNGConfig{Backend: "urandom"},
+			wantErr: true,
+		},
+		{
+			name:    "rate period without bytes",
+			conf:    &RNGConfig{RatePeriod: 1000},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+			err := convert_api_RNG_To_api_RNGs(domain, newTestContext(&ProfileSpec{RNG: tt.conf}))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantBackend == "" {
+				if len(domain.Devices.RNGs) > 0 {
+					t.Errorf("got RNGs %+v, want none", domain.Devices.RNGs)
+				}
+				return
+			}
+			if len(domain.Devices.RNGs) != 1 {
+				t.Fatalf("got RNGs %+v, want one", domain.Devices.RNGs)
+			}
+			rng := domain.Devices.RNGs[0]
+			if rng.Model != "virtio" || rng.Backend.Random.Device != tt.wantBackend {
+				t.Errorf("got RNG %s fed by %s, want virtio fed by %s", rng.Model, rng.Backend.Random.Device, tt.wantBackend)
+			}
+			var bytes, period uint
+			if rng.Rate != nil {
+				bytes, period = rng.Rate.Bytes, rng.Rate.Period
+			}
+			if bytes != tt.wantRate.Bytes || period != tt.wantRate.Period {
+				t.Errorf("got rate %+v, want %+v", rng.Rate, tt.wantRate)
+			}
+		})
+	}
+}
+
+func TestConvertBalloon(t *testing.T) {
+	yes := true
+	tests := []struct {
+		name            string
+		conf            *BalloonConfig
+		wantModel       string
+		wantAutodeflate string
+		wantStatsPeriod uint
+		wantWarning     string
+		wantErr         bool
+	}{
+		{
+			name: "no balloon profile",
+		},
+		{
+			name:      "virtio by default",
+			conf:      &BalloonConfig{},
+			wantModel: "virtio",
+		},
+		{
+			name:            "autodeflate and stats",
+			conf:            &BalloonConfig{Autodeflate: &yes, StatsPeriod: 10},
+			wantModel:       "virtio",
+			wantAutodeflate: "on",
+			wantStatsPeriod: 10,
+		},
+		{
+			name:      "no balloon",
+			conf:      &BalloonConfig{Model: "none"},
+			wantModel: "none",
+		},
+		{
+			name:        "settings of the disabled balloon",
+			conf:        &BalloonConfig{Model: "none", StatsPeriod: 10},
+			wantModel:   "none",
+			wantWarning: "the balloon is disabled",
+		},
+		{
+			name:    "unknown model",
+			conf:    &BalloonConfig{Model: "xen"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+			c := newTestContext(&ProfileSpec{Balloon: tt.conf})
+			err := convert_api_Balloon_To_api_MemBalloon(domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) > 0 {
+					t.Errorf("unexpected warnings %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+
+			balloon := domain.Devices.MemBalloon
+			if tt.wantModel == "" {
+				if balloon != nil {
+					t.Errorf("got balloon %+v, want libvirt's default", balloon)
+				}
+				return
+			}
+			if balloon == nil || balloon.Model != tt.wantModel || balloon.AutoDeflate != tt.wantAutodeflate {
+				t.Fatalf("got balloon %+v, want %s with autodeflate %q", balloon, tt.wantModel, tt.wantAutodeflate)
+			}
+			period := uint(0)
+			if balloon.Stats != nil {
+				period = balloon.Stats.Period
+			}
+			if period != tt.wantStatsPeriod {
+				t.Errorf("got stats period %d, want %d", period, tt.wantStatsPeriod)
+			}
+		})
+	}
+}
+
+func TestConvertInputs(t *testing.T) {
+	tests := []struct {
+		name       string
+		conf       *InputsConfig
+		wantInputs []libvirtxml.DomainInput
+		wantNoUSB  bool
+		wantErr    bool
+	}{
+		{
+			name: "no inputs profile",
+		},
+		{
+			name: "USB tablet by default",
+			conf: &InputsConfig{Devices: []InputDevice{{Type: "tablet"}}},
+			wantInputs: []libvirtxml.DomainInput{
+				{Type: "tablet", Bus: "usb"},
+			},
+		},
+		{
+			name: "virtio inputs without USB",
+			conf: &InputsConfig{
+				Devices:    []InputDevice{{Type: "keyboard", Bus: "virtio"}, {Type: "mouse", Bus: "virtio"}},
+				DisableUSB: true,
+			},
+			wantInputs: []libvirtxml.DomainInput{
+				{Type: "keyboard", Bus: "virtio"},
+				{Type: "mouse", Bus: "virtio"},
+			},
+			wantNoUSB: true,
+		},
+		{
+			name:      "no USB and no inputs",
+			conf:      &InputsConfig{DisableUSB: true},
+			wantNoUSB: true,
+		},
+		{
+			name:    "USB input without USB",
+			conf:    &InputsConfig{Devices: []InputDevice{{Type: "tablet"}}, DisableUSB: true},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			conf:    &InputsConfig{Devices: []InputDevice{{Type: "joystick"}}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown bus",
+			conf:    &InputsConfig{Devices: []InputDevice{{Type: "mouse", Bus: "ps2"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+			err := convert_api_Inputs_To_api_Inputs(domain, newTestContext(&ProfileSpec{Inputs: tt.conf}))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			inputs := domain.Devices.Inputs
+			if len(inputs) != len(tt.wantInputs) {
+				t.Fatalf("got inputs %+v, want %+v", inputs, tt.wantInputs)
+			}
+			for i := range inputs {
+				if inputs[i].Type != tt.wantInputs[i].Type || inputs[i].Bus != tt.wantInputs[i].Bus {
+					t.Errorf("got input %+v, want %+v", inputs[i], tt.wantInputs[i])
+				}
+			}
+
+			noUSB := false
+			for _, controller := range domain.Devices.Controllers {
+				if controller.Type == "usb" && controller.Model == "none" {
+					noUSB = true
+				}
+			}
+			if noUSB != tt.wantNoUSB {
+				t.Errorf("got the USB controller disabled: %v, want %v", noUSB, tt.wantNoUSB)
+			}
+		})
+	}
+}
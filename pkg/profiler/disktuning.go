@@ -0,0 +1,177 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"strconv"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+var diskTuningValues = []struct {
+	field  string
+	values []string
+	get    func(t *DiskTuning) string
+}{
+	{"cache", []string{"default", "none", "writethrough", "writeback", "directsync", "unsafe"}, func(t *DiskTuning) string { return t.Cache }},
+	{"io", []string{"native", "threads", "io_uring"}, func(t *DiskTuning) string { return t.IO }},
+	{"discard", []string{"unmap", "ignore"}, func(t *DiskTuning) string { return t.Discard }},
+	{"detectZeroes", []string{"off", "on", "unmap"}, func(t *DiskTuning) string { return t.DetectZeroes }},
+	{"errorPolicy", []string{"stop", "report", "ignore", "enospace"}, func(t *DiskTuning) string { return t.ErrorPolicy }},
+}
+
+// volumeSourceType returns the name of the volume source, as in the VMI spec, like "persistentVolumeClaim"
+func volumeSourceType(volume *k6tv1.Volume) string {
+	switch {
+	case volume.ContainerDisk != nil:
+		return "containerDisk"
+	case volume.CloudInitNoCloud != nil:
+		return "cloudInitNoCloud"
+	case volume.PersistentVolumeClaim != nil:
+		return "persistentVolumeClaim"
+	case volume.Ephemeral != nil:
+		return "ephemeral"
+	case volume.EmptyDisk != nil:
+		return "emptyDisk"
+	case volume.DataVolume != nil:
+		return "dataVolume"
+	case volume.HostDisk != nil:
+		return "hostDisk"
+	case volume.ConfigMap != nil:
+		return "configMap"
+	case volume.Secret != nil:
+		return "secret"
+	case volume.ServiceAccount != nil:
+		return "serviceAccount"
+	}
+	return ""
+}
+
+// convert_api_DiskTuning_To_api_Disk applies the disk tuning of the profile. The settings for the disk bus
+// override the defaults, the ones for the volume source override the bus ones, and the ones for the disk
+// name override everything else.
+func convert_api_DiskTuning_To_api_Disk(volume *k6tv1.Volume, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	conf := c.Profile.Disks
+	if conf == nil {
+		return nil
+	}
+	tuning := DiskTuning{}
+	if conf.Defaults != nil {
+		tuning.merge(conf.Defaults)
+	}
+	if disk.Target != nil {
+		if busTuning, ok := conf.Buses[disk.Target.Bus]; ok {
+			tuning.merge(&busTuning)
+		}
+	}
+	if sourceTuning, ok := conf.Sources[volumeSourceType(volume)]; ok {
+		tuning.merge(&sourceTuning)
+	}
+	if diskTuning, ok := conf.Disks[disk.Alias.Name]; ok {
+		tuning.merge(&diskTuning)
+	}
+
+	err := tuning.validate(disk.Alias.Name)
+	if err != nil {
+		return err
+	}
+
+	disk.Driver.Cache = tuning.Cache
+	disk.Driver.IO = tuning.IO
+	disk.Driver.Discard = tuning.Discard
+	disk.Driver.DetectZeros = tuning.DetectZeroes
+	disk.Driver.ErrorPolicy = tuning.ErrorPolicy
+	if tuning.CopyOnRead != nil {
+		if *tuning.CopyOnRead && disk.ReadOnly != nil {
+			c.warn("Copy on read has no effect on the read-only disk %s", disk.Alias.Name)
+		}
+		disk.Driver.CopyOnRead = boolToOnOff(tuning.CopyOnRead, false)
+	}
+	if tuning.IOTune != nil {
+		disk.IOTune = &libvirtxml.DomainDiskIOTune{
+			TotalBytesSec: tuning.IOTune.TotalBytesSec,
+			ReadBytesSec:  tuning.IOTune.ReadBytesSec,
+			WriteBytesSec: tuning.IOTune.WriteBytesSec,
+			TotalIopsSec:  tuning.IOTune.TotalIOPSSec,
+			ReadIopsSec:   tuning.IOTune.ReadIOPSSec,
+			WriteIopsSec:  tuning.IOTune.WriteIOPSSec,
+		}
+	}
+	return nil
+}
+
+// merge overrides the settings with the ones set in other
+func (t *DiskTuning) merge(other *DiskTuning) {
+	if other.Cache != "" {
+		t.Cache = other.Cache
+	}
+	if other.IO != "" {
+		t.IO = other.IO
+	}
+	if other.Discard != "" {
+		t.Discard = other.Discard
+	}
+	if other.DetectZeroes != "" {
+		t.DetectZeroes = other.DetectZeroes
+	}
+	if other.ErrorPolicy != "" {
+		t.ErrorPolicy = other.ErrorPolicy
+	}
+	if other.CopyOnRead != nil {
+		t.CopyOnRead = other.CopyOnRead
+	}
+	if other.IOTune != nil {
+		t.IOTune = other.IOTune
+	}
+}
+
+// validate checks the values and the combinations QEMU refuses
+func (t *DiskTuning) validate(diskName string) error {
+	field := func(name string) string {
+		return "disks." + diskName + "." + name
+	}
+	for _, setting := range diskTuningValues {
+		value := setting.get(t)
+		if value != "" && !containsString(setting.values, value) {
+			return &TranslationError{Field: field(setting.field), Value: value, Reason: "unknown value"}
+		}
+	}
+	// native AIO needs O_DIRECT, so a cache mode bypassing the host page cache
+	if t.IO == "native" && t.Cache != "none" && t.Cache != "directsync" {
+		return &TranslationError{Field: field("io"), Value: t.IO, Reason: "needs the none or directsync cache, found " + valueOrDefault(t.Cache)}
+	}
+	if t.DetectZeroes == "unmap" && t.Discard != "unmap" {
+		return &TranslationError{Field: field("detectZeroes"), Value: t.DetectZeroes, Reason: "needs discard unmap, found " + valueOrDefault(t.Discard)}
+	}
+	if tune := t.IOTune; tune != nil {
+		if tune.TotalBytesSec > 0 && (tune.ReadBytesSec > 0 || tune.WriteBytesSec > 0) {
+			return &TranslationError{Field: field("ioTune.totalBytesSec"), Value: strconv.FormatUint(tune.TotalBytesSec, 10), Reason: "can't be used with the read or write limits"}
+		}
+		if tune.TotalIOPSSec > 0 && (tune.ReadIOPSSec > 0 || tune.WriteIOPSSec > 0) {
+			return &TranslationError{Field: field("ioTune.totalIOPSSec"), Value: strconv.FormatUint(tune.TotalIOPSSec, 10), Reason: "can't be used with the read or write limits"}
+		}
+	}
+	return nil
+}
+
+func valueOrDefault(value string) string {
+	if value == "" {
+		return "the default"
+	}
+	return value
+}
@@ -0,0 +1,257 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestConvertDiskTuning(t *testing.T) {
+	yes := true
+	pvc := &k6tv1.Volume{
+		Name: "root",
+		VolumeSource: k6tv1.VolumeSource{
+			PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: "root"},
+		},
+	}
+	emptyDisk := &k6tv1.Volume{
+		Name: "scratch",
+		VolumeSource: k6tv1.VolumeSource{
+			EmptyDisk: &k6tv1.EmptyDiskSource{},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		conf        *DisksConfig
+		volume      *k6tv1.Volume
+		bus         string
+		readOnly    bool
+		wantDriver  libvirtxml.DomainDiskDriver
+		wantIOTune  *libvirtxml.DomainDiskIOTune
+		wantWarning string
+		wantField   string
+	}{
+		{
+			name:       "no disks profile",
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu"},
+		},
+		{
+			name: "defaults",
+			conf: &DisksConfig{
+				Defaults: &DiskTuning{Cache: "none", IO: "native", Discard: "unmap", ErrorPolicy: "stop"},
+			},
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu", Cache: "none", IO: "native", Discard: "unmap", ErrorPolicy: "stop"},
+		},
+		{
+			name: "the bus overrides the defaults",
+			conf: &DisksConfig{
+				Defaults: &DiskTuning{Cache: "none", Discard: "unmap"},
+				Buses:    map[string]DiskTuning{"virtio": {Cache: "writeback"}, "sata": {Cache: "unsafe"}},
+			},
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu", Cache: "writeback", Discard: "unmap"},
+		},
+		{
+			name: "the source overrides the bus",
+			conf: &DisksConfig{
+				Buses:   map[string]DiskTuning{"virtio": {Cache: "writeback", IO: "threads"}},
+				Sources: map[string]DiskTuning{"emptyDisk": {Cache: "unsafe"}},
+			},
+			volume:     emptyDisk,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu", Cache: "unsafe", IO: "threads"},
+		},
+		{
+			name: "the disk name overrides everything",
+			conf: &DisksConfig{
+				Defaults: &DiskTuning{Cache: "none"},
+				Buses:    map[string]DiskTuning{"virtio": {Cache: "writeback"}},
+				Sources:  map[string]DiskTuning{"persistentVolumeClaim": {Cache: "writethrough"}},
+				Disks:    map[string]DiskTuning{"root": {Cache: "directsync", IO: "native"}},
+			},
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu", Cache: "directsync", IO: "native"},
+		},
+		{
+			name: "a native I/O profile fixed by the disk cache",
+			conf: &DisksConfig{
+				Defaults: &DiskTuning{IO: "native", Cache: "writeback"},
+				Disks:    map[string]DiskTuning{"root": {Cache: "none"}},
+			},
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu", Cache: "none", IO: "native"},
+		},
+		{
+			name: "throttling",
+			conf: &DisksConfig{
+				Defaults: &DiskTuning{IOTune: &DiskIOTune{ReadBytesSec: 1000, WriteBytesSec: 500, TotalIOPSSec: 100}},
+			},
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu"},
+			wantIOTune: &libvirtxml.DomainDiskIOTune{ReadBytesSec: 1000, WriteBytesSec: 500, TotalIopsSec: 100},
+		},
+		{
+			name:       "copy on read",
+			conf:       &DisksConfig{Defaults: &DiskTuning{CopyOnRead: &yes, DetectZeroes: "unmap", Discard: "unmap"}},
+			volume:     pvc,
+			bus:        "virtio",
+			wantDriver: libvirtxml.DomainDiskDriver{Name: "qemu", CopyOnRead: "on", DetectZeros: "unmap", Discard: "unmap"},
+		},
+		{
+			name:        "copy on read of a read-only disk",
+			conf:        &DisksConfig{Defaults: &DiskTuning{CopyOnRead: &yes}},
+			volume:      pvc,
+			bus:         "virtio",
+			readOnly:    true,
+			wantDriver:  libvirtxml.DomainDiskDriver{Name: "qemu", CopyOnRead: "on"},
+			wantWarning: "Copy on read has no effect on the read-only disk root",
+		},
+		{
+			name:      "unknown cache",
+			conf:      &DisksConfig{Defaults: &DiskTuning{Cache: "writearound"}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.cache",
+		},
+		{
+			name:      "unknown error policy",
+			conf:      &DisksConfig{Disks: map[string]DiskTuning{"root": {ErrorPolicy: "retry"}}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.errorPolicy",
+		},
+		{
+			name:      "native I/O with writeback cache",
+			conf:      &DisksConfig{Defaults: &DiskTuning{IO: "native", Cache: "writeback"}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.io",
+		},
+		{
+			name:      "native I/O with the default cache",
+			conf:      &DisksConfig{Defaults: &DiskTuning{IO: "native"}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.io",
+		},
+		{
+			name:      "detect zeroes unmap without discard",
+			conf:      &DisksConfig{Defaults: &DiskTuning{DetectZeroes: "unmap"}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.detectZeroes",
+		},
+		{
+			name:      "total and read bytes limits",
+			conf:      &DisksConfig{Defaults: &DiskTuning{IOTune: &DiskIOTune{TotalBytesSec: 1000, ReadBytesSec: 500}}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.ioTune.totalBytesSec",
+		},
+		{
+			name:      "total and write IOPS limits",
+			conf:      &DisksConfig{Defaults: &DiskTuning{IOTune: &DiskIOTune{TotalIOPSSec: 100, WriteIOPSSec: 50}}},
+			volume:    pvc,
+			bus:       "virtio",
+			wantField: "disks.root.ioTune.totalIOPSSec",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := newTestDisk("root", "disk", tt.bus)
+			if tt.readOnly {
+				disk.ReadOnly = &libvirtxml.DomainDiskReadOnly{}
+			}
+			c := newTestContext(&ProfileSpec{Disks: tt.conf})
+			err := convert_api_DiskTuning_To_api_Disk(tt.volume, disk, c)
+			if tt.wantField != "" {
+				terr, ok := err.(*TranslationError)
+				if !ok {
+					t.Fatalf("got error %v, want a translation error", err)
+				}
+				if terr.Field != tt.wantField {
+					t.Errorf("got error on %s, want on %s", terr.Field, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) > 0 {
+					t.Errorf("unexpected warnings %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+
+			got, want := disk.Driver, tt.wantDriver
+			if got.Name != want.Name || got.Type != want.Type || got.Cache != want.Cache || got.IO != want.IO ||
+				got.Discard != want.Discard || got.DetectZeros != want.DetectZeros ||
+				got.ErrorPolicy != want.ErrorPolicy || got.CopyOnRead != want.CopyOnRead {
+				t.Errorf("got driver %+v, want %+v", got, want)
+			}
+			if (disk.IOTune == nil) != (tt.wantIOTune == nil) {
+				t.Fatalf("got iotune %+v, want %+v", disk.IOTune, tt.wantIOTune)
+			}
+			if tune, want := disk.IOTune, tt.wantIOTune; tune != nil {
+				if tune.TotalBytesSec != want.TotalBytesSec || tune.ReadBytesSec != want.ReadBytesSec ||
+					tune.WriteBytesSec != want.WriteBytesSec || tune.TotalIopsSec != want.TotalIopsSec ||
+					tune.ReadIopsSec != want.ReadIopsSec || tune.WriteIopsSec != want.WriteIopsSec {
+					t.Errorf("got iotune %+v, want %+v", tune, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVolumeSourceType(t *testing.T) {
+	tests := []struct {
+		name   string
+		source k6tv1.VolumeSource
+		want   string
+	}{
+		{"pvc", k6tv1.VolumeSource{PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{}}, "persistentVolumeClaim"},
+		{"container disk", k6tv1.VolumeSource{ContainerDisk: &k6tv1.ContainerDiskSource{}}, "containerDisk"},
+		{"cloud-init", k6tv1.VolumeSource{CloudInitNoCloud: &k6tv1.CloudInitNoCloudSource{}}, "cloudInitNoCloud"},
+		{"empty disk", k6tv1.VolumeSource{EmptyDisk: &k6tv1.EmptyDiskSource{}}, "emptyDisk"},
+		{"host disk", k6tv1.VolumeSource{HostDisk: &k6tv1.HostDisk{}}, "hostDisk"},
+		{"config map", k6tv1.VolumeSource{ConfigMap: &k6tv1.ConfigMapVolumeSource{}}, "configMap"},
+		{"none", k6tv1.VolumeSource{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := volumeSourceType(&k6tv1.Volume{VolumeSource: tt.source}); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"sort"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// Graphics protocols
+const (
+	GraphicsVNC   = "vnc"
+	GraphicsSpice = "spice"
+)
+
+// DefaultVideoModel is the video device used when the profiles don't choose one
+const DefaultVideoModel = "vga"
+
+const (
+	defaultVideoHeads = 1
+	defaultVideoVRAM  = "16Mi"
+)
+
+var (
+	videoModels          = []string{"vga", "virtio", "qxl", "bochs", "cirrus", "none"}
+	spiceChannels        = []string{"main", "display", "inputs", "cursor", "playback", "record", "smartcard", "usbredir"}
+	spiceChannelModes    = []string{"any", "secure", "insecure"}
+	spiceCompressions    = []string{"auto_glz", "auto_lz", "quic", "glz", "lz", "off"}
+	spiceStreamingModes  = []string{"filter", "all", "off"}
+	multiHeadVideoModels = []string{"virtio", "qxl"}
+)
+
+// convert_api_Display_To_api_Devices adds the graphics and the video devices. The VM spec decides
+// if they're attached; when it doesn't, the display profile does, and by default they are.
+func convert_api_Display_To_api_Devices(vmi *k6tv1.VirtualMachineInstance, domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.Display
+	if conf == nil {
+		conf = &DisplayConfig{}
+	}
+
+	attach := true
+	if vmi.Spec.Domain.Devices.AutoattachGraphicsDevice != nil {
+		attach = *vmi.Spec.Domain.Devices.AutoattachGraphicsDevice
+	} else if conf.Autoattach != nil {
+		attach = *conf.Autoattach
+	}
+	if conf.Headless {
+		if vmi.Spec.Domain.Devices.AutoattachGraphicsDevice != nil && attach {
+			c.warn("The VM asks for a graphics device, but the display profile is headless")
+		}
+		attach = false
+	}
+	if !attach {
+		return nil
+	}
+
+	video, err := convert_api_Video_To_api_Video(conf.Video)
+	if err != nil {
+		return err
+	}
+	domain.Devices.Videos = []libvirtxml.DomainVideo{*video}
+
+	switch conf.Graphics {
+	case "", GraphicsVNC:
+		if conf.Spice != nil {
+			c.warn("Ignoring the SPICE settings, the graphics protocol is %s", GraphicsVNC)
+		}
+		vncPath, err := c.Paths.VNCSocket()
+		if err != nil {
+			return err
+		}
+		domain.Devices.Graphics = []libvirtxml.DomainGraphic{
+			{
+				VNC: &libvirtxml.DomainGraphicVNC{
+					Listeners: []libvirtxml.DomainGraphicListener{
+						{
+							Socket: &libvirtxml.DomainGraphicListenerSocket{
+								Socket: vncPath,
+							},
+						},
+					},
+				},
+			},
+		}
+	case GraphicsSpice:
+		spice, err := convert_api_Spice_To_api_Graphics(conf.Spice, c)
+		if err != nil {
+			return err
+		}
+		domain.Devices.Graphics = []libvirtxml.DomainGraphic{
+			{
+				Spice: spice,
+			},
+		}
+		if video.Model.Type != "qxl" && video.Model.Type != "virtio" {
+			c.warn("SPICE works best with a qxl or virtio video device, found %s", video.Model.Type)
+		}
+	default:
+		return fmt.Errorf("unknown graphics protocol %s", conf.Graphics)
+	}
+	return nil
+}
+
+func convert_api_Video_To_api_Video(conf *VideoConfig) (*libvirtxml.DomainVideo, error) {
+	if conf == nil {
+		conf = &VideoConfig{}
+	}
+	model := conf.Model
+	if model == "" {
+		model = DefaultVideoModel
+	}
+	if !containsString(videoModels, model) {
+		return nil, fmt.Errorf("unknown video model %s", model)
+	}
+	video := &libvirtxml.DomainVideo{
+		Model: libvirtxml.DomainVideoModel{
+			Type: model,
+		},
+	}
+	if model == "none" {
+		return video, nil
+	}
+
+	heads := conf.Heads
+	if heads == 0 {
+		heads = defaultVideoHeads
+	}
+	if heads > 1 && !containsString(multiHeadVideoModels, model) {
+		return nil, fmt.Errorf("the %s video device has a single head, found %d", model, heads)
+	}
+	video.Model.Heads = heads
+
+	// the virtio device has no dedicated video memory
+	if model == "virtio" {
+		if conf.VRAM != "" {
+			return nil, fmt.Errorf("the virtio video device has no VRAM")
+		}
+		return video, nil
+	}
+	vram := conf.VRAM
+	if vram == "" {
+		vram = defaultVideoVRAM
+	}
+	quantity, err := k8sres.ParseQuantity(vram)
+	if err != nil {
+		return nil, fmt.Errorf("malformed VRAM size %s: %v", vram, err)
+	}
+	size, ok := quantity.AsInt64()
+	if !ok || size < 1024*1024 {
+		return nil, fmt.Errorf("invalid VRAM size %s", vram)
+	}
+	video.Model.VRam = uint(size / 1024)
+	return video, nil
+}
+
+// convert_api_Spice_To_api_Graphics configures a SPICE server listening on a unix socket. GL is always off:
+// the VMs have no access to the host render nodes.
+func convert_api_Spice_To_api_Graphics(conf *SpiceConfig, c *ConverterContext) (*libvirtxml.DomainGraphicSpice, error) {
+	if conf == nil {
+		conf = &SpiceConfig{}
+	}
+	spicePath, err := c.Paths.SpiceSocket()
+	if err != nil {
+		return nil, err
+	}
+	spice := &libvirtxml.DomainGraphicSpice{
+		Listeners: []libvirtxml.DomainGraphicListener{
+			{
+				Socket: &libvirtxml.DomainGraphicListenerSocket{
+					Socket: spicePath,
+				},
+			},
+		},
+		GL: &libvirtxml.DomainGraphicSpiceGL{
+			Enable: "no",
+		},
+	}
+
+	names := []string{}
+	for name := range conf.Channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mode := conf.Channels[name]
+		if !containsString(spiceChannels, name) {
+			return nil, fmt.Errorf("unknown SPICE channel %s", name)
+		}
+		if !containsString(spiceChannelModes, mode) {
+			return nil, fmt.Errorf("unknown mode %s for the SPICE channel %s", mode, name)
+		}
+		if mode == "secure" {
+			return nil, &TranslationError{Field: "spice.channels." + name, Value: mode, Reason: "the SPICE server listens on a unix socket, which has no TLS"}
+		}
+		spice.Channel = append(spice.Channel, libvirtxml.DomainGraphicSpiceChannel{
+			Name: name,
+			Mode: mode,
+		})
+	}
+
+	if conf.ImageCompression != "" {
+		if !containsString(spiceCompressions, conf.ImageCompression) {
+			return nil, fmt.Errorf("unknown SPICE image compression %s", conf.ImageCompression)
+		}
+		spice.Image = &libvirtxml.DomainGraphicSpiceImage{
+			Compression: conf.ImageCompression,
+		}
+	}
+	if conf.StreamingMode != "" {
+		if !containsString(spiceStreamingModes, conf.StreamingMode) {
+			return nil, fmt.Errorf("unknown SPICE streaming mode %s", conf.StreamingMode)
+		}
+		spice.Streaming = &libvirtxml.DomainGraphicSpiceStreaming{
+			Mode: conf.StreamingMode,
+		}
+	}
+	return spice, nil
+}
@@ -0,0 +1,338 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestConvertDisplay(t *testing.T) {
+	yes, no := true, false
+	vncSocket := testBaseDiskPath + "/default/testvmi/sockets/vnc"
+	spiceSocket := testBaseDiskPath + "/default/testvmi/sockets/spice"
+
+	tests := []struct {
+		name        string
+		autoattach  *bool
+		conf        *DisplayConfig
+		wantVideo   string
+		wantSocket  string
+		wantSpice   bool
+		wantWarning string
+		wantErr     bool
+	}{
+		{
+			name:       "VNC and VGA by default",
+			wantVideo:  "vga",
+			wantSocket: vncSocket,
+		},
+		{
+			name:       "the VM spec asks for no graphics",
+			autoattach: &no,
+			conf:       &DisplayConfig{Autoattach: &yes},
+		},
+		{
+			name: "the profile drops the graphics",
+			conf: &DisplayConfig{Autoattach: &no},
+		},
+		{
+			name:       "the VM spec wins over the profile",
+			autoattach: &yes,
+			conf:       &DisplayConfig{Autoattach: &no},
+			wantVideo:  "vga",
+			wantSocket: vncSocket,
+		},
+		{
+			name: "headless",
+			conf: &DisplayConfig{Headless: true},
+		},
+		{
+			name:        "headless, the VM asks for graphics",
+			autoattach:  &yes,
+			conf:        &DisplayConfig{Headless: true},
+			wantWarning: "the display profile is headless",
+		},
+		{
+			name:        "SPICE settings with VNC",
+			conf:        &DisplayConfig{Spice: &SpiceConfig{StreamingMode: "off"}},
+			wantVideo:   "vga",
+			wantSocket:  vncSocket,
+			wantWarning: "Ignoring the SPICE settings",
+		},
+		{
+			name:       "SPICE with qxl",
+			conf:       &DisplayConfig{Graphics: GraphicsSpice, Video: &VideoConfig{Model: "qxl"}},
+			wantVideo:  "qxl",
+			wantSocket: spiceSocket,
+			wantSpice:  true,
+		},
+		{
+			name:        "SPICE with VGA",
+			conf:        &DisplayConfig{Graphics: GraphicsSpice},
+			wantVideo:   "vga",
+			wantSocket:  spiceSocket,
+			wantSpice:   true,
+			wantWarning: "SPICE works best with a qxl or virtio video device",
+		},
+		{
+			name:    "unknown graphics protocol",
+			conf:    &DisplayConfig{Graphics: "rdp"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid video",
+			conf:    &DisplayConfig{Video: &VideoConfig{Model: "matrox"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{Display: tt.conf})
+			vmi := c.VirtualMachine
+			vmi.Spec.Domain.Devices.AutoattachGraphicsDevice = tt.autoattach
+			domain := &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{}}
+			err := convert_api_Display_To_api_Devices(vmi, domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) > 0 {
+					t.Errorf("unexpected warnings %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+
+			if tt.wantVideo == "" {
+				if len(domain.Devices.Videos) > 0 || len(domain.Devices.Graphics) > 0 {
+					t.Errorf("got videos %+v and graphics %+v, want none", domain.Devices.Videos, domain.Devices.Graphics)
+				}
+				return
+			}
+			if len(domain.Devices.Videos) != 1 || domain.Devices.Videos[0].Model.Type != tt.wantVideo {
+				t.Errorf("got videos %+v, want a %s one", domain.Devices.Videos, tt.wantVideo)
+			}
+			if len(domain.Devices.Graphics) != 1 {
+				t.Fatalf("got graphics %+v, want one", domain.Devices.Graphics)
+			}
+			graphics := domain.Devices.Graphics[0]
+			var listeners []libvirtxml.DomainGraphicListener
+			if tt.wantSpice {
+				if graphics.Spice == nil {
+					t.Fatalf("got graphics %+v, want SPICE", graphics)
+				}
+				listeners = graphics.Spice.Listeners
+			} else {
+				if graphics.VNC == nil {
+					t.Fatalf("got graphics %+v, want VNC", graphics)
+				}
+				listeners = graphics.VNC.Listeners
+			}
+			if len(listeners) != 1 || listeners[0].Socket == nil || listeners[0].Socket.Socket != tt.wantSocket {
+				t.Errorf("got listeners %+v, want the socket %s", listeners, tt.wantSocket)
+			}
+		})
+	}
+}
+
+func TestConvertVideo(t *testing.T) {
+	tests := []struct {
+		name      string
+		conf      *VideoConfig
+		wantModel string
+		wantHeads uint
+		wantVRAM  uint
+		wantErr   bool
+	}{
+		{
+			name:      "default",
+			wantModel: "vga",
+			wantHeads: 1,
+			wantVRAM:  16384,
+		},
+		{
+			name:      "qxl with two heads",
+			conf:      &VideoConfig{Model: "qxl", Heads: 2, VRAM: "64Mi"},
+			wantModel: "qxl",
+			wantHeads: 2,
+			wantVRAM:  65536,
+		},
+		{
+			name:      "virtio has no VRAM",
+			conf:      &VideoConfig{Model: "virtio", Heads: 4},
+			wantModel: "virtio",
+			wantHeads: 4,
+		},
+		{
+			name:      "none",
+			conf:      &VideoConfig{Model: "none", Heads: 2},
+			wantModel: "none",
+		},
+		{
+			name:    "unknown model",
+			conf:    &VideoConfig{Model: "matrox"},
+			wantErr: true,
+		},
+		{
+			name:    "cirrus with two heads",
+			conf:    &VideoConfig{Model: "cirrus", Heads: 2},
+			wantErr: true,
+		},
+		{
+			name:    "virtio with VRAM",
+			conf:    &VideoConfig{Model: "virtio", VRAM: "16Mi"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed VRAM",
+			conf:    &VideoConfig{VRAM: "lots"},
+			wantErr: true,
+		},
+		{
+			name:    "VRAM too small",
+			conf:    &VideoConfig{VRAM: "512Ki"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video, err := convert_api_Video_To_api_Video(tt.conf)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			model := video.Model
+			if model.Type != tt.wantModel || model.Heads != tt.wantHeads || model.VRam != tt.wantVRAM {
+				t.Errorf("got model %+v, want %s with %d heads and %d KiB VRAM", model, tt.wantModel, tt.wantHeads, tt.wantVRAM)
+			}
+		})
+	}
+}
+
+func TestConvertSpice(t *testing.T) {
+	tests := []struct {
+		name            string
+		conf            *SpiceConfig
+		wantChannels    []libvirtxml.DomainGraphicSpiceChannel
+		wantCompression string
+		wantStreaming   string
+		wantErr         bool
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "channels sorted by name",
+			conf: &SpiceConfig{Channels: map[string]string{"main": "insecure", "display": "any"}},
+			wantChannels: []libvirtxml.DomainGraphicSpiceChannel{
+				{Name: "display", Mode: "any"},
+				{Name: "main", Mode: "insecure"},
+			},
+		},
+		{
+			name:            "compression and streaming",
+			conf:            &SpiceConfig{ImageCompression: "quic", StreamingMode: "filter"},
+			wantCompression: "quic",
+			wantStreaming:   "filter",
+		},
+		{
+			name:    "secure channel on a unix socket",
+			conf:    &SpiceConfig{Channels: map[string]string{"main": "secure"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown channel",
+			conf:    &SpiceConfig{Channels: map[string]string{"webdav": "any"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown channel mode",
+			conf:    &SpiceConfig{Channels: map[string]string{"main": "encrypted"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown compression",
+			conf:    &SpiceConfig{ImageCompression: "zstd"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown streaming mode",
+			conf:    &SpiceConfig{StreamingMode: "some"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			spice, err := convert_api_Spice_To_api_Graphics(tt.conf, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(c.Warnings) > 0 {
+				t.Errorf("unexpected warnings %v", c.Warnings)
+			}
+
+			if spice.GL == nil || spice.GL.Enable != "no" {
+				t.Errorf("got GL %+v, want disabled", spice.GL)
+			}
+			if len(spice.Channel) != len(tt.wantChannels) {
+				t.Fatalf("got channels %+v, want %+v", spice.Channel, tt.wantChannels)
+			}
+			for i := range spice.Channel {
+				if spice.Channel[i].Name != tt.wantChannels[i].Name || spice.Channel[i].Mode != tt.wantChannels[i].Mode {
+					t.Errorf("got channel %+v, want %+v", spice.Channel[i], tt.wantChannels[i])
+				}
+			}
+			compression := ""
+			if spice.Image != nil {
+				compression = spice.Image.Compression
+			}
+			if compression != tt.wantCompression {
+				t.Errorf("got image compression %q, want %q", compression, tt.wantCompression)
+			}
+			streaming := ""
+			if spice.Streaming != nil {
+				streaming = spice.Streaming.Mode
+			}
+			if streaming != tt.wantStreaming {
+				t.Errorf("got streaming mode %q, want %q", streaming, tt.wantStreaming)
+			}
+		})
+	}
+}
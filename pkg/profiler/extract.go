@@ -0,0 +1,269 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// ProfileLabel is the label used by the extracted presets to select the VirtualMachineInstances
+const ProfileLabel = "virt-profiles/profile"
+
+// ExtractedProfile holds the profiles extracted from an existing domain
+type ExtractedProfile struct {
+	// Preset to apply in the stage1, holding the settings which have a KubeVirt equivalent
+	Preset *k6tv1.VirtualMachineInstancePreset
+	// XMLProfile to apply in the stage3, holding the settings which differ from the translated domain
+	XMLProfile string
+	// Unmapped lists the settings of the domain which have no KubeVirt equivalent,
+	// or which the XML profile can't express
+	Unmapped []string
+}
+
+// ExtractProfile compares the given domain with the translation of the closest equivalent DomainSpec,
+// and turns the differences in a candidate stage1 preset and a candidate stage3 XML profile.
+// The preset selects the VirtualMachineInstances labeled with ProfileLabel=name.
+func (p *Profiler) ExtractProfile(name string, dom *libvirtxml.Domain) (*ExtractedProfile, []string, error) {
+	// the name is both the name of the preset and a label value
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid profile name %q: %s", name, strings.Join(errs, ", "))
+	}
+	rev, warnings, err := ReverseTranslateSpecs(dom)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	vmi := &k6tv1.VirtualMachineInstance{}
+	vmi.Name = name
+	vmi.Spec.Domain = *rev.Spec
+	for i := range rev.Volumes {
+		vmi.Spec.Volumes = append(vmi.Spec.Volumes, rev.Volumes[i])
+	}
+	for i := range rev.Networks {
+		vmi.Spec.Networks = append(vmi.Spec.Networks, rev.Networks[i])
+	}
+
+	translated, _, translateWarnings, err := p.translate(context.Background(), vmi)
+	warnings = append(warnings, translateWarnings...)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	partial := &libvirtxml.Domain{}
+	unset := []string{}
+	if diff, changed := diffValue(reflect.ValueOf(dom).Elem(), reflect.ValueOf(translated).Elem(), "", &unset); changed {
+		partial = diff.Addr().Interface().(*libvirtxml.Domain)
+	}
+	stripPerVMSettings(partial)
+	xmlProfile, err := partial.Marshal()
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	return &ExtractedProfile{
+		Preset:     p.makePreset(name, rev.Spec),
+		XMLProfile: xmlProfile,
+		Unmapped:   append(rev.Unmapped, unset...),
+	}, warnings, nil
+}
+
+func (p *Profiler) makePreset(name string, spec *k6tv1.DomainSpec) *k6tv1.VirtualMachineInstancePreset {
+	preset := &k6tv1.VirtualMachineInstancePreset{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: k6tv1.GroupVersion.String(),
+			Kind:       "VirtualMachineInstancePreset",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Annotations: map[string]string{
+				p.sortingAnnotation: "0",
+			},
+		},
+		Spec: k6tv1.VirtualMachineInstancePresetSpec{
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					ProfileLabel: name,
+				},
+			},
+			Domain: &k6tv1.DomainSpec{
+				Resources: spec.Resources,
+				CPU:       spec.CPU,
+				Memory:    spec.Memory,
+				Machine:   spec.Machine,
+				Clock:     spec.Clock,
+				Features:  spec.Features,
+			},
+		},
+	}
+	// disks and interfaces are specific to each VM, and so is the firmware UUID
+	preset.Spec.Domain.Devices.Watchdog = spec.Devices.Watchdog
+	preset.Spec.Domain.Devices.AutoattachGraphicsDevice = spec.Devices.AutoattachGraphicsDevice
+	return preset
+}
+
+// stripPerVMSettings removes from a partial domain all the settings specific to a VM,
+// which don't belong to a reusable profile.
+func stripPerVMSettings(dom *libvirtxml.Domain) {
+	dom.Type = ""
+	dom.Name = ""
+	dom.UUID = ""
+	dom.Title = ""
+	dom.Description = ""
+	dom.Metadata = nil
+	dom.SysInfo = nil
+	if dom.Devices == nil {
+		return
+	}
+	dom.Devices.Emulator = ""
+	for i := range dom.Devices.Disks {
+		dom.Devices.Disks[i].Source = nil
+		dom.Devices.Disks[i].BackingStore = nil
+	}
+	for i := range dom.Devices.Interfaces {
+		dom.Devices.Interfaces[i].MAC = nil
+		dom.Devices.Interfaces[i].Source = nil
+		dom.Devices.Interfaces[i].Target = nil
+	}
+	dom.Devices.Serials = nil
+	dom.Devices.Consoles = nil
+}
+
+// diffValue returns the settings of want which differ from got, and whether any setting differs.
+// Device list elements are matched like in ApplyProfiles, and keep their key in the result.
+// The settings left unset in want but set in got can't be expressed by a partial domain,
+// so their XPath-like paths, relative to path, are appended to unset instead.
+func diffValue(want, got reflect.Value, path string, unset *[]string) (reflect.Value, bool) {
+	ret := reflect.New(want.Type()).Elem()
+
+	switch want.Kind() {
+	case reflect.Ptr:
+		if want.IsNil() {
+			return ret, false
+		}
+		if got.IsNil() {
+			return want, true
+		}
+		diff, changed := diffValue(want.Elem(), got.Elem(), path, unset)
+		if !changed {
+			return ret, false
+		}
+		ret.Set(reflect.New(want.Type().Elem()))
+		ret.Elem().Set(diff)
+		return ret, true
+	case reflect.Struct:
+		changed := false
+		for i := 0; i < want.NumField(); i++ {
+			field := want.Type().Field(i)
+			if field.PkgPath != "" || field.Name == "XMLName" {
+				continue
+			}
+			diff, fieldChanged := diffValue(want.Field(i), got.Field(i), xmlFieldPath(path, field), unset)
+			if fieldChanged {
+				ret.Field(i).Set(diff)
+				changed = true
+			}
+		}
+		return ret, changed
+	case reflect.Slice:
+		changed := false
+		for i := 0; i < want.Len(); i++ {
+			elem := want.Index(i)
+			pos := findElement(got, elem)
+			if pos == -1 {
+				if !containsValue(got, elem) {
+					ret = reflect.Append(ret, elem)
+					changed = true
+				}
+				continue
+			}
+			diff, elemChanged := diffValue(elem, got.Index(pos), fmt.Sprintf("%s[%d]", path, i+1), unset)
+			if elemChanged {
+				restoreElementKey(diff, elem)
+				ret = reflect.Append(ret, diff)
+				changed = true
+			}
+		}
+		return ret, changed
+	default:
+		if reflect.DeepEqual(want.Interface(), got.Interface()) {
+			return ret, false
+		}
+		if isZeroValue(want) {
+			*unset = append(*unset, fmt.Sprintf("%s: unset, translated as %v", path, got.Interface()))
+			return ret, false
+		}
+		return want, true
+	}
+}
+
+// xmlFieldPath returns the XPath-like path of a struct field, after its XML name.
+func xmlFieldPath(path string, field reflect.StructField) string {
+	tag := strings.Split(field.Tag.Get("xml"), ",")
+	name := tag[0]
+	if len(tag) > 1 && tag[1] == "attr" {
+		name = "@" + name
+	}
+	switch {
+	case tag[0] == "":
+		// character data, or a struct embedding its fields in the parent element
+		return path
+	case path == "":
+		return name
+	default:
+		return path + "/" + name
+	}
+}
+
+// restoreElementKey copies the fields used by elementKey from src to dst, so the element can be matched again.
+func restoreElementKey(dst, src reflect.Value) {
+	if alias := src.FieldByName("Alias"); alias.IsValid() {
+		dst.FieldByName("Alias").Set(alias)
+	}
+	if name := src.FieldByName("Name"); name.IsValid() && name.Kind() == reflect.String {
+		dst.FieldByName("Name").Set(name)
+	}
+	target := src.FieldByName("Target")
+	if !target.IsValid() || target.Kind() != reflect.Ptr || target.IsNil() {
+		return
+	}
+	dev := target.Elem().FieldByName("Dev")
+	if !dev.IsValid() {
+		return
+	}
+	dstTarget := dst.FieldByName("Target")
+	if dstTarget.IsNil() {
+		dstTarget.Set(reflect.New(target.Type().Elem()))
+	}
+	dstTarget.Elem().FieldByName("Dev").Set(dev)
+}
+
+func containsValue(slice, elem reflect.Value) bool {
+	for i := 0; i < slice.Len(); i++ {
+		if reflect.DeepEqual(slice.Index(i).Interface(), elem.Interface()) {
+			return true
+		}
+	}
+	return false
+}
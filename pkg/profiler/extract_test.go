@@ -0,0 +1,237 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestDiffValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		want        *libvirtxml.Domain
+		got         *libvirtxml.Domain
+		wantChanged bool
+		wantDiff    *libvirtxml.Domain
+		wantUnset   []string
+	}{
+		{
+			name: "same domains",
+			want: &libvirtxml.Domain{Name: "vm", VCPU: &libvirtxml.DomainVCPU{Value: 2}},
+			got:  &libvirtxml.Domain{Name: "vm", VCPU: &libvirtxml.DomainVCPU{Value: 2}},
+		},
+		{
+			name:        "changed setting",
+			want:        &libvirtxml.Domain{Name: "vm", VCPU: &libvirtxml.DomainVCPU{Value: 4}},
+			got:         &libvirtxml.Domain{Name: "vm", VCPU: &libvirtxml.DomainVCPU{Value: 2}},
+			wantChanged: true,
+			wantDiff:    &libvirtxml.Domain{VCPU: &libvirtxml.DomainVCPU{Value: 4}},
+		},
+		{
+			name:        "setting missing in the translation",
+			want:        &libvirtxml.Domain{Features: &libvirtxml.DomainFeatureList{PAE: &libvirtxml.DomainFeature{}}},
+			got:         &libvirtxml.Domain{},
+			wantChanged: true,
+			wantDiff:    &libvirtxml.Domain{Features: &libvirtxml.DomainFeatureList{PAE: &libvirtxml.DomainFeature{}}},
+		},
+		{
+			name: "setting missing in the domain",
+			want: &libvirtxml.Domain{},
+			got:  &libvirtxml.Domain{VCPU: &libvirtxml.DomainVCPU{Value: 2}},
+		},
+		{
+			name:      "setting unset in the domain",
+			want:      &libvirtxml.Domain{VCPU: &libvirtxml.DomainVCPU{Placement: "static"}},
+			got:       &libvirtxml.Domain{VCPU: &libvirtxml.DomainVCPU{Placement: "static", Value: 2}},
+			wantUnset: []string{"vcpu: unset, translated as 2"},
+		},
+		{
+			name: "timers keep their name",
+			want: &libvirtxml.Domain{Clock: &libvirtxml.DomainClock{
+				Offset: "utc",
+				Timer: []libvirtxml.DomainTimer{
+					{Name: "rtc", TickPolicy: "delay"},
+					{Name: "hpet", Present: "no"},
+				},
+			}},
+			got: &libvirtxml.Domain{Clock: &libvirtxml.DomainClock{
+				Offset: "utc",
+				Timer: []libvirtxml.DomainTimer{
+					{Name: "rtc", TickPolicy: "catchup"},
+					{Name: "hpet", Present: "no"},
+				},
+			}},
+			wantChanged: true,
+			wantDiff: &libvirtxml.Domain{Clock: &libvirtxml.DomainClock{
+				Timer: []libvirtxml.DomainTimer{{Name: "rtc", TickPolicy: "delay"}},
+			}},
+		},
+		{
+			name: "devices keep their alias and target",
+			want: &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{
+				Disks: []libvirtxml.DomainDisk{{
+					Alias:  &libvirtxml.DomainAlias{Name: "root"},
+					Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw", Cache: "none"},
+				}},
+			}},
+			got: &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{
+				Disks: []libvirtxml.DomainDisk{{
+					Alias:  &libvirtxml.DomainAlias{Name: "root"},
+					Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+				}},
+			}},
+			wantChanged: true,
+			wantDiff: &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{
+				Disks: []libvirtxml.DomainDisk{{
+					Alias:  &libvirtxml.DomainAlias{Name: "root"},
+					Target: &libvirtxml.DomainDiskTarget{Dev: "vda"},
+					Driver: &libvirtxml.DomainDiskDriver{Cache: "none"},
+				}},
+			}},
+		},
+		{
+			name: "keyless elements found in the translation",
+			want: &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{
+				Inputs: []libvirtxml.DomainInput{{Type: "tablet", Bus: "usb"}},
+			}},
+			got: &libvirtxml.Domain{Devices: &libvirtxml.DomainDeviceList{
+				Inputs: []libvirtxml.DomainInput{{Type: "tablet", Bus: "usb"}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unset := []string{}
+			diff, changed := diffValue(reflect.ValueOf(tt.want).Elem(), reflect.ValueOf(tt.got).Elem(), "", &unset)
+			if changed != tt.wantChanged {
+				t.Fatalf("got changed: %v, want %v", changed, tt.wantChanged)
+			}
+			if len(unset) > 0 || len(tt.wantUnset) > 0 {
+				if !reflect.DeepEqual(unset, tt.wantUnset) {
+					t.Errorf("got unset settings %v, want %v", unset, tt.wantUnset)
+				}
+			}
+			if !changed {
+				return
+			}
+			got := diff.Addr().Interface().(*libvirtxml.Domain)
+			if !reflect.DeepEqual(got, tt.wantDiff) {
+				t.Errorf("got diff %+v, want %+v", got, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestStripPerVMSettings(t *testing.T) {
+	dom := &libvirtxml.Domain{
+		Type:    "kvm",
+		Name:    "default_testvmi",
+		UUID:    "0f2d2e5e-8b6c-4a27-9a4e-5bd1c0a0e4c9",
+		SysInfo: &libvirtxml.DomainSysInfo{Type: "smbios"},
+		VCPU:    &libvirtxml.DomainVCPU{Value: 2},
+		Devices: &libvirtxml.DomainDeviceList{
+			Emulator: "/usr/bin/qemu-kvm",
+			Disks: []libvirtxml.DomainDisk{{
+				Alias:  &libvirtxml.DomainAlias{Name: "root"},
+				Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/images/root.img"}},
+				Driver: &libvirtxml.DomainDiskDriver{Cache: "none"},
+			}},
+			Interfaces: []libvirtxml.DomainInterface{{
+				Alias: &libvirtxml.DomainAlias{Name: "default"},
+				MAC:   &libvirtxml.DomainInterfaceMAC{Address: "52:54:00:12:34:56"},
+				MTU:   &libvirtxml.DomainInterfaceMTU{Size: 9000},
+			}},
+			Serials: []libvirtxml.DomainSerial{{}},
+		},
+	}
+	want := &libvirtxml.Domain{
+		VCPU: &libvirtxml.DomainVCPU{Value: 2},
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{{
+				Alias:  &libvirtxml.DomainAlias{Name: "root"},
+				Driver: &libvirtxml.DomainDiskDriver{Cache: "none"},
+			}},
+			Interfaces: []libvirtxml.DomainInterface{{
+				Alias: &libvirtxml.DomainAlias{Name: "default"},
+				MTU:   &libvirtxml.DomainInterfaceMTU{Size: 9000},
+			}},
+		},
+	}
+
+	stripPerVMSettings(dom)
+	if !reflect.DeepEqual(dom, want) {
+		t.Errorf("got %+v, want %+v", dom, want)
+	}
+}
+
+func TestExtractProfile(t *testing.T) {
+	dom := &libvirtxml.Domain{
+		Type:   "kvm",
+		Name:   "legacy",
+		Memory: &libvirtxml.DomainMemory{Value: 1024, Unit: "MiB"},
+		VCPU:   &libvirtxml.DomainVCPU{Value: 2},
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{{
+				Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+				Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/images/root.img"}},
+				Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw", Cache: "none"},
+			}},
+		},
+	}
+
+	extracted, _, err := newTestProfiler().ExtractProfile("legacy", dom)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	preset := extracted.Preset
+	if preset.Name != "legacy" || preset.Spec.Selector.MatchLabels[ProfileLabel] != "legacy" {
+		t.Errorf("got preset %s selecting %v", preset.Name, preset.Spec.Selector.MatchLabels)
+	}
+	if _, ok := preset.Annotations[priorityMarking]; !ok {
+		t.Errorf("the preset lacks the priority annotation")
+	}
+	if preset.Spec.Domain.CPU == nil || preset.Spec.Domain.CPU.Cores != 2 {
+		t.Errorf("got preset CPU %+v, want 2 cores", preset.Spec.Domain.CPU)
+	}
+	if !strings.Contains(extracted.XMLProfile, `cache="none"`) {
+		t.Errorf("the XML profile lacks the disk cache mode:\n%s", extracted.XMLProfile)
+	}
+	for _, perVM := range []string{"/images/root.img", "<name>", "<uuid>"} {
+		if strings.Contains(extracted.XMLProfile, perVM) {
+			t.Errorf("the XML profile holds the per VM setting %s:\n%s", perVM, extracted.XMLProfile)
+		}
+	}
+	if !containsWarning(extracted.Unmapped, "import /images/root.img into the PVC root") {
+		t.Errorf("got unmapped settings %v, want the disk image import", extracted.Unmapped)
+	}
+}
+
+func TestExtractProfileInvalidName(t *testing.T) {
+	for _, name := range []string{"", "../etc/passwd", "Legacy", "legacy.profile"} {
+		_, _, err := newTestProfiler().ExtractProfile(name, &libvirtxml.Domain{})
+		if err == nil || !strings.Contains(err.Error(), "invalid profile name") {
+			t.Errorf("name %q: got error %v, want the invalid name error", name, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// Firmware types
+const (
+	FirmwareBIOS = "bios"
+	FirmwareEFI  = "efi"
+)
+
+// DefaultFirmwareDir is where the distributions install the QEMU firmware descriptors
+const DefaultFirmwareDir = "/usr/share/qemu/firmware"
+
+const defaultArch = "x86_64"
+
+// FirmwareDescriptor is a firmware description in the QEMU firmware interop format (docs/interop/firmware.json).
+// Only the fields used to pick a loader are decoded.
+type FirmwareDescriptor struct {
+	Description    string           `json:"description"`
+	InterfaceTypes []string         `json:"interface-types"`
+	Mapping        FirmwareMapping  `json:"mapping"`
+	Targets        []FirmwareTarget `json:"targets"`
+	Features       []string         `json:"features"`
+	// Name is the descriptor file name; descriptors are tried in name order
+	Name string `json:"-"`
+}
+
+// FirmwareMapping tells how the firmware is mapped in the guest
+type FirmwareMapping struct {
+	Device        string        `json:"device"`
+	Executable    *FirmwareFile `json:"executable,omitempty"`
+	NVRAMTemplate *FirmwareFile `json:"nvram-template,omitempty"`
+	Filename      string        `json:"filename,omitempty"`
+}
+
+// FirmwareFile is a firmware image
+type FirmwareFile struct {
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+}
+
+// FirmwareTarget is an architecture and the machine types, as glob patterns, the firmware supports
+type FirmwareTarget struct {
+	Architecture string   `json:"architecture"`
+	Machines     []string `json:"machines"`
+}
+
+// LoadFirmwareDescriptors reads all the firmware descriptors in a directory, sorted by file name
+func LoadFirmwareDescriptors(dir string) ([]FirmwareDescriptor, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	descs := []FirmwareDescriptor{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		desc := FirmwareDescriptor{}
+		err = json.Unmarshal(data, &desc)
+		if err != nil {
+			return nil, fmt.Errorf("malformed firmware descriptor %s: %v", file, err)
+		}
+		desc.Name = filepath.Base(file)
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+func (d *FirmwareDescriptor) hasFeature(feature string) bool {
+	for _, f := range d.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *FirmwareDescriptor) supports(interfaceType, arch, machine string) bool {
+	found := false
+	for _, it := range d.InterfaceTypes {
+		if it == interfaceType {
+			found = true
+		}
+	}
+	if !found {
+		return false
+	}
+	// the machine aliases match the versioned machine patterns, like "pc-q35-*"
+	switch machine {
+	case "q35":
+		machine = "pc-q35-latest"
+	case "pc":
+		machine = "pc-i440fx-latest"
+	}
+	for _, target := range d.Targets {
+		if target.Architecture != arch {
+			continue
+		}
+		if machine == "" {
+			return true
+		}
+		for _, pattern := range target.Machines {
+			if ok, _ := path.Match(pattern, machine); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// convert_api_Firmware_To_api_OS sets the firmware the profile asks for. UEFI uses a read-only pflash loader and
+// a per-VM variable store; the images are chosen later by Complete, from the firmware descriptors.
+func convert_api_Firmware_To_api_OS(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.Firmware
+	if conf == nil || conf.Type == "" || conf.Type == FirmwareBIOS {
+		if conf != nil && conf.SecureBoot {
+			return fmt.Errorf("secure boot needs the %s firmware", FirmwareEFI)
+		}
+		return nil
+	}
+	if conf.Type != FirmwareEFI {
+		return fmt.Errorf("unknown firmware type %s", conf.Type)
+	}
+
+	nvram, err := c.Paths.NVRAM()
+	if err != nil {
+		return err
+	}
+	domain.OS.Loader = &libvirtxml.DomainLoader{
+		Readonly: "yes",
+		Type:     "pflash",
+	}
+	domain.OS.NVRam = &libvirtxml.DomainNVRam{
+		NVRam: nvram,
+	}
+
+	if conf.SecureBoot {
+		if !isQ35Machine(domain) {
+			return fmt.Errorf("secure boot needs a q35 machine")
+		}
+		domain.OS.Loader.Secure = "yes"
+		// secure boot is enforced only if the guest can't write the flash outside of SMM
+		if domain.Features == nil {
+			domain.Features = &libvirtxml.DomainFeatureList{}
+		}
+		domain.Features.SMM = &libvirtxml.DomainFeatureSMM{
+			State: "on",
+		}
+	}
+	return nil
+}
+
+// completeFirmware picks the loader image and the variable store template from the host firmware descriptors,
+// unless the profiles already set them.
+func completeFirmware(dom *libvirtxml.Domain, c *ConverterContext) error {
+	if dom.OS == nil || dom.OS.Loader == nil || dom.OS.Loader.Path != "" {
+		return nil
+	}
+	if c.Host == nil || len(c.Host.Firmwares) == 0 {
+		c.warn("No firmware descriptor for the host, the UEFI loader is left to libvirt")
+		return nil
+	}
+
+	arch := defaultArch
+	machine := ""
+	if dom.OS.Type != nil {
+		if dom.OS.Type.Arch != "" {
+			arch = dom.OS.Type.Arch
+		}
+		machine = dom.OS.Type.Machine
+	}
+	secure := dom.OS.Loader.Secure == "yes"
+
+	for i := range c.Host.Firmwares {
+		desc := &c.Host.Firmwares[i]
+		if desc.Mapping.Device != "flash" || desc.Mapping.Executable == nil {
+			continue
+		}
+		if !desc.supports("uefi", arch, machine) {
+			continue
+		}
+		if secure != desc.hasFeature("secure-boot") {
+			continue
+		}
+		if desc.hasFeature("requires-smm") && (dom.Features == nil || dom.Features.SMM == nil || dom.Features.SMM.State != "on") {
+			continue
+		}
+		dom.OS.Loader.Path = desc.Mapping.Executable.Filename
+		if desc.Mapping.NVRAMTemplate != nil {
+			if dom.OS.NVRam == nil {
+				dom.OS.NVRam = &libvirtxml.DomainNVRam{}
+			}
+			if dom.OS.NVRam.Template == "" {
+				dom.OS.NVRam.Template = desc.Mapping.NVRAMTemplate.Filename
+			}
+		}
+		if secure && !desc.hasFeature("enrolled-keys") {
+			c.warn("The firmware %s has no keys enrolled, secure boot must be set up in the guest", desc.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("no UEFI firmware matches %s/%s (secure boot: %v)", arch, machine, secure)
+}
@@ -0,0 +1,354 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// fixtureFirmwares are the firmware descriptors used by the tests: a secure boot UEFI for q35,
+// a plain UEFI for both the machine types and a BIOS
+func fixtureFirmwares() []FirmwareDescriptor {
+	return []FirmwareDescriptor{
+		{
+			Name:           "40-edk2-secboot.json",
+			InterfaceTypes: []string{"uefi"},
+			Mapping: FirmwareMapping{
+				Device:        "flash",
+				Executable:    &FirmwareFile{Filename: "/usr/share/OVMF/OVMF_CODE.secboot.fd", Format: "raw"},
+				NVRAMTemplate: &FirmwareFile{Filename: "/usr/share/OVMF/OVMF_VARS.secboot.fd", Format: "raw"},
+			},
+			Targets:  []FirmwareTarget{{Architecture: "x86_64", Machines: []string{"pc-q35-*"}}},
+			Features: []string{"secure-boot", "requires-smm", "enrolled-keys"},
+		},
+		{
+			Name:           "50-edk2.json",
+			InterfaceTypes: []string{"uefi"},
+			Mapping: FirmwareMapping{
+				Device:        "flash",
+				Executable:    &FirmwareFile{Filename: "/usr/share/OVMF/OVMF_CODE.fd", Format: "raw"},
+				NVRAMTemplate: &FirmwareFile{Filename: "/usr/share/OVMF/OVMF_VARS.fd", Format: "raw"},
+			},
+			Targets: []FirmwareTarget{{Architecture: "x86_64", Machines: []string{"pc-i440fx-*", "pc-q35-*"}}},
+		},
+		{
+			Name:           "60-seabios.json",
+			InterfaceTypes: []string{"bios"},
+			Mapping:        FirmwareMapping{Device: "memory", Filename: "/usr/share/seabios/bios.bin"},
+			Targets:        []FirmwareTarget{{Architecture: "x86_64", Machines: []string{"pc-i440fx-*", "pc-q35-*"}}},
+		},
+	}
+}
+
+func TestLoadFirmwareDescriptors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firmware")
+	if err != nil {
+		t.Fatalf("cannot create the descriptor directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"60-seabios.json": `{"interface-types": ["bios"], "mapping": {"device": "memory", "filename": "/usr/share/seabios/bios.bin"}}`,
+		"50-edk2.json": `{
+			"description": "UEFI firmware",
+			"interface-types": ["uefi"],
+			"mapping": {
+				"device": "flash",
+				"executable": {"filename": "/usr/share/OVMF/OVMF_CODE.fd", "format": "raw"},
+				"nvram-template": {"filename": "/usr/share/OVMF/OVMF_VARS.fd", "format": "raw"}
+			},
+			"targets": [{"architecture": "x86_64", "machines": ["pc-q35-*"]}],
+			"features": ["acpi-s3"]
+		}`,
+		"README": "not a descriptor",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+	}
+
+	descs, err := LoadFirmwareDescriptors(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, desc := range descs {
+		names = append(names, desc.Name)
+	}
+	if want := []string{"50-edk2.json", "60-seabios.json"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got descriptors %v, want %v", names, want)
+	}
+	edk2 := descs[0]
+	if edk2.Mapping.Executable == nil || edk2.Mapping.Executable.Filename != "/usr/share/OVMF/OVMF_CODE.fd" {
+		t.Errorf("got executable %+v", edk2.Mapping.Executable)
+	}
+	if !edk2.hasFeature("acpi-s3") || edk2.hasFeature("secure-boot") {
+		t.Errorf("got features %v", edk2.Features)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "70-broken.json"), []byte("{"), 0644)
+	if err != nil {
+		t.Fatalf("cannot write the broken descriptor: %v", err)
+	}
+	if _, err := LoadFirmwareDescriptors(dir); err == nil {
+		t.Errorf("expected an error for the malformed descriptor")
+	}
+}
+
+func TestFirmwareDescriptorSupports(t *testing.T) {
+	desc := fixtureFirmwares()[0]
+	tests := []struct {
+		interfaceType string
+		arch          string
+		machine       string
+		want          bool
+	}{
+		{"uefi", "x86_64", "pc-q35-2.12", true},
+		{"uefi", "x86_64", "q35", true},
+		{"uefi", "x86_64", "", true},
+		{"uefi", "x86_64", "pc", false},
+		{"uefi", "aarch64", "pc-q35-2.12", false},
+		{"bios", "x86_64", "pc-q35-2.12", false},
+	}
+	for _, tt := range tests {
+		if got := desc.supports(tt.interfaceType, tt.arch, tt.machine); got != tt.want {
+			t.Errorf("%s %s/%s: got %v, want %v", tt.interfaceType, tt.arch, tt.machine, got, tt.want)
+		}
+	}
+}
+
+func TestConvertFirmware(t *testing.T) {
+	tests := []struct {
+		name       string
+		machine    string
+		conf       *FirmwareConfig
+		wantLoader *libvirtxml.DomainLoader
+		wantSMM    bool
+		wantErr    bool
+	}{
+		{
+			name:    "no firmware profile",
+			machine: "q35",
+		},
+		{
+			name:    "BIOS",
+			machine: "q35",
+			conf:    &FirmwareConfig{Type: FirmwareBIOS},
+		},
+		{
+			name:       "UEFI",
+			machine:    "pc-i440fx-2.12",
+			conf:       &FirmwareConfig{Type: FirmwareEFI},
+			wantLoader: &libvirtxml.DomainLoader{Readonly: "yes", Type: "pflash"},
+		},
+		{
+			name:       "secure boot",
+			machine:    "pc-q35-2.12",
+			conf:       &FirmwareConfig{Type: FirmwareEFI, SecureBoot: true},
+			wantLoader: &libvirtxml.DomainLoader{Readonly: "yes", Type: "pflash", Secure: "yes"},
+			wantSMM:    true,
+		},
+		{
+			name:    "secure boot on i440fx",
+			machine: "pc-i440fx-2.12",
+			conf:    &FirmwareConfig{Type: FirmwareEFI, SecureBoot: true},
+			wantErr: true,
+		},
+		{
+			name:    "secure boot with BIOS",
+			machine: "q35",
+			conf:    &FirmwareConfig{SecureBoot: true},
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			machine: "q35",
+			conf:    &FirmwareConfig{Type: "coreboot"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{Firmware: tt.conf})
+			domain := &libvirtxml.Domain{
+				OS: &libvirtxml.DomainOS{
+					Type: &libvirtxml.DomainOSType{Type: "hvm", Machine: tt.machine},
+				},
+			}
+			err := convert_api_Firmware_To_api_OS(domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(domain.OS.Loader, tt.wantLoader) {
+				t.Errorf("got loader %+v, want %+v", domain.OS.Loader, tt.wantLoader)
+			}
+			if tt.wantLoader != nil {
+				want := testBaseDiskPath + "/default/testvmi/nvram/efivars.fd"
+				if domain.OS.NVRam == nil || domain.OS.NVRam.NVRam != want {
+					t.Errorf("got variable store %+v, want %s", domain.OS.NVRam, want)
+				}
+			} else if domain.OS.NVRam != nil {
+				t.Errorf("unexpected variable store %+v", domain.OS.NVRam)
+			}
+			gotSMM := domain.Features != nil && domain.Features.SMM != nil && domain.Features.SMM.State == "on"
+			if gotSMM != tt.wantSMM {
+				t.Errorf("got SMM: %v, want %v", gotSMM, tt.wantSMM)
+			}
+		})
+	}
+}
+
+func TestCompleteFirmware(t *testing.T) {
+	unenrolled := fixtureFirmwares()[0]
+	unenrolled.Features = []string{"secure-boot", "requires-smm"}
+	tests := []struct {
+		name         string
+		firmwares    []FirmwareDescriptor
+		machine      string
+		arch         string
+		loader       *libvirtxml.DomainLoader
+		smm          bool
+		wantPath     string
+		wantTemplate string
+		wantWarning  string
+		wantErr      bool
+	}{
+		{
+			name:      "BIOS",
+			firmwares: fixtureFirmwares(),
+			machine:   "q35",
+		},
+		{
+			name:      "loader set by the profiles",
+			firmwares: fixtureFirmwares(),
+			machine:   "q35",
+			loader:    &libvirtxml.DomainLoader{Type: "pflash", Path: "/opt/OVMF_CODE.fd"},
+			wantPath:  "/opt/OVMF_CODE.fd",
+		},
+		{
+			name:        "no descriptors",
+			machine:     "q35",
+			loader:      &libvirtxml.DomainLoader{Type: "pflash"},
+			wantWarning: "No firmware descriptor",
+		},
+		{
+			name:         "UEFI",
+			firmwares:    fixtureFirmwares(),
+			machine:      "pc-i440fx-2.12",
+			loader:       &libvirtxml.DomainLoader{Type: "pflash"},
+			wantPath:     "/usr/share/OVMF/OVMF_CODE.fd",
+			wantTemplate: "/usr/share/OVMF/OVMF_VARS.fd",
+		},
+		{
+			name:         "secure boot",
+			firmwares:    fixtureFirmwares(),
+			machine:      "q35",
+			loader:       &libvirtxml.DomainLoader{Type: "pflash", Secure: "yes"},
+			smm:          true,
+			wantPath:     "/usr/share/OVMF/OVMF_CODE.secboot.fd",
+			wantTemplate: "/usr/share/OVMF/OVMF_VARS.secboot.fd",
+		},
+		{
+			name:      "secure boot without SMM",
+			firmwares: fixtureFirmwares(),
+			machine:   "q35",
+			loader:    &libvirtxml.DomainLoader{Type: "pflash", Secure: "yes"},
+			wantErr:   true,
+		},
+		{
+			name:         "secure boot without enrolled keys",
+			firmwares:    []FirmwareDescriptor{unenrolled},
+			machine:      "q35",
+			loader:       &libvirtxml.DomainLoader{Type: "pflash", Secure: "yes"},
+			smm:          true,
+			wantPath:     "/usr/share/OVMF/OVMF_CODE.secboot.fd",
+			wantTemplate: "/usr/share/OVMF/OVMF_VARS.secboot.fd",
+			wantWarning:  "has no keys enrolled",
+		},
+		{
+			name:      "unsupported architecture",
+			firmwares: fixtureFirmwares(),
+			machine:   "virt",
+			arch:      "aarch64",
+			loader:    &libvirtxml.DomainLoader{Type: "pflash"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			c.Host.Firmwares = tt.firmwares
+			dom := &libvirtxml.Domain{
+				OS: &libvirtxml.DomainOS{
+					Type:   &libvirtxml.DomainOSType{Type: "hvm", Arch: tt.arch, Machine: tt.machine},
+					Loader: tt.loader,
+				},
+			}
+			if tt.smm {
+				dom.Features = &libvirtxml.DomainFeatureList{SMM: &libvirtxml.DomainFeatureSMM{State: "on"}}
+			}
+
+			err := completeFirmware(dom, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			path := ""
+			if dom.OS.Loader != nil {
+				path = dom.OS.Loader.Path
+			}
+			if path != tt.wantPath {
+				t.Errorf("got loader %s, want %s", path, tt.wantPath)
+			}
+			template := ""
+			if dom.OS.NVRam != nil {
+				template = dom.OS.NVRam.Template
+			}
+			if template != tt.wantTemplate {
+				t.Errorf("got variable store template %s, want %s", template, tt.wantTemplate)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// The fixtures shared by the tests of the package
+
+const (
+	testBaseDiskPath = "/var/lib/virt-profiles"
+	sriovResource    = "intel.com/sriov"
+)
+
+// newTestVMI returns a VMI with 1Gi of memory and a virtio disk on a PVC, to be customized by the tests
+func newTestVMI() *k6tv1.VirtualMachineInstance {
+	vmi := &k6tv1.VirtualMachineInstance{}
+	vmi.Name = "testvmi"
+	vmi.Namespace = "default"
+	vmi.Spec.Domain.Resources.Requests = k8sv1.ResourceList{
+		k8sv1.ResourceMemory: k8sres.MustParse("1Gi"),
+	}
+	vmi.Spec.Domain.Devices.Disks = []k6tv1.Disk{
+		{
+			Name: "root",
+			DiskDevice: k6tv1.DiskDevice{
+				Disk: &k6tv1.DiskTarget{Bus: "virtio"},
+			},
+		},
+	}
+	vmi.Spec.Volumes = []k6tv1.Volume{
+		{
+			Name: "root",
+			VolumeSource: k6tv1.VolumeSource{
+				PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{ClaimName: "root"},
+			},
+		},
+	}
+	return vmi
+}
+
+// newTestProfiler returns a profiler for a DefaultHost, with the host path layout rooted in testBaseDiskPath
+func newTestProfiler() *Profiler {
+	return NewProfiler(testBaseDiskPath).SetPathLayout(HostPathLayout()).SetHost(DefaultHost())
+}
+
+// translateTestVMI translates the VMI with a test profiler
+func translateTestVMI(vmi *k6tv1.VirtualMachineInstance) (*libvirtxml.Domain, []string, error) {
+	p := newTestProfiler()
+	p.SetVirtualMachine(vmi)
+	return p.TranslateSpecs(&vmi.Spec.Domain)
+}
+
+// newTestContext returns the context of the translation of a newTestVMI for a DefaultHost,
+// using the given profile
+func newTestContext(spec *ProfileSpec) *ConverterContext {
+	vmi := newTestVMI()
+	if spec == nil {
+		spec = &ProfileSpec{}
+	}
+	return &ConverterContext{
+		VirtualMachine:  vmi,
+		Host:            DefaultHost(),
+		Profile:         spec,
+		Paths:           newPathResolver(HostPathLayout(), testBaseDiskPath, vmi.Namespace, vmi.Name),
+		LibvirtSecrets:  []Secret{},
+		PCIDevicesInUse: make(map[string]bool),
+		Warnings:        []string{},
+	}
+}
+
+// newTestDisk returns a disk with the qemu driver, given alias and device, and a target on the bus, if any
+func newTestDisk(alias, device, bus string) *libvirtxml.DomainDisk {
+	disk := &libvirtxml.DomainDisk{
+		Device: device,
+		Driver: &libvirtxml.DomainDiskDriver{Name: "qemu"},
+		Alias:  &libvirtxml.DomainAlias{Name: alias},
+	}
+	if bus != "" {
+		disk.Target = &libvirtxml.DomainDiskTarget{Bus: bus, Dev: alias}
+	}
+	return disk
+}
+
+// newTestDomain returns a domain of the given machine type with an interface named default,
+// the given number of virtio disks, named vda, vdb..., and of SCSI disks, named sda, sdb...
+func newTestDomain(machine string, virtioDisks, scsiDisks int) *libvirtxml.Domain {
+	domain := &libvirtxml.Domain{
+		OS: &libvirtxml.DomainOS{
+			Type: &libvirtxml.DomainOSType{Type: "hvm", Machine: machine},
+		},
+		Devices: &libvirtxml.DomainDeviceList{
+			Interfaces: []libvirtxml.DomainInterface{
+				{Alias: &libvirtxml.DomainAlias{Name: "default"}},
+			},
+		},
+	}
+	for i := 0; i < virtioDisks+scsiDisks; i++ {
+		prefix, bus, index := "vd", "virtio", i
+		if i >= virtioDisks {
+			prefix, bus, index = "sd", "scsi", i-virtioDisks
+		}
+		name := formatDeviceName(prefix, index)
+		domain.Devices.Disks = append(domain.Devices.Disks, libvirtxml.DomainDisk{
+			Target: &libvirtxml.DomainDiskTarget{Dev: name, Bus: bus},
+			Alias:  &libvirtxml.DomainAlias{Name: name},
+		})
+	}
+	return domain
+}
+
+// newTestSecret returns a Secret of the default namespace holding the given data
+func newTestSecret(name string, data map[string]string) *k8sv1.Secret {
+	secret := &k8sv1.Secret{Data: map[string][]byte{}}
+	secret.Name = name
+	secret.Namespace = "default"
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	return secret
+}
+
+// fixturePCIDevices are the host devices used by the tests: four VFs and a GPU
+func fixturePCIDevices() []PCIDevice {
+	return []PCIDevice{
+		{Address: "0000:81:00.1", ResourceName: sriovResource},
+		{Address: "0000:81:00.2", ResourceName: sriovResource},
+		{Address: "0000:81:00.3", ResourceName: sriovResource},
+		{Address: "0000:81:00.4", ResourceName: sriovResource},
+		{Address: "0000:02:00.0", ResourceName: "nvidia.com/gpu"},
+	}
+}
+
+// newBridgeInterface returns an interface with the bridge binding
+func newBridgeInterface(name string) *k6tv1.Interface {
+	return &k6tv1.Interface{
+		Name: name,
+		InterfaceBindingMethod: k6tv1.InterfaceBindingMethod{
+			Bridge: &k6tv1.InterfaceBridge{},
+		},
+	}
+}
+
+// newTestClaim returns a claim with the given volume mode
+func newTestClaim(namespace, name string, mode k8sv1.PersistentVolumeMode) *k8sv1.PersistentVolumeClaim {
+	claim := &k8sv1.PersistentVolumeClaim{}
+	claim.Namespace = namespace
+	claim.Name = name
+	claim.Spec.VolumeMode = &mode
+	return claim
+}
+
+// newProfilesTestDomain returns a translated-like domain, with an aliased and an unaliased disk,
+// a CPU feature and a timer
+func newProfilesTestDomain() *libvirtxml.Domain {
+	return &libvirtxml.Domain{
+		Type: "kvm",
+		Name: "default_testvmi",
+		VCPU: &libvirtxml.DomainVCPU{Value: 1},
+		CPU: &libvirtxml.DomainCPU{
+			Mode:     CPUModeHostModel,
+			Features: []libvirtxml.DomainCPUFeature{{Policy: "require", Name: "vmx"}},
+		},
+		Clock: &libvirtxml.DomainClock{
+			Offset: "utc",
+			Timer:  []libvirtxml.DomainTimer{{Name: "rtc", TickPolicy: "catchup"}},
+		},
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				{
+					Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+					Alias:  &libvirtxml.DomainAlias{Name: "root"},
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw"},
+				},
+				{
+					Target: &libvirtxml.DomainDiskTarget{Dev: "vdb", Bus: "virtio"},
+					Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
+				},
+			},
+		},
+	}
+}
+
+// newTestPreset returns a preset with the given priority annotation, if any, and domain spec
+func newTestPreset(name, annotation, priority string, spec *k6tv1.DomainSpec) k6tv1.VirtualMachineInstancePreset {
+	preset := k6tv1.VirtualMachineInstancePreset{}
+	preset.Name = name
+	if annotation != "" {
+		preset.Annotations = map[string]string{annotation: priority}
+	}
+	if spec == nil {
+		spec = &k6tv1.DomainSpec{}
+	}
+	preset.Spec.Domain = spec
+	return preset
+}
+
+func containsWarning(warnings []string, text string) bool {
+	for _, warning := range warnings {
+		if strings.Contains(warning, text) {
+			return true
+		}
+	}
+	return false
+}
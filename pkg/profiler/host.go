@@ -0,0 +1,110 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"os"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+const (
+	VirtTypeKVM  = "kvm"
+	VirtTypeQEMU = "qemu"
+)
+
+// Host describes the host on which the translated VM will run.
+// The translation only depends on this description, never on the host running the profiler.
+type Host struct {
+	// VirtTypes lists the virtualization types available on the host, like "kvm" or "qemu"
+	VirtTypes []string
+	// Caps are the host capabilities, if known
+	Caps *libvirtxml.Caps
+	// PCIDevices lists the host devices available for passthrough
+	PCIDevices []PCIDevice
+	// CPUSet lists the host CPUs the VM can be pinned to, like "2-7,10"
+	CPUSet string
+	// Firmwares are the firmware descriptors of the host, in priority order
+	Firmwares []FirmwareDescriptor
+}
+
+// DefaultHost returns the description of a host capable of hardware virtualization.
+// The profiler assumes it, with a warning, when no host was set with SetHost.
+func DefaultHost() *Host {
+	return &Host{
+		VirtTypes: []string{VirtTypeKVM, VirtTypeQEMU},
+	}
+}
+
+// ensureHost makes the context use a DefaultHost if the host which will run the VM is unknown
+func ensureHost(c *ConverterContext) {
+	if c.Host == nil {
+		c.Host = DefaultHost()
+		c.warn("The host which will run the VM is unknown, assuming it supports hardware virtualization")
+	}
+}
+
+// NewHostFromCapabilities builds the host description from its libvirt capabilities XML,
+// as reported by `virsh capabilities`.
+func NewHostFromCapabilities(capsXML string) (*Host, error) {
+	caps := &libvirtxml.Caps{}
+	err := caps.Unmarshal(capsXML)
+	if err != nil {
+		return nil, fmt.Errorf("malformed host capabilities: %v", err)
+	}
+	host := &Host{
+		VirtTypes: []string{},
+		Caps:      caps,
+	}
+	for _, guest := range caps.Guests {
+		if guest.OSType != "hvm" {
+			continue
+		}
+		for _, dom := range guest.Arch.Domains {
+			if !host.Supports(dom.Type) {
+				host.VirtTypes = append(host.VirtTypes, dom.Type)
+			}
+		}
+	}
+	return host, nil
+}
+
+// LocalHost returns the description of the host running the profiler, probing /dev/kvm.
+// Meant for tools which translate and run the VMs on the same host.
+func LocalHost() (*Host, error) {
+	host := &Host{
+		VirtTypes: []string{VirtTypeQEMU},
+	}
+	_, err := os.Stat("/dev/kvm")
+	if err == nil {
+		host.VirtTypes = append(host.VirtTypes, VirtTypeKVM)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return host, nil
+}
+
+// Supports tells if the host supports the given virtualization type
+func (h *Host) Supports(virtType string) bool {
+	for _, vt := range h.VirtTypes {
+		if vt == virtType {
+			return true
+		}
+	}
+	return false
+}
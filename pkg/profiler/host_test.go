@@ -0,0 +1,183 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestNewHostFromCapabilities(t *testing.T) {
+	tests := []struct {
+		name    string
+		caps    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "kvm host",
+			caps: `<capabilities>
+  <host><cpu><arch>x86_64</arch></cpu></host>
+  <guest>
+    <os_type>hvm</os_type>
+    <arch name="x86_64"><domain type="qemu"/><domain type="kvm"/></arch>
+  </guest>
+  <guest>
+    <os_type>hvm</os_type>
+    <arch name="i686"><domain type="qemu"/><domain type="kvm"/></arch>
+  </guest>
+</capabilities>`,
+			want: []string{VirtTypeQEMU, VirtTypeKVM},
+		},
+		{
+			name: "emulation only",
+			caps: `<capabilities>
+  <guest>
+    <os_type>hvm</os_type>
+    <arch name="x86_64"><domain type="qemu"/></arch>
+  </guest>
+  <guest>
+    <os_type>xen</os_type>
+    <arch name="x86_64"><domain type="xen"/></arch>
+  </guest>
+</capabilities>`,
+			want: []string{VirtTypeQEMU},
+		},
+		{
+			name:    "malformed",
+			caps:    `<capabilities>`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := NewHostFromCapabilities(tt.caps)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(host.VirtTypes, tt.want) {
+				t.Errorf("got virt types %v, want %v", host.VirtTypes, tt.want)
+			}
+			if host.Caps == nil {
+				t.Errorf("missing the capabilities")
+			}
+		})
+	}
+}
+
+func TestLocalHost(t *testing.T) {
+	host, err := LocalHost()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !host.Supports(VirtTypeQEMU) {
+		t.Errorf("the local host always supports emulation")
+	}
+}
+
+func TestHostSupports(t *testing.T) {
+	host := &Host{VirtTypes: []string{VirtTypeQEMU}}
+	tests := []struct {
+		virtType string
+		want     bool
+	}{
+		{VirtTypeQEMU, true},
+		{VirtTypeKVM, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := host.Supports(tt.virtType); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.virtType, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		host         *Host
+		useEmulation bool
+		wantType     string
+		wantWarning  string
+		wantErr      bool
+	}{
+		{
+			name:        "unknown host",
+			wantType:    VirtTypeKVM,
+			wantWarning: "host which will run the VM is unknown",
+		},
+		{
+			name:     "kvm host",
+			host:     DefaultHost(),
+			wantType: VirtTypeKVM,
+		},
+		{
+			name:         "emulation",
+			host:         &Host{VirtTypes: []string{VirtTypeQEMU}},
+			useEmulation: true,
+			wantType:     VirtTypeQEMU,
+			wantWarning:  "Using software emulation",
+		},
+		{
+			name:    "emulation not allowed",
+			host:    &Host{VirtTypes: []string{VirtTypeQEMU}},
+			wantErr: true,
+		},
+		{
+			name:         "no virtualization at all",
+			host:         &Host{VirtTypes: []string{}},
+			useEmulation: true,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProfiler()
+			p.SetUseEmulation(tt.useEmulation)
+			p.SetHost(tt.host)
+			dom, _, warnings, err := p.translate(context.Background(), newTestVMI())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if dom.Type != tt.wantType {
+				t.Errorf("got domain type %s, want %s", dom.Type, tt.wantType)
+			}
+			if tt.wantWarning == "" {
+				if len(warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", warnings)
+				}
+			} else if !containsWarning(warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", warnings, tt.wantWarning)
+			}
+		})
+	}
+}
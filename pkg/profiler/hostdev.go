@@ -0,0 +1,337 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// PCIDevice describes a PCI device of the host which can be passed through to the VMs
+type PCIDevice struct {
+	// Address is the PCI address of the device, like 0000:81:00.1
+	Address string `json:"address"`
+	// ResourceName is the name of the extended resource the device is advertised as, like intel.com/sriov
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// ParsePCIAddress parses a PCI address in the domain:bus:slot.function form; the domain is optional
+func ParsePCIAddress(address string) (*libvirtxml.DomainAddressPCI, error) {
+	var domain, bus, slot, function uint
+	_, err := fmt.Sscanf(address, "%x:%x:%x.%x", &domain, &bus, &slot, &function)
+	if err != nil {
+		domain = 0
+		_, err = fmt.Sscanf(address, "%x:%x.%x", &bus, &slot, &function)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed PCI address %s", address)
+	}
+	return &libvirtxml.DomainAddressPCI{
+		Domain:   &domain,
+		Bus:      &bus,
+		Slot:     &slot,
+		Function: &function,
+	}, nil
+}
+
+func formatPCIAddress(addr *libvirtxml.DomainAddressPCI) string {
+	value := func(v *uint) uint {
+		if v == nil {
+			return 0
+		}
+		return *v
+	}
+	return fmt.Sprintf("%04x:%02x:%02x.%x", value(addr.Domain), value(addr.Bus), value(addr.Slot), value(addr.Function))
+}
+
+// allocatePCIDevice picks a host device not yet in use, either by address or by resource name
+func (c *ConverterContext) allocatePCIDevice(address, resourceName string) (*libvirtxml.DomainAddressPCI, error) {
+	if c.PCIDevicesInUse == nil {
+		c.PCIDevicesInUse = make(map[string]bool)
+	}
+	if address != "" {
+		addr, err := ParsePCIAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		key := formatPCIAddress(addr)
+		if c.PCIDevicesInUse[key] {
+			return nil, fmt.Errorf("PCI device %s already in use", key)
+		}
+		c.PCIDevicesInUse[key] = true
+		return addr, nil
+	}
+
+	if resourceName == "" {
+		return nil, fmt.Errorf("host devices need either an address or a resource name")
+	}
+	if c.Host == nil {
+		return nil, fmt.Errorf("no host device available for %s", resourceName)
+	}
+	for _, dev := range c.Host.PCIDevices {
+		if dev.ResourceName != resourceName {
+			continue
+		}
+		addr, err := ParsePCIAddress(dev.Address)
+		if err != nil {
+			return nil, err
+		}
+		key := formatPCIAddress(addr)
+		if c.PCIDevicesInUse[key] {
+			continue
+		}
+		c.PCIDevicesInUse[key] = true
+		return addr, nil
+	}
+	return nil, fmt.Errorf("no host device available for %s", resourceName)
+}
+
+// pciDevicesInUseFor counts the host devices with the given resource name already in use,
+// like the VFs taken by the SR-IOV interfaces
+func (c *ConverterContext) pciDevicesInUseFor(resourceName string) int64 {
+	if c.Host == nil {
+		return 0
+	}
+	count := int64(0)
+	for _, dev := range c.Host.PCIDevices {
+		if dev.ResourceName != resourceName {
+			continue
+		}
+		addr, err := ParsePCIAddress(dev.Address)
+		if err != nil {
+			continue
+		}
+		if c.PCIDevicesInUse[formatPCIAddress(addr)] {
+			count++
+		}
+	}
+	return count
+}
+
+func newPCIHostdev(name string, addr *libvirtxml.DomainAddressPCI) libvirtxml.DomainHostdev {
+	return libvirtxml.DomainHostdev{
+		Managed: "yes",
+		SubsysPCI: &libvirtxml.DomainHostdevSubsysPCI{
+			Source: &libvirtxml.DomainHostdevSubsysPCISource{
+				Address: addr,
+			},
+		},
+		Alias: &libvirtxml.DomainAlias{
+			Name: name,
+		},
+	}
+}
+
+// isPCIResourceName tells if a resource may be provided by host PCI devices: the extended resources are,
+// but for the ones of the KubeVirt device plugins, which provide host character devices.
+func isPCIResourceName(name string) bool {
+	if !strings.Contains(name, "/") {
+		return false
+	}
+	return !strings.HasPrefix(name, "kubernetes.io/") && !strings.HasPrefix(name, "devices.kubevirt.io/")
+}
+
+// convert_v1_HostDevices_To_api_Hostdevs passes through the host devices requested as extended resources,
+// and the ones listed in the profile. The devices of a resource already used by the SR-IOV interfaces
+// are part of the requested quantity, so it must run after the interfaces are translated.
+// Requesting an extended resource which none of the known host PCI devices provides is an error.
+func convert_v1_HostDevices_To_api_Hostdevs(vmi *k6tv1.VirtualMachineInstance, domain *libvirtxml.Domain, c *ConverterContext) error {
+	knownResources := map[string]bool{}
+	if c.Host != nil {
+		for _, dev := range c.Host.PCIDevices {
+			if dev.ResourceName != "" {
+				knownResources[dev.ResourceName] = true
+			}
+		}
+	}
+
+	// extended resources must be set in the limits; the requests, if set, must match
+	requested := vmi.Spec.Domain.Resources.Limits
+	if len(requested) == 0 {
+		requested = vmi.Spec.Domain.Resources.Requests
+	}
+	names := []string{}
+	for name := range requested {
+		if knownResources[string(name)] {
+			names = append(names, string(name))
+			continue
+		}
+		if !isPCIResourceName(string(name)) {
+			continue
+		}
+		if len(knownResources) == 0 {
+			c.warn("Cannot pass through the devices of %s: the host PCI devices are unknown", name)
+			continue
+		}
+		return &TranslationError{Field: "resources.limits", Value: string(name), Reason: "no host PCI device provides this resource"}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		quantity := requested[k8sv1.ResourceName(name)]
+		for i := c.pciDevicesInUseFor(name); i < quantity.Value(); i++ {
+			addr, err := c.allocatePCIDevice("", name)
+			if err != nil {
+				return err
+			}
+			alias := fmt.Sprintf("hostdev%d", len(domain.Devices.Hostdevs))
+			domain.Devices.Hostdevs = append(domain.Devices.Hostdevs, newPCIHostdev(alias, addr))
+		}
+	}
+
+	if c.Profile.HostDevices == nil {
+		return nil
+	}
+	for _, req := range c.Profile.HostDevices.Devices {
+		addr, err := c.allocatePCIDevice(req.Address, req.ResourceName)
+		if err != nil {
+			return fmt.Errorf("host device %s: %v", req.Name, err)
+		}
+		alias := req.Name
+		if alias == "" {
+			alias = fmt.Sprintf("hostdev%d", len(domain.Devices.Hostdevs))
+		}
+		domain.Devices.Hostdevs = append(domain.Devices.Hostdevs, newPCIHostdev(alias, addr))
+	}
+	return nil
+}
+
+func bindSRIOV(iface *k6tv1.Interface, attachment *NetworkAttachment, domIface *libvirtxml.DomainInterface, _ *libvirtxml.Domain, c *ConverterContext) error {
+	addr, err := c.allocatePCIDevice(attachment.Address, attachment.ResourceName)
+	if err != nil {
+		return fmt.Errorf("interface %s: %v", iface.Name, err)
+	}
+	if attachment.MTU > 0 {
+		c.warn("Ignoring the MTU of %s, set by the VF driver", iface.Name)
+	}
+	// the VF is the NIC seen by the guest, so the emulated model and backend do not apply
+	domIface.Model = nil
+	domIface.Driver = nil
+	domIface.MTU = nil
+	domIface.Managed = "yes"
+	domIface.Source = &libvirtxml.DomainInterfaceSource{
+		Hostdev: &libvirtxml.DomainInterfaceSourceHostdev{
+			PCI: &libvirtxml.DomainHostdevSubsysPCISource{
+				Address: addr,
+			},
+		},
+	}
+	return nil
+}
+
+// completeHostDevices applies the profile settings to the PCI host devices and SR-IOV interfaces.
+// Devices are matched with their resource name through the host device list.
+func completeHostDevices(dom *libvirtxml.Domain, c *ConverterContext) error {
+	if c.Profile.HostDevices == nil || dom.Devices == nil {
+		return nil
+	}
+	conf := c.Profile.HostDevices
+
+	settingsFor := func(addr *libvirtxml.DomainAddressPCI) HostDeviceSettings {
+		settings := HostDeviceSettings{}
+		if conf.Defaults != nil {
+			settings = *conf.Defaults
+		}
+		if addr == nil || c.Host == nil {
+			return settings
+		}
+		key := formatPCIAddress(addr)
+		for _, dev := range c.Host.PCIDevices {
+			devAddr, err := ParsePCIAddress(dev.Address)
+			if err != nil || formatPCIAddress(devAddr) != key {
+				continue
+			}
+			if override, ok := conf.Resources[dev.ResourceName]; ok {
+				mergeHostDeviceSettings(&settings, &override)
+			}
+		}
+		return settings
+	}
+
+	for i := range dom.Devices.Hostdevs {
+		hostdev := &dom.Devices.Hostdevs[i]
+		if hostdev.SubsysPCI == nil {
+			continue
+		}
+		var addr *libvirtxml.DomainAddressPCI
+		if hostdev.SubsysPCI.Source != nil {
+			addr = hostdev.SubsysPCI.Source.Address
+		}
+		settings := settingsFor(addr)
+		if settings.Managed != nil {
+			hostdev.Managed = boolToYesNo(settings.Managed, true)
+		}
+		if settings.Driver != "" {
+			hostdev.SubsysPCI.Driver = &libvirtxml.DomainHostdevSubsysPCIDriver{
+				Name: settings.Driver,
+			}
+		}
+		if rom := settings.rom(); rom != nil {
+			hostdev.ROM = rom
+		}
+	}
+
+	for i := range dom.Devices.Interfaces {
+		iface := &dom.Devices.Interfaces[i]
+		if iface.Source == nil || iface.Source.Hostdev == nil || iface.Source.Hostdev.PCI == nil {
+			continue
+		}
+		settings := settingsFor(iface.Source.Hostdev.PCI.Address)
+		if settings.Managed != nil {
+			iface.Managed = boolToYesNo(settings.Managed, true)
+		}
+		if settings.Driver != "" {
+			iface.Driver = &libvirtxml.DomainInterfaceDriver{
+				Name: settings.Driver,
+			}
+		}
+		if rom := settings.rom(); rom != nil {
+			iface.ROM = rom
+		}
+	}
+	return nil
+}
+
+func mergeHostDeviceSettings(dst, src *HostDeviceSettings) {
+	if src.Managed != nil {
+		dst.Managed = src.Managed
+	}
+	if src.Driver != "" {
+		dst.Driver = src.Driver
+	}
+	if src.ROMBar != "" {
+		dst.ROMBar = src.ROMBar
+	}
+	if src.ROMFile != "" {
+		dst.ROMFile = src.ROMFile
+	}
+}
+
+func (s *HostDeviceSettings) rom() *libvirtxml.DomainROM {
+	if s.ROMBar == "" && s.ROMFile == "" {
+		return nil
+	}
+	return &libvirtxml.DomainROM{
+		Bar:  s.ROMBar,
+		File: s.ROMFile,
+	}
+}
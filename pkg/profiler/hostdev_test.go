@@ -0,0 +1,231 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func hostdevAddresses(domain *libvirtxml.Domain) []string {
+	addrs := []string{}
+	for _, hostdev := range domain.Devices.Hostdevs {
+		addrs = append(addrs, formatPCIAddress(hostdev.SubsysPCI.Source.Address))
+	}
+	return addrs
+}
+
+func TestParsePCIAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		wantErr bool
+	}{
+		{address: "0000:81:00.1", want: "0000:81:00.1"},
+		{address: "81:00.1", want: "0000:81:00.1"},
+		{address: "0001:0a:1f.7", want: "0001:0a:1f.7"},
+		{address: "eth0", wantErr: true},
+	}
+	for _, tt := range tests {
+		addr, err := ParsePCIAddress(tt.address)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.address, err)
+			continue
+		}
+		if got := formatPCIAddress(addr); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestConvertHostDevices(t *testing.T) {
+	tests := []struct {
+		name string
+		// limits are the extended resources of the VMI
+		limits map[string]string
+		// sriovInterfaces is the number of SR-IOV interfaces bound before the host devices
+		sriovInterfaces int
+		profile         *HostDevicesConfig
+		// unknownHost drops the host PCI devices
+		unknownHost bool
+		want        []string
+		wantWarning string
+		wantErr     bool
+	}{
+		{
+			name:   "resources only",
+			limits: map[string]string{sriovResource: "2", "nvidia.com/gpu": "1"},
+			want:   []string{"0000:81:00.1", "0000:81:00.2", "0000:02:00.0"},
+		},
+		{
+			name:    "unknown resources are rejected",
+			limits:  map[string]string{"example.com/unknown": "1"},
+			wantErr: true,
+		},
+		{
+			name:   "resources of the KubeVirt device plugins are not PCI devices",
+			limits: map[string]string{"devices.kubevirt.io/kvm": "1", "cpu": "2"},
+			want:   []string{},
+		},
+		{
+			name:        "resources on an unknown host",
+			limits:      map[string]string{"nvidia.com/gpu": "1"},
+			unknownHost: true,
+			want:        []string{},
+			wantWarning: "the host PCI devices are unknown",
+		},
+		{
+			name:            "VFs used by the SR-IOV interfaces are not allocated again",
+			limits:          map[string]string{sriovResource: "2"},
+			sriovInterfaces: 2,
+			want:            []string{},
+		},
+		{
+			name:            "VFs left after the SR-IOV interfaces",
+			limits:          map[string]string{sriovResource: "3"},
+			sriovInterfaces: 1,
+			want:            []string{"0000:81:00.2", "0000:81:00.3"},
+		},
+		{
+			name:    "too many devices requested",
+			limits:  map[string]string{sriovResource: "5"},
+			wantErr: true,
+		},
+		{
+			name: "profile devices",
+			profile: &HostDevicesConfig{
+				Devices: []HostDeviceRequest{
+					{Name: "gpu", ResourceName: "nvidia.com/gpu"},
+					{Name: "vf", Address: "0000:81:00.4"},
+				},
+			},
+			want: []string{"0000:02:00.0", "0000:81:00.4"},
+		},
+		{
+			name:   "profile device already in use",
+			limits: map[string]string{"nvidia.com/gpu": "1"},
+			profile: &HostDevicesConfig{
+				Devices: []HostDeviceRequest{
+					{Name: "gpu", Address: "0000:02:00.0"},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			if !tt.unknownHost {
+				c.Host.PCIDevices = fixturePCIDevices()
+			}
+			c.Profile.HostDevices = tt.profile
+			domain := &libvirtxml.Domain{
+				Devices: &libvirtxml.DomainDeviceList{},
+			}
+			for i := 0; i < tt.sriovInterfaces; i++ {
+				iface := &k6tv1.Interface{Name: "sriov"}
+				attachment := &NetworkAttachment{Type: "sriov", ResourceName: sriovResource}
+				err := bindSRIOV(iface, attachment, &libvirtxml.DomainInterface{}, domain, c)
+				if err != nil {
+					t.Fatalf("bindSRIOV: unexpected error: %v", err)
+				}
+			}
+
+			vmi := &k6tv1.VirtualMachineInstance{}
+			vmi.Spec.Domain.Resources.Limits = k8sv1.ResourceList{}
+			for name, value := range tt.limits {
+				vmi.Spec.Domain.Resources.Limits[k8sv1.ResourceName(name)] = k8sres.MustParse(value)
+			}
+
+			err := convert_v1_HostDevices_To_api_Hostdevs(vmi, domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := hostdevAddresses(domain); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got host devices %v, want %v", got, tt.want)
+			}
+			if tt.wantWarning != "" && !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCompleteHostDevices(t *testing.T) {
+	yes := true
+	c := newTestContext(nil)
+	c.Host.PCIDevices = fixturePCIDevices()
+	c.Profile.HostDevices = &HostDevicesConfig{
+		Defaults: &HostDeviceSettings{Driver: "vfio"},
+		Resources: map[string]HostDeviceSettings{
+			"nvidia.com/gpu": {ROMBar: "off", Managed: &yes},
+		},
+	}
+	gpu, _ := ParsePCIAddress("0000:02:00.0")
+	vf, _ := ParsePCIAddress("0000:81:00.1")
+	domain := &libvirtxml.Domain{
+		Devices: &libvirtxml.DomainDeviceList{
+			Hostdevs: []libvirtxml.DomainHostdev{newPCIHostdev("hostdev0", gpu)},
+			Interfaces: []libvirtxml.DomainInterface{
+				{
+					Source: &libvirtxml.DomainInterfaceSource{
+						Hostdev: &libvirtxml.DomainInterfaceSourceHostdev{
+							PCI: &libvirtxml.DomainHostdevSubsysPCISource{Address: vf},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err := completeHostDevices(domain, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	hostdev := domain.Devices.Hostdevs[0]
+	if hostdev.SubsysPCI.Driver == nil || hostdev.SubsysPCI.Driver.Name != "vfio" {
+		t.Errorf("host device: expected the default vfio driver, got %v", hostdev.SubsysPCI.Driver)
+	}
+	if hostdev.ROM == nil || hostdev.ROM.Bar != "off" {
+		t.Errorf("host device: expected the resource ROM settings, got %v", hostdev.ROM)
+	}
+	iface := domain.Devices.Interfaces[0]
+	if iface.Driver == nil || iface.Driver.Name != "vfio" {
+		t.Errorf("interface: expected the default vfio driver, got %v", iface.Driver)
+	}
+	if iface.ROM != nil {
+		t.Errorf("interface: unexpected ROM settings %v", iface.ROM)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// the enlightenments each enlightenment needs to work, as QEMU checks them
+var hypervDependencies = []struct {
+	name     string
+	requires []string
+}{
+	{"synic", []string{"vpindex"}},
+	{"stimer", []string{"synic", "hypervclock"}},
+	{"tlbflush", []string{"vpindex"}},
+	{"ipi", []string{"vpindex"}},
+	{"evmcs", []string{"vapic"}},
+}
+
+// convert_api_HyperV_To_api_Features applies the Hyper-V profile: each enlightenment the VM spec leaves unset
+// takes the profile setting, then the enabled enlightenments are checked against the ones they depend on.
+// A missing dependency is only a warning: QEMU refuses to start the VM, but the domain is still valid.
+func convert_api_HyperV_To_api_Features(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.HyperV
+	if conf != nil {
+		if domain.Features == nil {
+			domain.Features = &libvirtxml.DomainFeatureList{}
+		}
+		if domain.Features.HyperV == nil {
+			domain.Features.HyperV = &libvirtxml.DomainFeatureHyperV{}
+		}
+		hyperv := domain.Features.HyperV
+
+		fillFeatureState(&hyperv.Relaxed, conf.Relaxed)
+		fillFeatureState(&hyperv.VAPIC, conf.VAPIC)
+		fillFeatureState(&hyperv.VPIndex, conf.VPIndex)
+		fillFeatureState(&hyperv.Runtime, conf.Runtime)
+		fillFeatureState(&hyperv.Synic, conf.SyNIC)
+		fillFeatureState(&hyperv.STimer, conf.SyNICTimer)
+		fillFeatureState(&hyperv.Reset, conf.Reset)
+		fillFeatureState(&hyperv.Frequencies, conf.Frequencies)
+		fillFeatureState(&hyperv.ReEnlightenment, conf.Reenlightenment)
+		fillFeatureState(&hyperv.TLBFlush, conf.TLBFlush)
+		fillFeatureState(&hyperv.IPI, conf.IPI)
+		fillFeatureState(&hyperv.EVMCS, conf.EVMCS)
+		if hyperv.Spinlocks == nil && conf.SpinlockRetries != nil {
+			if *conf.SpinlockRetries < 4095 {
+				return fmt.Errorf("hyperv: spinlock retries must be at least 4095, found %d", *conf.SpinlockRetries)
+			}
+			hyperv.Spinlocks = &libvirtxml.DomainFeatureHyperVSpinlocks{
+				DomainFeatureState: libvirtxml.DomainFeatureState{
+					State: "on",
+				},
+				Retries: uint(*conf.SpinlockRetries),
+			}
+		}
+		if hyperv.VendorId == nil && conf.VendorID != "" {
+			if len(conf.VendorID) > 12 {
+				return fmt.Errorf("hyperv: vendor id %s is longer than 12 characters", conf.VendorID)
+			}
+			hyperv.VendorId = &libvirtxml.DomainFeatureHyperVVendorId{
+				DomainFeatureState: libvirtxml.DomainFeatureState{
+					State: "on",
+				},
+				Value: conf.VendorID,
+			}
+		}
+
+		if conf.Clock != nil && findTimer(domain.Clock, "hypervclock") == nil {
+			if domain.Clock == nil {
+				domain.Clock = &libvirtxml.DomainClock{
+					Offset: "utc",
+				}
+			}
+			domain.Clock.Timer = append(domain.Clock.Timer, libvirtxml.DomainTimer{
+				Name:    "hypervclock",
+				Present: boolToYesNo(conf.Clock, true),
+			})
+		}
+	}
+
+	if domain.Features == nil || domain.Features.HyperV == nil {
+		return nil
+	}
+	enabled := hypervEnlightenments(domain)
+	for _, dep := range hypervDependencies {
+		if !enabled[dep.name] {
+			continue
+		}
+		for _, required := range dep.requires {
+			if !enabled[required] {
+				c.warn("Hyper-V enlightenment %s needs %s enabled", dep.name, required)
+			}
+		}
+	}
+	return nil
+}
+
+// fillFeatureState sets a feature from the profile, unless it's set already
+func fillFeatureState(state **libvirtxml.DomainFeatureState, enabled *bool) {
+	if *state != nil || enabled == nil {
+		return
+	}
+	*state = &libvirtxml.DomainFeatureState{
+		State: boolToOnOff(enabled, true),
+	}
+}
+
+// hypervEnlightenments returns the enlightenments enabled in the domain, including the hypervclock timer
+func hypervEnlightenments(domain *libvirtxml.Domain) map[string]bool {
+	hyperv := domain.Features.HyperV
+	isOn := func(state *libvirtxml.DomainFeatureState) bool {
+		return state != nil && state.State == "on"
+	}
+	enabled := map[string]bool{
+		"relaxed":         isOn(hyperv.Relaxed),
+		"vapic":           isOn(hyperv.VAPIC),
+		"vpindex":         isOn(hyperv.VPIndex),
+		"runtime":         isOn(hyperv.Runtime),
+		"synic":           isOn(hyperv.Synic),
+		"stimer":          isOn(hyperv.STimer),
+		"reset":           isOn(hyperv.Reset),
+		"frequencies":     isOn(hyperv.Frequencies),
+		"reenlightenment": isOn(hyperv.ReEnlightenment),
+		"tlbflush":        isOn(hyperv.TLBFlush),
+		"ipi":             isOn(hyperv.IPI),
+		"evmcs":           isOn(hyperv.EVMCS),
+	}
+	if timer := findTimer(domain.Clock, "hypervclock"); timer != nil {
+		enabled["hypervclock"] = timer.Present != "no"
+	}
+	return enabled
+}
+
+func findTimer(clock *libvirtxml.DomainClock, name string) *libvirtxml.DomainTimer {
+	if clock == nil {
+		return nil
+	}
+	for i := range clock.Timer {
+		if clock.Timer[i].Name == name {
+			return &clock.Timer[i]
+		}
+	}
+	return nil
+}
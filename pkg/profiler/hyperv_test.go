@@ -0,0 +1,235 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestConvertFeatureHyperv(t *testing.T) {
+	yes, no := true, false
+	retries := uint32(8191)
+	source := &k6tv1.FeatureHyperv{
+		Relaxed:         &k6tv1.FeatureState{},
+		VAPIC:           &k6tv1.FeatureState{Enabled: &no},
+		Spinlocks:       &k6tv1.FeatureSpinlocks{Retries: &retries},
+		VendorID:        &k6tv1.FeatureVendorID{VendorID: "KVMKVMKVM"},
+		Frequencies:     &k6tv1.FeatureState{Enabled: &yes},
+		Reenlightenment: &k6tv1.FeatureState{Enabled: &yes},
+		TLBFlush:        &k6tv1.FeatureState{Enabled: &yes},
+		IPI:             &k6tv1.FeatureState{Enabled: &no},
+		EVMCS:           &k6tv1.FeatureState{Enabled: &yes},
+	}
+	hyperv := &libvirtxml.DomainFeatureHyperV{}
+	if err := convert_v1_FeatureHyperv_To_api_FeatureHyperv(source, hyperv, newTestContext(nil)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		state *libvirtxml.DomainFeatureState
+		want  string
+	}{
+		{"relaxed", hyperv.Relaxed, "on"},
+		{"vapic", hyperv.VAPIC, "off"},
+		{"spinlocks", &hyperv.Spinlocks.DomainFeatureState, "on"},
+		{"vendor_id", &hyperv.VendorId.DomainFeatureState, "on"},
+		{"frequencies", hyperv.Frequencies, "on"},
+		{"reenlightenment", hyperv.ReEnlightenment, "on"},
+		{"tlbflush", hyperv.TLBFlush, "on"},
+		{"ipi", hyperv.IPI, "off"},
+		{"evmcs", hyperv.EVMCS, "on"},
+		{"unset", hyperv.Synic, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.want == "" {
+				if tt.state != nil {
+					t.Errorf("got state %+v, want none", tt.state)
+				}
+				return
+			}
+			if tt.state == nil || tt.state.State != tt.want {
+				t.Errorf("got state %+v, want %s", tt.state, tt.want)
+			}
+		})
+	}
+	if hyperv.Spinlocks.Retries != 8191 {
+		t.Errorf("got %d spinlock retries, want 8191", hyperv.Spinlocks.Retries)
+	}
+	if hyperv.VendorId.Value != "KVMKVMKVM" {
+		t.Errorf("got vendor id %s, want KVMKVMKVM", hyperv.VendorId.Value)
+	}
+}
+
+func TestConvertHyperV(t *testing.T) {
+	yes, no := true, false
+	shortRetries := uint32(100)
+	retries := uint32(8191)
+	on := &libvirtxml.DomainFeatureState{State: "on"}
+	off := &libvirtxml.DomainFeatureState{State: "off"}
+
+	tests := []struct {
+		name        string
+		hyperv      *libvirtxml.DomainFeatureHyperV
+		timers      []libvirtxml.DomainTimer
+		conf        *HyperVConfig
+		check       func(t *testing.T, domain *libvirtxml.Domain)
+		wantWarning string
+		wantErr     bool
+	}{
+		{
+			name: "no Hyper-V",
+			check: func(t *testing.T, domain *libvirtxml.Domain) {
+				if domain.Features != nil {
+					t.Errorf("got features %+v, want none", domain.Features)
+				}
+			},
+		},
+		{
+			name:   "the VM spec wins over the profile",
+			hyperv: &libvirtxml.DomainFeatureHyperV{Relaxed: off},
+			conf:   &HyperVConfig{Relaxed: &yes, Reset: &yes},
+			check: func(t *testing.T, domain *libvirtxml.Domain) {
+				hyperv := domain.Features.HyperV
+				if hyperv.Relaxed.State != "off" {
+					t.Errorf("got relaxed %s, want off", hyperv.Relaxed.State)
+				}
+				if hyperv.Reset == nil || hyperv.Reset.State != "on" {
+					t.Errorf("got reset %+v, want on", hyperv.Reset)
+				}
+			},
+		},
+		{
+			name: "spinlocks and vendor id",
+			conf: &HyperVConfig{SpinlockRetries: &retries, VendorID: "KVMKVMKVM"},
+			check: func(t *testing.T, domain *libvirtxml.Domain) {
+				hyperv := domain.Features.HyperV
+				if hyperv.Spinlocks == nil || hyperv.Spinlocks.Retries != 8191 {
+					t.Errorf("got spinlocks %+v, want 8191 retries", hyperv.Spinlocks)
+				}
+				if hyperv.VendorId == nil || hyperv.VendorId.Value != "KVMKVMKVM" {
+					t.Errorf("got vendor id %+v, want KVMKVMKVM", hyperv.VendorId)
+				}
+			},
+		},
+		{
+			name: "clock adds the hypervclock timer",
+			conf: &HyperVConfig{Clock: &yes, VPIndex: &yes, SyNIC: &yes, SyNICTimer: &yes},
+			check: func(t *testing.T, domain *libvirtxml.Domain) {
+				timer := findTimer(domain.Clock, "hypervclock")
+				if timer == nil || timer.Present != "yes" {
+					t.Errorf("got hypervclock timer %+v, want present", timer)
+				}
+			},
+		},
+		{
+			name:   "clock keeps the existing timer",
+			timers: []libvirtxml.DomainTimer{{Name: "hypervclock", Present: "no"}},
+			conf:   &HyperVConfig{Clock: &yes},
+			check: func(t *testing.T, domain *libvirtxml.Domain) {
+				if len(domain.Clock.Timer) != 1 || domain.Clock.Timer[0].Present != "no" {
+					t.Errorf("got timers %+v, want the hypervclock one unchanged", domain.Clock.Timer)
+				}
+			},
+		},
+		{
+			name:        "synic without vpindex",
+			conf:        &HyperVConfig{SyNIC: &yes},
+			wantWarning: "synic needs vpindex enabled",
+		},
+		{
+			name:        "stimer without hypervclock",
+			hyperv:      &libvirtxml.DomainFeatureHyperV{VPIndex: on, Synic: on, STimer: on},
+			wantWarning: "stimer needs hypervclock enabled",
+		},
+		{
+			name:        "stimer with hypervclock disabled",
+			hyperv:      &libvirtxml.DomainFeatureHyperV{VPIndex: on, Synic: on, STimer: on},
+			timers:      []libvirtxml.DomainTimer{{Name: "hypervclock", Present: "no"}},
+			wantWarning: "stimer needs hypervclock enabled",
+		},
+		{
+			name:        "tlbflush without vpindex",
+			conf:        &HyperVConfig{TLBFlush: &yes, VPIndex: &no},
+			wantWarning: "tlbflush needs vpindex enabled",
+		},
+		{
+			name:        "ipi without vpindex",
+			hyperv:      &libvirtxml.DomainFeatureHyperV{IPI: on},
+			wantWarning: "ipi needs vpindex enabled",
+		},
+		{
+			name:        "evmcs without vapic",
+			conf:        &HyperVConfig{EVMCS: &yes},
+			wantWarning: "evmcs needs vapic enabled",
+		},
+		{
+			name: "all the dependencies",
+			conf: &HyperVConfig{
+				VAPIC: &yes, VPIndex: &yes, SyNIC: &yes, SyNICTimer: &yes, Clock: &yes,
+				TLBFlush: &yes, IPI: &yes, EVMCS: &yes, Frequencies: &yes, Reenlightenment: &yes,
+			},
+		},
+		{
+			name:    "too few spinlock retries",
+			conf:    &HyperVConfig{SpinlockRetries: &shortRetries},
+			wantErr: true,
+		},
+		{
+			name:    "vendor id too long",
+			conf:    &HyperVConfig{VendorID: "ThisIsTooLongForHyperV"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{}
+			if tt.hyperv != nil {
+				domain.Features = &libvirtxml.DomainFeatureList{HyperV: tt.hyperv}
+			}
+			if tt.timers != nil {
+				domain.Clock = &libvirtxml.DomainClock{Offset: "utc", Timer: tt.timers}
+			}
+			c := newTestContext(&ProfileSpec{HyperV: tt.conf})
+			err := convert_api_HyperV_To_api_Features(domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) > 0 {
+					t.Errorf("unexpected warnings %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+			if tt.check != nil {
+				tt.check(t, domain)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// ioThreadUser is a device which can run its I/O in an IOThread
+type ioThreadUser struct {
+	name   string
+	assign func(id uint)
+}
+
+// convert_api_Devices_To_api_IOThreads allocates the IOThreads as the profile asks, and spreads over them
+// the virtio disks and the virtio-scsi controllers. The devices listed in the assignments get
+// the given IOThread, the others are assigned round-robin, in device order.
+// The IOThreads already in the domain, like the ones of the SCSI controllers, are kept for their
+// devices: the new ones are numbered after them.
+func convert_api_Devices_To_api_IOThreads(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.IOThreads
+	if conf == nil {
+		return nil
+	}
+
+	users := []ioThreadUser{}
+	for i := range domain.Devices.Disks {
+		disk := &domain.Devices.Disks[i]
+		if disk.Target == nil || disk.Target.Bus != "virtio" || disk.Alias == nil {
+			continue
+		}
+		if disk.Driver == nil {
+			disk.Driver = &libvirtxml.DomainDiskDriver{}
+		}
+		driver := disk.Driver
+		users = append(users, ioThreadUser{name: disk.Alias.Name, assign: func(id uint) { driver.IOThread = &id }})
+	}
+	for i := range domain.Devices.Controllers {
+		ctrl := &domain.Devices.Controllers[i]
+		if ctrl.Type != "scsi" || ctrl.Model != DefaultSCSIModel || ctrl.Alias == nil {
+			continue
+		}
+		if ctrl.Driver == nil {
+			ctrl.Driver = &libvirtxml.DomainControllerDriver{}
+		}
+		if ctrl.Driver.IOThread != 0 {
+			// already given one by the SCSI settings
+			continue
+		}
+		driver := ctrl.Driver
+		users = append(users, ioThreadUser{name: ctrl.Alias.Name, assign: func(id uint) { driver.IOThread = id }})
+	}
+
+	count := conf.Count
+	if count == 0 && conf.DisksPerIOThread > 0 {
+		count = (uint(len(users)) + conf.DisksPerIOThread - 1) / conf.DisksPerIOThread
+	}
+	if count == 0 {
+		c.warn("No IOThread allocated: the profile sets neither count nor disksPerIOThread")
+		return nil
+	}
+	reserved := domain.IOThreads
+	domain.IOThreads = reserved + count
+
+	next := uint(0)
+	for _, user := range users {
+		// IOThread ids start from 1
+		id, ok := conf.Assignments[user.name]
+		if ok {
+			if id == 0 || id > domain.IOThreads {
+				return fmt.Errorf("device %s assigned to the IOThread %d, out of range 1-%d", user.name, id, domain.IOThreads)
+			}
+		} else {
+			id = reserved + next%count + 1
+			next++
+		}
+		user.assign(id)
+	}
+
+	return convert_api_IOThreadPins_To_api_CPUTune(domain, conf)
+}
+
+func convert_api_IOThreadPins_To_api_CPUTune(domain *libvirtxml.Domain, conf *IOThreadsConfig) error {
+	pins := map[uint]string{}
+	for _, pin := range conf.Pins {
+		if pin.IOThread == 0 || pin.IOThread > domain.IOThreads {
+			return fmt.Errorf("pinning of the IOThread %d, out of range 1-%d", pin.IOThread, domain.IOThreads)
+		}
+		pins[pin.IOThread] = pin.CPUSet
+	}
+	for id := uint(1); id <= domain.IOThreads; id++ {
+		cpuset, ok := pins[id]
+		if !ok {
+			cpuset = conf.CPUSet
+		}
+		if cpuset == "" {
+			continue
+		}
+		if domain.CPUTune == nil {
+			domain.CPUTune = &libvirtxml.DomainCPUTune{}
+		}
+		domain.CPUTune.IOThreadPin = append(domain.CPUTune.IOThreadPin, libvirtxml.DomainCPUTuneIOThreadPin{
+			IOThread: id,
+			CPUSet:   cpuset,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func diskIOThreads(domain *libvirtxml.Domain) []uint {
+	ids := []uint{}
+	for _, disk := range domain.Devices.Disks {
+		if disk.Driver != nil && disk.Driver.IOThread != nil {
+			ids = append(ids, *disk.Driver.IOThread)
+		}
+	}
+	return ids
+}
+
+func controllerIOThreads(domain *libvirtxml.Domain) []uint {
+	ids := []uint{}
+	for _, ctrl := range domain.Devices.Controllers {
+		if ctrl.Driver != nil && ctrl.Driver.IOThread != 0 {
+			ids = append(ids, ctrl.Driver.IOThread)
+		}
+	}
+	return ids
+}
+
+func TestConvertIOThreads(t *testing.T) {
+	tests := []struct {
+		name            string
+		virtioDisks     int
+		scsiDisks       int
+		scsi            *SCSIConfig
+		conf            *IOThreadsConfig
+		wantIOThreads   uint
+		wantDisks       []uint
+		wantControllers []uint
+		wantPins        []string
+		wantWarning     string
+		wantErr         bool
+	}{
+		{
+			name:        "no IOThreads profile",
+			virtioDisks: 2,
+			wantDisks:   []uint{},
+		},
+		{
+			name:        "neither count nor disks per IOThread",
+			virtioDisks: 2,
+			conf:        &IOThreadsConfig{},
+			wantDisks:   []uint{},
+			wantWarning: "No IOThread allocated",
+		},
+		{
+			name:          "round robin",
+			virtioDisks:   3,
+			conf:          &IOThreadsConfig{Count: 2},
+			wantIOThreads: 2,
+			wantDisks:     []uint{1, 2, 1},
+		},
+		{
+			name:          "disks per IOThread",
+			virtioDisks:   5,
+			conf:          &IOThreadsConfig{DisksPerIOThread: 2},
+			wantIOThreads: 3,
+			wantDisks:     []uint{1, 2, 3, 1, 2},
+		},
+		{
+			name:          "assignments",
+			virtioDisks:   3,
+			conf:          &IOThreadsConfig{Count: 2, Assignments: map[string]uint{"vda": 2}},
+			wantIOThreads: 2,
+			wantDisks:     []uint{2, 1, 2},
+		},
+		{
+			name:        "assignment to the IOThread 0",
+			virtioDisks: 1,
+			conf:        &IOThreadsConfig{Count: 1, Assignments: map[string]uint{"vda": 0}},
+			wantErr:     true,
+		},
+		{
+			name:            "SCSI controllers in the round robin",
+			virtioDisks:     2,
+			scsiDisks:       1,
+			scsi:            &SCSIConfig{},
+			conf:            &IOThreadsConfig{Count: 2},
+			wantIOThreads:   2,
+			wantDisks:       []uint{1, 2},
+			wantControllers: []uint{1},
+		},
+		{
+			name:        "assignment out of range",
+			virtioDisks: 1,
+			conf:        &IOThreadsConfig{Count: 1, Assignments: map[string]uint{"vda": 2}},
+			wantErr:     true,
+		},
+		{
+			name:            "disks numbered after the SCSI controllers",
+			virtioDisks:     2,
+			scsiDisks:       1,
+			scsi:            &SCSIConfig{IOThreadPerController: true},
+			conf:            &IOThreadsConfig{Count: 2},
+			wantIOThreads:   3,
+			wantDisks:       []uint{2, 3},
+			wantControllers: []uint{1},
+		},
+		{
+			name:          "pinning",
+			virtioDisks:   2,
+			conf:          &IOThreadsConfig{Count: 2, CPUSet: "2-3", Pins: []IOThreadPin{{IOThread: 1, CPUSet: "4"}}},
+			wantIOThreads: 2,
+			wantDisks:     []uint{1, 2},
+			wantPins:      []string{"4", "2-3"},
+		},
+		{
+			name:        "pinning out of range",
+			virtioDisks: 1,
+			conf:        &IOThreadsConfig{Count: 1, Pins: []IOThreadPin{{IOThread: 3, CPUSet: "4"}}},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &ConverterContext{
+				Profile: &ProfileSpec{
+					SCSI:      tt.scsi,
+					IOThreads: tt.conf,
+				},
+				Warnings: []string{},
+			}
+			domain := newTestDomain("q35", tt.virtioDisks, tt.scsiDisks)
+			if tt.scsi != nil {
+				err := convert_api_SCSIDisks_To_api_Controllers(domain, c)
+				if err != nil {
+					t.Fatalf("SCSI controllers: unexpected error: %v", err)
+				}
+			}
+
+			err := convert_api_Devices_To_api_IOThreads(domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if domain.IOThreads != tt.wantIOThreads {
+				t.Errorf("got %d IOThreads, want %d", domain.IOThreads, tt.wantIOThreads)
+			}
+			if got := diskIOThreads(domain); !reflect.DeepEqual(got, tt.wantDisks) {
+				t.Errorf("got disk IOThreads %v, want %v", got, tt.wantDisks)
+			}
+			wantControllers := tt.wantControllers
+			if wantControllers == nil {
+				wantControllers = []uint{}
+			}
+			if got := controllerIOThreads(domain); !reflect.DeepEqual(got, wantControllers) {
+				t.Errorf("got controller IOThreads %v, want %v", got, wantControllers)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) > 0 {
+					t.Errorf("unexpected warnings %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+			pins := []string{}
+			if domain.CPUTune != nil {
+				for i, pin := range domain.CPUTune.IOThreadPin {
+					if pin.IOThread != uint(i+1) {
+						t.Errorf("got the pin %d for the IOThread %d, want %d", i, pin.IOThread, i+1)
+					}
+					pins = append(pins, pin.CPUSet)
+				}
+			}
+			wantPins := tt.wantPins
+			if wantPins == nil {
+				wantPins = []string{}
+			}
+			if !reflect.DeepEqual(pins, wantPins) {
+				t.Errorf("got IOThread pins %v, want %v", pins, wantPins)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"strconv"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// convert_v1_Memory_To_api_MemoryBacking translates the hugepages of the spec, and applies the memory profile
+func convert_v1_Memory_To_api_MemoryBacking(source *k6tv1.Memory, domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.Memory
+	if conf == nil {
+		conf = &MemoryConfig{}
+	}
+	backing := &libvirtxml.DomainMemoryBacking{}
+	used := false
+
+	if source != nil && source.Hugepages != nil {
+		page, err := pageSizeToHugepage(source.Hugepages.PageSize)
+		if err != nil {
+			return err
+		}
+		backing.MemoryHugePages = &libvirtxml.DomainMemoryHugepages{}
+		if page != nil {
+			backing.MemoryHugePages.Hugepages = append(backing.MemoryHugePages.Hugepages, *page)
+		}
+		for _, node := range conf.HugepageNodes {
+			nodePage, err := pageSizeToHugepage(node.PageSize)
+			if err != nil {
+				return err
+			}
+			if nodePage == nil {
+				return fmt.Errorf("missing page size for the NUMA nodes %s", node.Nodeset)
+			}
+			nodePage.Nodeset = node.Nodeset
+			backing.MemoryHugePages.Hugepages = append(backing.MemoryHugePages.Hugepages, *nodePage)
+		}
+		used = true
+	} else if len(conf.HugepageNodes) > 0 {
+		c.warn("Ignoring the per-node hugepages, the VM doesn't use hugepages")
+	}
+
+	if conf.Locked {
+		backing.MemoryLocked = &libvirtxml.DomainMemoryLocked{}
+		used = true
+	}
+	if conf.NoSharePages {
+		backing.MemoryNosharepages = &libvirtxml.DomainMemoryNosharepages{}
+		used = true
+	}
+	switch conf.Source {
+	case "":
+	case "anonymous", "file", "memfd":
+		backing.MemorySource = &libvirtxml.DomainMemorySource{
+			Type: conf.Source,
+		}
+		used = true
+	default:
+		return fmt.Errorf("unknown memory source %s", conf.Source)
+	}
+	switch conf.Allocation {
+	case "":
+	case "immediate", "ondemand":
+		backing.MemoryAllocation = &libvirtxml.DomainMemoryAllocation{
+			Mode: conf.Allocation,
+		}
+		used = true
+	default:
+		return fmt.Errorf("unknown memory allocation mode %s", conf.Allocation)
+	}
+
+	if used {
+		domain.MemoryBacking = backing
+	}
+	return nil
+}
+
+// pageSizeToHugepage converts a page size like "2Mi" in a libvirt hugepage; an empty size means the host default
+func pageSizeToHugepage(pageSize string) (*libvirtxml.DomainMemoryHugepage, error) {
+	if pageSize == "" {
+		return nil, nil
+	}
+	quantity, err := k8sres.ParseQuantity(pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("malformed hugepage size %s: %v", pageSize, err)
+	}
+	size, ok := quantity.AsInt64()
+	if !ok || size <= 0 || size%1024 != 0 {
+		return nil, fmt.Errorf("invalid hugepage size %s", pageSize)
+	}
+	return &libvirtxml.DomainMemoryHugepage{
+		Size: uint(size / 1024),
+		Unit: "KiB",
+	}, nil
+}
+
+// HugepageSizes returns the page sizes, in bytes, supported by the host, if known
+func (h *Host) HugepageSizes() []uint64 {
+	sizes := []uint64{}
+	if h == nil || h.Caps == nil || h.Caps.Host.CPU == nil {
+		return sizes
+	}
+	for _, page := range h.Caps.Host.CPU.PageSizes {
+		size, err := domainMemoryToBytes(uint(page.Size), page.Unit)
+		if err == nil {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// completeHugepages checks the hugepage sizes against the ones the host supports, and,
+// when the memory is bound to host nodes, that the nodes have enough pages.
+func completeHugepages(dom *libvirtxml.Domain, c *ConverterContext) error {
+	if dom.MemoryBacking == nil || dom.MemoryBacking.MemoryHugePages == nil {
+		return nil
+	}
+	supported := c.Host.HugepageSizes()
+	if len(supported) == 0 {
+		c.warn("Host page sizes unknown, hugepages not checked")
+		return nil
+	}
+
+	for _, page := range dom.MemoryBacking.MemoryHugePages.Hugepages {
+		size, err := domainMemoryToBytes(page.Size, page.Unit)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, hostSize := range supported {
+			if hostSize == size {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("hugepage size %d%s not supported by the host", page.Size, page.Unit)
+		}
+	}
+
+	size, ok := hugepageSize(dom)
+	if !ok || dom.NUMATune == nil || dom.CPU == nil || dom.CPU.Numa == nil {
+		return nil
+	}
+	for _, memNode := range dom.NUMATune.MemNodes {
+		nodes, err := ParseCPUSet(memNode.Nodeset)
+		if err != nil || len(nodes) != 1 {
+			continue
+		}
+		for _, cell := range dom.CPU.Numa.Cell {
+			if cell.ID == nil || *cell.ID != memNode.CellID {
+				continue
+			}
+			memory, err := strconv.ParseUint(cell.Memory, 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed memory %q of the NUMA cell %d", cell.Memory, memNode.CellID)
+			}
+			cellMemory, err := domainMemoryToBytes(uint(memory), cell.Unit)
+			if err != nil {
+				return err
+			}
+			if available, known := c.Host.hugepagesOnNode(nodes[0], size); known && available < cellMemory/size {
+				c.warn("The host node %d has %d hugepages, the NUMA cell %d needs %d", nodes[0], available, memNode.CellID, cellMemory/size)
+			}
+		}
+	}
+	return nil
+}
+
+// shareGuestMemory lets a vhost-user backend, like the virtiofs daemon, access the guest memory:
+// it must be shared and backed by a file, so hugepages, a file or memfd.
+// Without a memory profile, memfd is the one which needs no host setup.
+func shareGuestMemory(domain *libvirtxml.Domain) error {
+	if domain.MemoryBacking == nil {
+		domain.MemoryBacking = &libvirtxml.DomainMemoryBacking{}
+	}
+	backing := domain.MemoryBacking
+	if backing.MemoryHugePages == nil {
+		if backing.MemorySource == nil {
+			backing.MemorySource = &libvirtxml.DomainMemorySource{
+				Type: "memfd",
+			}
+		} else if backing.MemorySource.Type == "anonymous" {
+			return fmt.Errorf("needs the guest memory backed by hugepages, a file or memfd, not anonymous memory")
+		}
+	}
+	backing.MemoryAccess = &libvirtxml.DomainMemoryAccess{
+		Mode: "shared",
+	}
+	return nil
+}
+
+func (h *Host) hugepagesOnNode(node uint, size uint64) (uint64, bool) {
+	if h == nil || h.Caps == nil || h.Caps.Host.NUMA == nil || h.Caps.Host.NUMA.Cells == nil {
+		return 0, false
+	}
+	for _, cell := range h.Caps.Host.NUMA.Cells.Cells {
+		if uint(cell.ID) != node {
+			continue
+		}
+		for _, info := range cell.PageInfo {
+			infoSize, err := domainMemoryToBytes(uint(info.Size), info.Unit)
+			if err == nil && infoSize == size {
+				return info.Count, true
+			}
+		}
+	}
+	return 0, false
+}
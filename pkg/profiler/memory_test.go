@@ -0,0 +1,264 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// hugepagesTestCaps describes a host with 2Mi and 1Gi hugepages, and 512 2Mi pages on its only NUMA node
+const hugepagesTestCaps = `<capabilities>
+  <host>
+    <cpu>
+      <arch>x86_64</arch>
+      <pages unit="KiB" size="4"/>
+      <pages unit="KiB" size="2048"/>
+      <pages unit="KiB" size="1048576"/>
+    </cpu>
+    <topology>
+      <cells num="1">
+        <cell id="0">
+          <pages unit="KiB" size="2048">512</pages>
+          <cpus num="1"><cpu id="0"/></cpus>
+        </cell>
+      </cells>
+    </topology>
+  </host>
+</capabilities>`
+
+func TestPageSizeToHugepage(t *testing.T) {
+	tests := []struct {
+		pageSize string
+		want     *libvirtxml.DomainMemoryHugepage
+		wantErr  bool
+	}{
+		{pageSize: ""},
+		{pageSize: "2Mi", want: &libvirtxml.DomainMemoryHugepage{Size: 2048, Unit: "KiB"}},
+		{pageSize: "1Gi", want: &libvirtxml.DomainMemoryHugepage{Size: 1048576, Unit: "KiB"}},
+		{pageSize: "1500", wantErr: true},
+		{pageSize: "-2Mi", wantErr: true},
+		{pageSize: "huge", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := pageSizeToHugepage(tt.pageSize)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: expected an error", tt.pageSize)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.pageSize, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.pageSize, got, tt.want)
+		}
+	}
+}
+
+func TestConvertMemoryBacking(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      *k6tv1.Memory
+		conf        *MemoryConfig
+		want        *libvirtxml.DomainMemoryBacking
+		wantWarning bool
+		wantErr     bool
+	}{
+		{
+			name: "nothing to back",
+		},
+		{
+			name:   "hugepages of the default size",
+			source: &k6tv1.Memory{Hugepages: &k6tv1.Hugepages{}},
+			want: &libvirtxml.DomainMemoryBacking{
+				MemoryHugePages: &libvirtxml.DomainMemoryHugepages{},
+			},
+		},
+		{
+			name:   "hugepages with per node sizes",
+			source: &k6tv1.Memory{Hugepages: &k6tv1.Hugepages{PageSize: "2Mi"}},
+			conf: &MemoryConfig{
+				HugepageNodes: []HugepageNodeConfig{{Nodeset: "1", PageSize: "1Gi"}},
+			},
+			want: &libvirtxml.DomainMemoryBacking{
+				MemoryHugePages: &libvirtxml.DomainMemoryHugepages{
+					Hugepages: []libvirtxml.DomainMemoryHugepage{
+						{Size: 2048, Unit: "KiB"},
+						{Size: 1048576, Unit: "KiB", Nodeset: "1"},
+					},
+				},
+			},
+		},
+		{
+			name: "per node sizes without hugepages",
+			conf: &MemoryConfig{
+				HugepageNodes: []HugepageNodeConfig{{Nodeset: "1", PageSize: "1Gi"}},
+			},
+			wantWarning: true,
+		},
+		{
+			name: "profile settings",
+			conf: &MemoryConfig{Locked: true, NoSharePages: true, Source: "memfd", Allocation: "immediate"},
+			want: &libvirtxml.DomainMemoryBacking{
+				MemoryLocked:       &libvirtxml.DomainMemoryLocked{},
+				MemoryNosharepages: &libvirtxml.DomainMemoryNosharepages{},
+				MemorySource:       &libvirtxml.DomainMemorySource{Type: "memfd"},
+				MemoryAllocation:   &libvirtxml.DomainMemoryAllocation{Mode: "immediate"},
+			},
+		},
+		{
+			name:    "invalid page size",
+			source:  &k6tv1.Memory{Hugepages: &k6tv1.Hugepages{PageSize: "3000"}},
+			wantErr: true,
+		},
+		{
+			name:   "per node size missing",
+			source: &k6tv1.Memory{Hugepages: &k6tv1.Hugepages{PageSize: "2Mi"}},
+			conf: &MemoryConfig{
+				HugepageNodes: []HugepageNodeConfig{{Nodeset: "1"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "unknown source",
+			conf:    &MemoryConfig{Source: "shm"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown allocation",
+			conf:    &MemoryConfig{Allocation: "lazy"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{Memory: tt.conf})
+			domain := &libvirtxml.Domain{}
+			err := convert_v1_Memory_To_api_MemoryBacking(tt.source, domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(domain.MemoryBacking, tt.want) {
+				t.Errorf("got memory backing %+v, want %+v", domain.MemoryBacking, tt.want)
+			}
+			if got := containsWarning(c.Warnings, "Ignoring the per-node hugepages"); got != tt.wantWarning {
+				t.Errorf("got warnings %v, want the per-node warning: %v", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCompleteHugepages(t *testing.T) {
+	tests := []struct {
+		name        string
+		unknownHost bool
+		pages       []libvirtxml.DomainMemoryHugepage
+		// cellMiB is the memory of the only guest NUMA cell, bound to the host node 0; no NUMA if empty
+		cellMiB     string
+		wantWarning string
+		wantErr     bool
+	}{
+		{
+			name: "no hugepages",
+		},
+		{
+			name:  "supported sizes",
+			pages: []libvirtxml.DomainMemoryHugepage{{Size: 2048, Unit: "KiB"}, {Size: 1, Unit: "G", Nodeset: "1"}},
+		},
+		{
+			name:    "unsupported size",
+			pages:   []libvirtxml.DomainMemoryHugepage{{Size: 16, Unit: "M"}},
+			wantErr: true,
+		},
+		{
+			name:        "unknown host",
+			unknownHost: true,
+			pages:       []libvirtxml.DomainMemoryHugepage{{Size: 16, Unit: "M"}},
+			wantWarning: "Host page sizes unknown",
+		},
+		{
+			name:    "enough pages on the host node",
+			pages:   []libvirtxml.DomainMemoryHugepage{{Size: 2048, Unit: "KiB"}},
+			cellMiB: "1024",
+		},
+		{
+			name:        "not enough pages on the host node",
+			pages:       []libvirtxml.DomainMemoryHugepage{{Size: 2048, Unit: "KiB"}},
+			cellMiB:     "2048",
+			wantWarning: "has 512 hugepages, the NUMA cell 0 needs 1024",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			if !tt.unknownHost {
+				host, err := NewHostFromCapabilities(hugepagesTestCaps)
+				if err != nil {
+					t.Fatalf("malformed test capabilities: %v", err)
+				}
+				c.Host = host
+			}
+			dom := &libvirtxml.Domain{CPU: &libvirtxml.DomainCPU{}}
+			if tt.pages != nil {
+				dom.MemoryBacking = &libvirtxml.DomainMemoryBacking{
+					MemoryHugePages: &libvirtxml.DomainMemoryHugepages{Hugepages: tt.pages},
+				}
+			}
+			if tt.cellMiB != "" {
+				id := uint(0)
+				dom.CPU.Numa = &libvirtxml.DomainNuma{
+					Cell: []libvirtxml.DomainCell{{ID: &id, CPUs: "0", Memory: tt.cellMiB, Unit: "MiB"}},
+				}
+				dom.NUMATune = &libvirtxml.DomainNUMATune{
+					MemNodes: []libvirtxml.DomainNUMATuneMemNode{{CellID: 0, Mode: NUMAModeStrict, Nodeset: "0"}},
+				}
+			}
+
+			err := completeHugepages(dom, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
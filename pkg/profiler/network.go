@@ -0,0 +1,220 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// Network binding types, used in the profile attachments
+const (
+	NetworkBindingBridge    = "bridge"
+	NetworkBindingMacvtap   = "macvtap"
+	NetworkBindingVhostUser = "vhostuser"
+	NetworkBindingSRIOV     = "sriov"
+)
+
+const defaultMacvtapMode = "bridge"
+
+// networkBinding fills the host side of a domain interface
+type networkBinding func(iface *k6tv1.Interface, attachment *NetworkAttachment, domIface *libvirtxml.DomainInterface, domain *libvirtxml.Domain, c *ConverterContext) error
+
+var networkBindings = map[string]networkBinding{
+	NetworkBindingBridge:    bindBridge,
+	NetworkBindingMacvtap:   bindMacvtap,
+	NetworkBindingVhostUser: bindVhostUser,
+	NetworkBindingSRIOV:     bindSRIOV,
+}
+
+// networkAttachmentFor returns how the network of the interface is attached to the host.
+// Pod networks use the bridge from the profile, Multus networks are looked up in the profile attachments.
+func networkAttachmentFor(iface *k6tv1.Interface, network *k6tv1.Network, c *ConverterContext) (*NetworkAttachment, error) {
+	conf := c.Profile.Network
+	if conf == nil {
+		conf = &NetworkConfig{}
+	}
+
+	if network.Pod != nil {
+		if iface.Bridge == nil {
+			return nil, fmt.Errorf("unsupported binding for interface %s on the pod network", iface.Name)
+		}
+		bridge := conf.PodBridge
+		if bridge == "" {
+			bridge = DefaultBridgeName
+		}
+		return &NetworkAttachment{
+			Type:   NetworkBindingBridge,
+			Bridge: bridge,
+		}, nil
+	}
+
+	if network.Multus != nil {
+		if iface.Bridge == nil {
+			return nil, fmt.Errorf("interface %s: only the bridge binding is supported on multus networks", iface.Name)
+		}
+		if attachment, ok := conf.Attachments[network.Multus.NetworkName]; ok {
+			return &attachment, nil
+		}
+		c.warn("No attachment found for the multus network %s, connecting %s to the bridge with the same name", network.Multus.NetworkName, iface.Name)
+		return &NetworkAttachment{
+			Type:   NetworkBindingBridge,
+			Bridge: network.Multus.NetworkName,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("network interface type not supported for %s", iface.Name)
+}
+
+// convert_v1_Interface_To_api_Interface builds a domain interface connected to the host network described by the attachment.
+// Model, queues and MTU are taken from the attachment first, then from the profile network defaults.
+func convert_v1_Interface_To_api_Interface(iface *k6tv1.Interface, attachment *NetworkAttachment, domain *libvirtxml.Domain, c *ConverterContext) error {
+	bind, ok := networkBindings[attachment.Type]
+	if !ok {
+		return fmt.Errorf("interface %s: unknown network binding %q", iface.Name, attachment.Type)
+	}
+
+	conf := c.Profile.Network
+	if conf == nil {
+		conf = &NetworkConfig{}
+	}
+
+	model := iface.Model
+	if model == "" {
+		model = attachment.Model
+	}
+	if model == "" {
+		model = conf.Model
+	}
+	if model == "" {
+		model = "virtio"
+	}
+
+	domIface := libvirtxml.DomainInterface{
+		Model: &libvirtxml.DomainInterfaceModel{
+			Type: model,
+		},
+		Alias: &libvirtxml.DomainAlias{
+			Name: iface.Name,
+		},
+	}
+	if iface.MacAddress != "" {
+		domIface.MAC = &libvirtxml.DomainInterfaceMAC{
+			Address: iface.MacAddress,
+		}
+	}
+
+	queues := attachment.Queues
+	if queues == 0 {
+		queues = conf.Queues
+	}
+	if queues > 0 {
+		// vhost-user interfaces are always virtio, see bindVhostUser
+		if model != "virtio" && attachment.Type != NetworkBindingVhostUser {
+			c.warn("Ignoring the queues of %s, supported only by virtio interfaces", iface.Name)
+		} else {
+			domIface.Driver = &libvirtxml.DomainInterfaceDriver{
+				Queues: queues,
+			}
+			// the vhost-user backend takes the place of the in-kernel vhost-net one
+			if attachment.Type != NetworkBindingVhostUser {
+				domIface.Driver.Name = "vhost"
+			}
+		}
+	}
+
+	mtu := attachment.MTU
+	if mtu == 0 {
+		mtu = conf.MTU
+	}
+	if mtu > 0 {
+		domIface.MTU = &libvirtxml.DomainInterfaceMTU{
+			Size: mtu,
+		}
+	}
+
+	err := bind(iface, attachment, &domIface, domain, c)
+	if err != nil {
+		return err
+	}
+	domain.Devices.Interfaces = append(domain.Devices.Interfaces, domIface)
+	return nil
+}
+
+func bindBridge(iface *k6tv1.Interface, attachment *NetworkAttachment, domIface *libvirtxml.DomainInterface, _ *libvirtxml.Domain, _ *ConverterContext) error {
+	if attachment.Bridge == "" {
+		return fmt.Errorf("interface %s: missing bridge name", iface.Name)
+	}
+	domIface.Source = &libvirtxml.DomainInterfaceSource{
+		Bridge: &libvirtxml.DomainInterfaceSourceBridge{
+			Bridge: attachment.Bridge,
+		},
+	}
+	return nil
+}
+
+func bindMacvtap(iface *k6tv1.Interface, attachment *NetworkAttachment, domIface *libvirtxml.DomainInterface, _ *libvirtxml.Domain, _ *ConverterContext) error {
+	if attachment.Device == "" {
+		return fmt.Errorf("interface %s: missing macvtap host device", iface.Name)
+	}
+	mode := attachment.Mode
+	if mode == "" {
+		mode = defaultMacvtapMode
+	}
+	domIface.Source = &libvirtxml.DomainInterfaceSource{
+		Direct: &libvirtxml.DomainInterfaceSourceDirect{
+			Dev:  attachment.Device,
+			Mode: mode,
+		},
+	}
+	return nil
+}
+
+func bindVhostUser(iface *k6tv1.Interface, attachment *NetworkAttachment, domIface *libvirtxml.DomainInterface, domain *libvirtxml.Domain, c *ConverterContext) error {
+	socketPath := attachment.Socket
+	if socketPath == "" {
+		var err error
+		socketPath, err = c.Paths.VhostUserSocket(iface.Name)
+		if err != nil {
+			return err
+		}
+	}
+	mode := attachment.Mode
+	if mode == "" {
+		mode = "client"
+	}
+	if domIface.Model.Type != "virtio" {
+		c.warn("The network interface type of %s was changed to virtio, the only one supported by vhost-user", iface.Name)
+		domIface.Model.Type = "virtio"
+	}
+	domIface.Source = &libvirtxml.DomainInterfaceSource{
+		VHostUser: &libvirtxml.DomainChardevSource{
+			UNIX: &libvirtxml.DomainChardevSourceUNIX{
+				Mode: mode,
+				Path: socketPath,
+			},
+		},
+	}
+
+	err := shareGuestMemory(domain)
+	if err != nil {
+		return fmt.Errorf("interface %s: vhost-user %v", iface.Name, err)
+	}
+	return nil
+}
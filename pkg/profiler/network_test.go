@@ -0,0 +1,250 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestNetworkAttachmentFor(t *testing.T) {
+	conf := &NetworkConfig{
+		PodBridge: "br0",
+		Attachments: map[string]NetworkAttachment{
+			"storage": {Type: NetworkBindingMacvtap, Device: "eth1"},
+		},
+	}
+	tests := []struct {
+		name        string
+		conf        *NetworkConfig
+		iface       *k6tv1.Interface
+		network     k6tv1.NetworkSource
+		wantType    string
+		wantBridge  string
+		wantDevice  string
+		wantWarning bool
+		wantErr     bool
+	}{
+		{
+			name:       "pod network on the default bridge",
+			iface:      newBridgeInterface("default"),
+			network:    k6tv1.NetworkSource{Pod: &k6tv1.PodNetwork{}},
+			wantType:   NetworkBindingBridge,
+			wantBridge: DefaultBridgeName,
+		},
+		{
+			name:       "pod network on the profile bridge",
+			conf:       conf,
+			iface:      newBridgeInterface("default"),
+			network:    k6tv1.NetworkSource{Pod: &k6tv1.PodNetwork{}},
+			wantType:   NetworkBindingBridge,
+			wantBridge: "br0",
+		},
+		{
+			name:       "multus network with an attachment",
+			conf:       conf,
+			iface:      newBridgeInterface("storage"),
+			network:    k6tv1.NetworkSource{Multus: &k6tv1.MultusNetwork{NetworkName: "storage"}},
+			wantType:   NetworkBindingMacvtap,
+			wantDevice: "eth1",
+		},
+		{
+			name:        "multus network without an attachment",
+			conf:        conf,
+			iface:       newBridgeInterface("backend"),
+			network:     k6tv1.NetworkSource{Multus: &k6tv1.MultusNetwork{NetworkName: "backend"}},
+			wantType:    NetworkBindingBridge,
+			wantBridge:  "backend",
+			wantWarning: true,
+		},
+		{
+			name:    "slirp on a multus network",
+			iface:   &k6tv1.Interface{Name: "backend", InterfaceBindingMethod: k6tv1.InterfaceBindingMethod{Slirp: &k6tv1.InterfaceSlirp{}}},
+			network: k6tv1.NetworkSource{Multus: &k6tv1.MultusNetwork{NetworkName: "backend"}},
+			wantErr: true,
+		},
+		{
+			name:    "no network source",
+			iface:   newBridgeInterface("default"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{Network: tt.conf})
+			network := &k6tv1.Network{Name: tt.iface.Name, NetworkSource: tt.network}
+			attachment, err := networkAttachmentFor(tt.iface, network, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if attachment.Type != tt.wantType || attachment.Bridge != tt.wantBridge || attachment.Device != tt.wantDevice {
+				t.Errorf("got attachment %+v", attachment)
+			}
+			if got := containsWarning(c.Warnings, "No attachment found"); got != tt.wantWarning {
+				t.Errorf("got warnings %v, want the attachment warning: %v", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestConvertInterface(t *testing.T) {
+	tests := []struct {
+		name        string
+		model       string
+		conf        *NetworkConfig
+		attachment  NetworkAttachment
+		check       func(t *testing.T, iface *libvirtxml.DomainInterface, domain *libvirtxml.Domain)
+		wantWarning string
+		wantErr     bool
+	}{
+		{
+			name:       "bridge with the default model",
+			attachment: NetworkAttachment{Type: NetworkBindingBridge, Bridge: "br0"},
+			check: func(t *testing.T, iface *libvirtxml.DomainInterface, _ *libvirtxml.Domain) {
+				if iface.Model.Type != "virtio" || iface.Source.Bridge.Bridge != "br0" {
+					t.Errorf("got %s interface on %+v", iface.Model.Type, iface.Source)
+				}
+				if iface.Alias.Name != "default" {
+					t.Errorf("got alias %s, want default", iface.Alias.Name)
+				}
+			},
+		},
+		{
+			name:       "attachment settings override the profile",
+			conf:       &NetworkConfig{Model: "e1000", MTU: 1500, Queues: 2},
+			attachment: NetworkAttachment{Type: NetworkBindingBridge, Bridge: "br0", Model: "virtio", MTU: 9000},
+			check: func(t *testing.T, iface *libvirtxml.DomainInterface, _ *libvirtxml.Domain) {
+				if iface.Model.Type != "virtio" || iface.MTU.Size != 9000 {
+					t.Errorf("got %s interface with MTU %d", iface.Model.Type, iface.MTU.Size)
+				}
+				if iface.Driver == nil || iface.Driver.Queues != 2 {
+					t.Errorf("got driver %+v, want the 2 queues of the profile", iface.Driver)
+				}
+			},
+		},
+		{
+			name:        "queues ignored on non virtio models",
+			model:       "e1000",
+			conf:        &NetworkConfig{Queues: 2},
+			attachment:  NetworkAttachment{Type: NetworkBindingBridge, Bridge: "br0"},
+			wantWarning: "Ignoring the queues",
+			check: func(t *testing.T, iface *libvirtxml.DomainInterface, _ *libvirtxml.Domain) {
+				if iface.Driver != nil {
+					t.Errorf("unexpected driver %+v", iface.Driver)
+				}
+			},
+		},
+		{
+			name:       "macvtap in the default mode",
+			attachment: NetworkAttachment{Type: NetworkBindingMacvtap, Device: "eth1"},
+			check: func(t *testing.T, iface *libvirtxml.DomainInterface, _ *libvirtxml.Domain) {
+				if direct := iface.Source.Direct; direct.Dev != "eth1" || direct.Mode != "bridge" {
+					t.Errorf("got direct source %+v", direct)
+				}
+			},
+		},
+		{
+			name:        "vhost-user",
+			model:       "e1000",
+			attachment:  NetworkAttachment{Type: NetworkBindingVhostUser},
+			wantWarning: "changed to virtio",
+			check: func(t *testing.T, iface *libvirtxml.DomainInterface, domain *libvirtxml.Domain) {
+				unix := iface.Source.VHostUser.UNIX
+				if want := testBaseDiskPath + "/default/testvmi/sockets/vhost-user-default"; unix.Path != want || unix.Mode != "client" {
+					t.Errorf("got socket %s in mode %s, want %s in client mode", unix.Path, unix.Mode, want)
+				}
+				if iface.Model.Type != "virtio" {
+					t.Errorf("got model %s, want virtio", iface.Model.Type)
+				}
+				backing := domain.MemoryBacking
+				if backing == nil || backing.MemoryAccess == nil || backing.MemoryAccess.Mode != "shared" {
+					t.Fatalf("the guest memory is not shared")
+				}
+				if backing.MemorySource == nil || backing.MemorySource.Type != "memfd" {
+					t.Errorf("got memory source %+v, want memfd", backing.MemorySource)
+				}
+			},
+		},
+		{
+			name:       "vhost-user queues",
+			conf:       &NetworkConfig{Queues: 4},
+			attachment: NetworkAttachment{Type: NetworkBindingVhostUser},
+			check: func(t *testing.T, iface *libvirtxml.DomainInterface, _ *libvirtxml.Domain) {
+				if want := (&libvirtxml.DomainInterfaceDriver{Queues: 4}); !reflect.DeepEqual(iface.Driver, want) {
+					t.Errorf("got driver %+v, want %+v", iface.Driver, want)
+				}
+			},
+		},
+		{
+			name:       "missing bridge",
+			attachment: NetworkAttachment{Type: NetworkBindingBridge},
+			wantErr:    true,
+		},
+		{
+			name:       "missing macvtap device",
+			attachment: NetworkAttachment{Type: NetworkBindingMacvtap},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown binding",
+			attachment: NetworkAttachment{Type: "ovs"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{Network: tt.conf})
+			iface := newBridgeInterface("default")
+			iface.Model = tt.model
+			domain := &libvirtxml.Domain{
+				Devices: &libvirtxml.DomainDeviceList{},
+			}
+			err := convert_v1_Interface_To_api_Interface(iface, &tt.attachment, domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(domain.Devices.Interfaces) != 1 {
+				t.Fatalf("got %d interfaces, want 1", len(domain.Devices.Interfaces))
+			}
+			tt.check(t, &domain.Devices.Interfaces[0], domain)
+			if tt.wantWarning == "" {
+				if len(c.Warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
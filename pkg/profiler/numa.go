@@ -0,0 +1,218 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// NUMA memory binding modes
+const (
+	NUMAModeStrict     = "strict"
+	NUMAModePreferred  = "preferred"
+	NUMAModeInterleave = "interleave"
+)
+
+// HostNUMANodes returns the ids of the host NUMA nodes, as found in the capabilities
+func (h *Host) HostNUMANodes() []uint {
+	nodes := []uint{}
+	if h == nil || h.Caps == nil || h.Caps.Host.NUMA == nil || h.Caps.Host.NUMA.Cells == nil {
+		return nodes
+	}
+	for _, cell := range h.Caps.Host.NUMA.Cells.Cells {
+		nodes = append(nodes, uint(cell.ID))
+	}
+	return nodes
+}
+
+// NUMANodeOfCPU returns the host NUMA node of the given host CPU
+func (h *Host) NUMANodeOfCPU(cpu uint) (uint, bool) {
+	if h == nil || h.Caps == nil || h.Caps.Host.NUMA == nil || h.Caps.Host.NUMA.Cells == nil {
+		return 0, false
+	}
+	for _, cell := range h.Caps.Host.NUMA.Cells.Cells {
+		if cell.CPUS == nil {
+			continue
+		}
+		for _, hostCPU := range cell.CPUS.CPUs {
+			if uint(hostCPU.ID) == cpu {
+				return uint(cell.ID), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// completeNUMA splits the guest vCPUs and memory in NUMA cells, and binds each cell to a host node.
+// When the vCPUs are pinned, each cell is bound to the node of the host CPUs its vCPUs run on.
+func completeNUMA(dom *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.NUMA
+	if conf == nil {
+		return nil
+	}
+	if dom.VCPU == nil || dom.VCPU.Value == 0 || dom.Memory == nil {
+		return fmt.Errorf("NUMA topology needs explicit vCPU count and memory")
+	}
+	vcpus := uint(dom.VCPU.Value)
+
+	mode := conf.Mode
+	if mode == "" {
+		mode = NUMAModeStrict
+	}
+	if mode != NUMAModeStrict && mode != NUMAModePreferred && mode != NUMAModeInterleave {
+		return fmt.Errorf("unknown NUMA mode %s", mode)
+	}
+
+	hostNodes := conf.HostNodes
+	if len(hostNodes) == 0 {
+		hostNodes = c.Host.HostNUMANodes()
+	}
+	cells := conf.Cells
+	if cells == 0 {
+		cells = uint(len(hostNodes))
+	}
+	if cells == 0 {
+		cells = 1
+	}
+	if cells > vcpus {
+		return fmt.Errorf("%d NUMA cells need at least as many vCPUs, found %d", cells, vcpus)
+	}
+	if len(hostNodes) == 0 {
+		c.warn("Host NUMA topology unknown, the guest NUMA cells are not bound to host nodes")
+	}
+
+	memory, err := domainMemoryToBytes(dom.Memory.Value, dom.Memory.Unit)
+	if err != nil {
+		return err
+	}
+	// the cells must hold whole pages
+	pageSize := uint64(1024)
+	if size, ok := hugepageSize(dom); ok {
+		pageSize = size
+	}
+	pages := memory / pageSize
+	if pages < uint64(cells) {
+		return fmt.Errorf("not enough memory to split in %d NUMA cells", cells)
+	}
+	if memory%pageSize != 0 {
+		return fmt.Errorf("the memory (%d bytes) is not a multiple of the page size (%d bytes), so it can't be split in NUMA cells", memory, pageSize)
+	}
+
+	pinned := map[uint]uint{}
+	if dom.CPUTune != nil {
+		for _, pin := range dom.CPUTune.VCPUPin {
+			cpus, err := ParseCPUSet(pin.CPUSet)
+			if err == nil && len(cpus) > 0 {
+				pinned[pin.VCPU] = cpus[0]
+			}
+		}
+	}
+
+	numa := &libvirtxml.DomainNuma{}
+	numaTune := &libvirtxml.DomainNUMATune{}
+	memAccess := ""
+	if dom.MemoryBacking != nil && dom.MemoryBacking.MemoryAccess != nil {
+		memAccess = dom.MemoryBacking.MemoryAccess.Mode
+	}
+	nodesInUse := map[uint]bool{}
+	firstVCPU := uint(0)
+	for cell := uint(0); cell < cells; cell++ {
+		cellVCPUs := vcpus / cells
+		if cell < vcpus%cells {
+			cellVCPUs++
+		}
+		cellPages := pages / uint64(cells)
+		if uint64(cell) < pages%uint64(cells) {
+			cellPages++
+		}
+
+		id := cell
+		cpus := []uint{}
+		for vcpu := firstVCPU; vcpu < firstVCPU+cellVCPUs; vcpu++ {
+			cpus = append(cpus, vcpu)
+		}
+		numa.Cell = append(numa.Cell, libvirtxml.DomainCell{
+			ID:        &id,
+			CPUs:      FormatCPUSet(cpus),
+			Memory:    strconv.FormatUint(cellPages*pageSize/1024, 10),
+			Unit:      "KiB",
+			MemAccess: memAccess,
+		})
+
+		if len(hostNodes) > 0 {
+			node := hostNodes[int(cell)%len(hostNodes)]
+			if hostCPU, ok := pinned[firstVCPU]; ok {
+				if pinnedNode, ok := c.Host.NUMANodeOfCPU(hostCPU); ok {
+					node = pinnedNode
+				}
+				for vcpu := firstVCPU; vcpu < firstVCPU+cellVCPUs; vcpu++ {
+					otherCPU, ok := pinned[vcpu]
+					if !ok {
+						continue
+					}
+					otherNode, ok := c.Host.NUMANodeOfCPU(otherCPU)
+					if ok && otherNode != node {
+						c.warn("The vCPUs of the NUMA cell %d are pinned on different host nodes", cell)
+						break
+					}
+				}
+			}
+			nodesInUse[node] = true
+			numaTune.MemNodes = append(numaTune.MemNodes, libvirtxml.DomainNUMATuneMemNode{
+				CellID:  cell,
+				Mode:    mode,
+				Nodeset: strconv.Itoa(int(node)),
+			})
+		}
+		firstVCPU += cellVCPUs
+	}
+
+	if dom.CPU == nil {
+		dom.CPU = &libvirtxml.DomainCPU{}
+	}
+	dom.CPU.Numa = numa
+	if len(nodesInUse) > 0 {
+		nodes := []uint{}
+		for node := range nodesInUse {
+			nodes = append(nodes, node)
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+		numaTune.Memory = &libvirtxml.DomainNUMATuneMemory{
+			Mode:    mode,
+			Nodeset: FormatCPUSet(nodes),
+		}
+		dom.NUMATune = numaTune
+	}
+	return nil
+}
+
+// hugepageSize returns the size in bytes of the hugepages backing the domain memory, if any
+func hugepageSize(dom *libvirtxml.Domain) (uint64, bool) {
+	if dom.MemoryBacking == nil || dom.MemoryBacking.MemoryHugePages == nil || len(dom.MemoryBacking.MemoryHugePages.Hugepages) == 0 {
+		return 0, false
+	}
+	page := dom.MemoryBacking.MemoryHugePages.Hugepages[0]
+	size, err := domainMemoryToBytes(page.Size, page.Unit)
+	if err != nil || size == 0 {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,322 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// numaTestCaps describes a host with two NUMA nodes of two CPUs each
+const numaTestCaps = `<capabilities>
+  <host>
+    <cpu><arch>x86_64</arch></cpu>
+    <topology>
+      <cells num="2">
+        <cell id="0"><cpus num="2"><cpu id="0"/><cpu id="1"/></cpus></cell>
+        <cell id="1"><cpus num="2"><cpu id="2"/><cpu id="3"/></cpus></cell>
+      </cells>
+    </topology>
+  </host>
+  <guest>
+    <os_type>hvm</os_type>
+    <arch name="x86_64"><domain type="kvm"/></arch>
+  </guest>
+</capabilities>`
+
+func newNUMATestHost(t *testing.T) *Host {
+	host, err := NewHostFromCapabilities(numaTestCaps)
+	if err != nil {
+		t.Fatalf("malformed test capabilities: %v", err)
+	}
+	return host
+}
+
+func TestHostNUMANodes(t *testing.T) {
+	host := newNUMATestHost(t)
+	if got := host.HostNUMANodes(); !reflect.DeepEqual(got, []uint{0, 1}) {
+		t.Errorf("got nodes %v, want [0 1]", got)
+	}
+	tests := []struct {
+		cpu      uint
+		wantNode uint
+		wantOK   bool
+	}{
+		{cpu: 1, wantNode: 0, wantOK: true},
+		{cpu: 2, wantNode: 1, wantOK: true},
+		{cpu: 8},
+	}
+	for _, tt := range tests {
+		node, ok := host.NUMANodeOfCPU(tt.cpu)
+		if node != tt.wantNode || ok != tt.wantOK {
+			t.Errorf("CPU %d: got node %d (%v), want %d (%v)", tt.cpu, node, ok, tt.wantNode, tt.wantOK)
+		}
+	}
+	if nodes := DefaultHost().HostNUMANodes(); len(nodes) != 0 {
+		t.Errorf("got nodes %v for a host without capabilities", nodes)
+	}
+}
+
+func TestCompleteNUMA(t *testing.T) {
+	tests := []struct {
+		name string
+		// unknownHost uses a host without NUMA topology
+		unknownHost bool
+		vcpus       int
+		memoryGiB   uint
+		// extraKiB is added to memoryGiB
+		extraKiB  uint
+		noCPU     bool
+		hugepages bool
+		shared    bool
+		pins      []string
+		conf      *NUMAConfig
+		// wantCells are the vCPUs and the KiB of memory of each cell
+		wantCells    []string
+		wantMemNodes []string
+		wantNodeset  string
+		wantWarning  string
+		wantErr      bool
+	}{
+		{
+			name:      "no NUMA profile",
+			vcpus:     4,
+			memoryGiB: 4,
+		},
+		{
+			name:         "one cell per host node",
+			vcpus:        4,
+			memoryGiB:    4,
+			conf:         &NUMAConfig{},
+			wantCells:    []string{"0-1/2097152", "2-3/2097152"},
+			wantMemNodes: []string{"0:strict:0", "1:strict:1"},
+			wantNodeset:  "0-1",
+		},
+		{
+			name:         "more cells than host nodes",
+			vcpus:        4,
+			memoryGiB:    3,
+			conf:         &NUMAConfig{Cells: 3, Mode: NUMAModePreferred},
+			wantCells:    []string{"0-1/1048576", "2/1048576", "3/1048576"},
+			wantMemNodes: []string{"0:preferred:0", "1:preferred:1", "2:preferred:0"},
+			wantNodeset:  "0-1",
+		},
+		{
+			name:         "explicit host nodes",
+			vcpus:        2,
+			memoryGiB:    2,
+			conf:         &NUMAConfig{Cells: 2, HostNodes: []uint{1}, Mode: NUMAModeInterleave},
+			wantCells:    []string{"0/1048576", "1/1048576"},
+			wantMemNodes: []string{"0:interleave:1", "1:interleave:1"},
+			wantNodeset:  "1",
+		},
+		{
+			name:         "cells follow the pinned vCPUs",
+			vcpus:        2,
+			memoryGiB:    2,
+			pins:         []string{"2", "0"},
+			conf:         &NUMAConfig{},
+			wantCells:    []string{"0/1048576", "1/1048576"},
+			wantMemNodes: []string{"0:strict:1", "1:strict:0"},
+			wantNodeset:  "0-1",
+		},
+		{
+			name:         "cell pinned on different nodes",
+			vcpus:        2,
+			memoryGiB:    2,
+			pins:         []string{"0", "2"},
+			conf:         &NUMAConfig{Cells: 1},
+			wantCells:    []string{"0-1/2097152"},
+			wantMemNodes: []string{"0:strict:0"},
+			wantNodeset:  "0",
+			wantWarning:  "pinned on different host nodes",
+		},
+		{
+			name:         "cells hold whole hugepages",
+			vcpus:        2,
+			memoryGiB:    3,
+			hugepages:    true,
+			conf:         &NUMAConfig{},
+			wantCells:    []string{"0/2097152", "1/1048576"},
+			wantMemNodes: []string{"0:strict:0", "1:strict:1"},
+			wantNodeset:  "0-1",
+		},
+		{
+			name:         "domain without CPU element",
+			vcpus:        2,
+			memoryGiB:    2,
+			noCPU:        true,
+			conf:         &NUMAConfig{},
+			wantCells:    []string{"0/1048576", "1/1048576"},
+			wantMemNodes: []string{"0:strict:0", "1:strict:1"},
+			wantNodeset:  "0-1",
+		},
+		{
+			name:      "memory not made of whole hugepages",
+			vcpus:     2,
+			memoryGiB: 2,
+			extraKiB:  512,
+			hugepages: true,
+			conf:      &NUMAConfig{},
+			wantErr:   true,
+		},
+		{
+			name:         "shared memory",
+			vcpus:        2,
+			memoryGiB:    2,
+			shared:       true,
+			conf:         &NUMAConfig{},
+			wantCells:    []string{"0/1048576/shared", "1/1048576/shared"},
+			wantMemNodes: []string{"0:strict:0", "1:strict:1"},
+			wantNodeset:  "0-1",
+		},
+		{
+			name:         "unknown host topology",
+			unknownHost:  true,
+			vcpus:        2,
+			memoryGiB:    2,
+			conf:         &NUMAConfig{},
+			wantCells:    []string{"0-1/2097152"},
+			wantMemNodes: []string{},
+			wantWarning:  "Host NUMA topology unknown",
+		},
+		{
+			name:      "unknown mode",
+			vcpus:     2,
+			memoryGiB: 2,
+			conf:      &NUMAConfig{Mode: "bind"},
+			wantErr:   true,
+		},
+		{
+			name:      "more cells than vCPUs",
+			vcpus:     2,
+			memoryGiB: 2,
+			conf:      &NUMAConfig{Cells: 4},
+			wantErr:   true,
+		},
+		{
+			name:      "no vCPU count",
+			memoryGiB: 2,
+			conf:      &NUMAConfig{},
+			wantErr:   true,
+		},
+		{
+			name:      "not enough hugepages",
+			vcpus:     2,
+			memoryGiB: 1,
+			hugepages: true,
+			conf:      &NUMAConfig{},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{NUMA: tt.conf})
+			if !tt.unknownHost {
+				c.Host = newNUMATestHost(t)
+			}
+			dom := &libvirtxml.Domain{
+				Memory: &libvirtxml.DomainMemory{Value: tt.memoryGiB*1024*1024 + tt.extraKiB, Unit: "KiB"},
+			}
+			if !tt.noCPU {
+				dom.CPU = &libvirtxml.DomainCPU{}
+			}
+			if tt.vcpus > 0 {
+				dom.VCPU = &libvirtxml.DomainVCPU{Value: tt.vcpus}
+			}
+			if tt.hugepages || tt.shared {
+				dom.MemoryBacking = &libvirtxml.DomainMemoryBacking{}
+			}
+			if tt.hugepages {
+				dom.MemoryBacking.MemoryHugePages = &libvirtxml.DomainMemoryHugepages{
+					Hugepages: []libvirtxml.DomainMemoryHugepage{{Size: 1, Unit: "GiB"}},
+				}
+			}
+			if tt.shared {
+				dom.MemoryBacking.MemoryAccess = &libvirtxml.DomainMemoryAccess{Mode: "shared"}
+			}
+			if len(tt.pins) > 0 {
+				dom.CPUTune = &libvirtxml.DomainCPUTune{}
+				for vcpu, cpuset := range tt.pins {
+					dom.CPUTune.VCPUPin = append(dom.CPUTune.VCPUPin, libvirtxml.DomainCPUTuneVCPUPin{
+						VCPU:   uint(vcpu),
+						CPUSet: cpuset,
+					})
+				}
+			}
+
+			err := completeNUMA(dom, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.conf == nil {
+				if (dom.CPU != nil && dom.CPU.Numa != nil) || dom.NUMATune != nil {
+					t.Errorf("unexpected NUMA topology")
+				}
+				return
+			}
+
+			cells := []string{}
+			for i, cell := range dom.CPU.Numa.Cell {
+				if *cell.ID != uint(i) || cell.Unit != "KiB" {
+					t.Errorf("got cell %d with unit %s at position %d", *cell.ID, cell.Unit, i)
+				}
+				desc := fmt.Sprintf("%s/%s", cell.CPUs, cell.Memory)
+				if cell.MemAccess != "" {
+					desc += "/" + cell.MemAccess
+				}
+				cells = append(cells, desc)
+			}
+			if !reflect.DeepEqual(cells, tt.wantCells) {
+				t.Errorf("got cells %v, want %v", cells, tt.wantCells)
+			}
+
+			memNodes := []string{}
+			nodeset := ""
+			if dom.NUMATune != nil {
+				for _, memNode := range dom.NUMATune.MemNodes {
+					memNodes = append(memNodes, fmt.Sprintf("%d:%s:%s", memNode.CellID, memNode.Mode, memNode.Nodeset))
+				}
+				nodeset = dom.NUMATune.Memory.Nodeset
+			}
+			if !reflect.DeepEqual(memNodes, tt.wantMemNodes) {
+				t.Errorf("got memory nodes %v, want %v", memNodes, tt.wantMemNodes)
+			}
+			if nodeset != tt.wantNodeset {
+				t.Errorf("got nodeset %s, want %s", nodeset, tt.wantNodeset)
+			}
+
+			if tt.wantWarning == "" {
+				if len(c.Warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
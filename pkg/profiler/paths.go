@@ -0,0 +1,265 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"text/template"
+)
+
+// DefaultBaseDiskPath is the base path used when the Profiler has none
+const DefaultBaseDiskPath = "/var/run/kubevirt-private"
+
+// PathLayout describes where the files backing a VM are found on the host.
+// Every path is a text/template, expanded with the following fields:
+// .Base (the Profiler BaseDiskPath), .Namespace and .Name (of the VirtualMachineInstance),
+// .Volume (the volume or interface name), .Format (the disk image format) and .Port (the serial port number).
+type PathLayout struct {
+	// DiskImage is the image backing filesystem volumes, like PVCs
+	DiskImage string `json:"diskImage,omitempty"`
+	// EmptyDisk is the image backing an empty disk
+	EmptyDisk string `json:"emptyDisk,omitempty"`
+	// EphemeralDisk is the copy-on-write overlay of an ephemeral volume
+	EphemeralDisk string `json:"ephemeralDisk,omitempty"`
+	// ContainerDisk is the image extracted from a container disk
+	ContainerDisk string `json:"containerDisk,omitempty"`
+	// ContainerDiskFormat is the format of the container disk images, one of ContainerDiskFormats
+	ContainerDiskFormat string `json:"containerDiskFormat,omitempty"`
+	// CloudInitISO is the ISO image holding the cloud-init data
+	CloudInitISO string `json:"cloudInitISO,omitempty"`
+	// BlockDevice is the block device of a block mode PersistentVolumeClaim
+	BlockDevice string `json:"blockDevice,omitempty"`
+	// ConfigMapISO, SecretISO and ServiceAccountISO are the ISO images holding the data of the volumes
+	ConfigMapISO      string `json:"configMapISO,omitempty"`
+	SecretISO         string `json:"secretISO,omitempty"`
+	ServiceAccountISO string `json:"serviceAccountISO,omitempty"`
+	// ConfigMapDir, SecretDir and ServiceAccountDir are the directories holding the data of the volumes,
+	// shared with the guest by virtiofs
+	ConfigMapDir      string `json:"configMapDir,omitempty"`
+	SecretDir         string `json:"secretDir,omitempty"`
+	ServiceAccountDir string `json:"serviceAccountDir,omitempty"`
+	// SerialSocket is the unix socket backing a serial port
+	SerialSocket string `json:"serialSocket,omitempty"`
+	// VNCSocket is the unix socket of the VNC server
+	VNCSocket string `json:"vncSocket,omitempty"`
+	// SpiceSocket is the unix socket of the SPICE server
+	SpiceSocket string `json:"spiceSocket,omitempty"`
+	// GuestAgentSocket is the unix socket of the QEMU guest agent channel
+	GuestAgentSocket string `json:"guestAgentSocket,omitempty"`
+	// ChannelSocket is the unix socket of a custom virtio-serial channel; the channel name is in .Volume
+	ChannelSocket string `json:"channelSocket,omitempty"`
+	// VhostUserSocket is the unix socket of a vhost-user interface
+	VhostUserSocket string `json:"vhostUserSocket,omitempty"`
+	// NVRAM is the UEFI variable store of the VM
+	NVRAM string `json:"nvram,omitempty"`
+}
+
+// ContainerDiskFormats are the supported container disk image formats
+var ContainerDiskFormats = []string{"raw", "qcow2"}
+
+// KubeVirtPathLayout returns the layout used in the KubeVirt pods.
+// The private VM files are rooted in the base path, the shared KubeVirt and libvirt
+// directories are its siblings; only the block devices and the service account
+// mounted by Kubernetes are at fixed locations.
+func KubeVirtPathLayout() *PathLayout {
+	return &PathLayout{
+		DiskImage:           "{{.Base}}/vmi-disks/{{.Volume}}/disk.img",
+		EmptyDisk:           "{{.Base}}/../libvirt/empty-disks/{{.Volume}}.qcow2",
+		EphemeralDisk:       "{{.Base}}/../kubevirt-ephemeral-disks/disk-data/{{.Volume}}/disk.qcow2",
+		ContainerDisk:       "{{.Base}}/../kubevirt-ephemeral-disks/container-disk-data/{{.Namespace}}/{{.Name}}/disk_{{.Volume}}/disk-image.{{.Format}}",
+		ContainerDiskFormat: "qcow2",
+		CloudInitISO:        "{{.Base}}/../kubevirt-ephemeral-disks/cloud-init-data/{{.Namespace}}/{{.Name}}/noCloud.iso",
+		BlockDevice:         "/dev/{{.Volume}}",
+		ConfigMapISO:        "{{.Base}}/config-map-disks/{{.Volume}}.iso",
+		SecretISO:           "{{.Base}}/secret-disks/{{.Volume}}.iso",
+		ServiceAccountISO:   "{{.Base}}/service-account-disk/service-account.iso",
+		ConfigMapDir:        "{{.Base}}/config-map/{{.Volume}}",
+		SecretDir:           "{{.Base}}/secret/{{.Volume}}",
+		ServiceAccountDir:   "/var/run/secrets/kubernetes.io/serviceaccount",
+		SerialSocket:        "{{.Base}}/{{.Namespace}}/{{.Name}}/virt-serial{{.Port}}",
+		VNCSocket:           "{{.Base}}/{{.Namespace}}/{{.Name}}/virt-vnc",
+		SpiceSocket:         "{{.Base}}/{{.Namespace}}/{{.Name}}/virt-spice",
+		GuestAgentSocket:    "{{.Base}}/{{.Namespace}}/{{.Name}}/virt-guest-agent",
+		ChannelSocket:       "{{.Base}}/{{.Namespace}}/{{.Name}}/virt-channel-{{.Volume}}",
+		VhostUserSocket:     "{{.Base}}/../vhost-user/{{.Namespace}}/{{.Name}}/{{.Volume}}.sock",
+		NVRAM:               "{{.Base}}/{{.Namespace}}/{{.Name}}/nvram/efivars.fd",
+	}
+}
+
+// HostPathLayout returns a layout fully rooted in the base path, suitable for plain libvirt hosts.
+func HostPathLayout() *PathLayout {
+	return &PathLayout{
+		DiskImage:           "{{.Base}}/{{.Namespace}}/{{.Name}}/disks/{{.Volume}}.img",
+		EmptyDisk:           "{{.Base}}/{{.Namespace}}/{{.Name}}/empty/{{.Volume}}.qcow2",
+		EphemeralDisk:       "{{.Base}}/{{.Namespace}}/{{.Name}}/ephemeral/{{.Volume}}.qcow2",
+		ContainerDisk:       "{{.Base}}/{{.Namespace}}/{{.Name}}/container-disk/{{.Volume}}.{{.Format}}",
+		ContainerDiskFormat: "qcow2",
+		CloudInitISO:        "{{.Base}}/{{.Namespace}}/{{.Name}}/cloud-init/noCloud.iso",
+		BlockDevice:         "{{.Base}}/{{.Namespace}}/{{.Name}}/block/{{.Volume}}",
+		ConfigMapISO:        "{{.Base}}/{{.Namespace}}/{{.Name}}/config-map/{{.Volume}}.iso",
+		SecretISO:           "{{.Base}}/{{.Namespace}}/{{.Name}}/secret/{{.Volume}}.iso",
+		ServiceAccountISO:   "{{.Base}}/{{.Namespace}}/{{.Name}}/service-account/service-account.iso",
+		ConfigMapDir:        "{{.Base}}/{{.Namespace}}/{{.Name}}/config-map/{{.Volume}}",
+		SecretDir:           "{{.Base}}/{{.Namespace}}/{{.Name}}/secret/{{.Volume}}",
+		ServiceAccountDir:   "{{.Base}}/{{.Namespace}}/{{.Name}}/service-account/data",
+		SerialSocket:        "{{.Base}}/{{.Namespace}}/{{.Name}}/sockets/serial{{.Port}}",
+		VNCSocket:           "{{.Base}}/{{.Namespace}}/{{.Name}}/sockets/vnc",
+		SpiceSocket:         "{{.Base}}/{{.Namespace}}/{{.Name}}/sockets/spice",
+		GuestAgentSocket:    "{{.Base}}/{{.Namespace}}/{{.Name}}/sockets/guest-agent",
+		ChannelSocket:       "{{.Base}}/{{.Namespace}}/{{.Name}}/sockets/channel-{{.Volume}}",
+		VhostUserSocket:     "{{.Base}}/{{.Namespace}}/{{.Name}}/sockets/vhost-user-{{.Volume}}",
+		NVRAM:               "{{.Base}}/{{.Namespace}}/{{.Name}}/nvram/efivars.fd",
+	}
+}
+
+type pathParams struct {
+	Base      string
+	Namespace string
+	Name      string
+	Volume    string
+	Format    string
+	Port      uint
+}
+
+// pathResolver expands the PathLayout templates for a given VM
+type pathResolver struct {
+	layout *PathLayout
+	base   string
+	params pathParams
+}
+
+func newPathResolver(layout *PathLayout, base, namespace, name string) *pathResolver {
+	if layout == nil {
+		layout = KubeVirtPathLayout()
+	}
+	if base == "" {
+		base = DefaultBaseDiskPath
+	}
+	return &pathResolver{
+		layout: layout,
+		base:   base,
+		params: pathParams{
+			Base:      base,
+			Namespace: namespace,
+			Name:      name,
+			Format:    layout.ContainerDiskFormat,
+		},
+	}
+}
+
+func (r *pathResolver) expand(what, tmpl, volume string, port uint) (string, error) {
+	if tmpl == "" {
+		return "", fmt.Errorf("path layout: missing template for %s", what)
+	}
+	t, err := template.New(what).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("path layout: malformed template for %s: %v", what, err)
+	}
+	params := r.params
+	params.Volume = volume
+	params.Port = port
+	var buf bytes.Buffer
+	err = t.Execute(&buf, params)
+	if err != nil {
+		return "", fmt.Errorf("path layout: cannot expand template for %s: %v", what, err)
+	}
+	return filepath.Clean(buf.String()), nil
+}
+
+func (r *pathResolver) DiskImage(volume string) (string, error) {
+	return r.expand("diskImage", r.layout.DiskImage, volume, 0)
+}
+
+func (r *pathResolver) EmptyDisk(volume string) (string, error) {
+	return r.expand("emptyDisk", r.layout.EmptyDisk, volume, 0)
+}
+
+func (r *pathResolver) EphemeralDisk(volume string) (string, error) {
+	return r.expand("ephemeralDisk", r.layout.EphemeralDisk, volume, 0)
+}
+
+// ContainerDisk returns the path and the format of a container disk image.
+// The format is fixed by the layout: the host is never looked at.
+func (r *pathResolver) ContainerDisk(volume string) (string, string, error) {
+	if !containsString(ContainerDiskFormats, r.params.Format) {
+		return "", "", fmt.Errorf("path layout: unsupported container disk format %q", r.params.Format)
+	}
+	path, err := r.expand("containerDisk", r.layout.ContainerDisk, volume, 0)
+	return path, r.params.Format, err
+}
+
+func (r *pathResolver) CloudInitISO() (string, error) {
+	return r.expand("cloudInitISO", r.layout.CloudInitISO, "", 0)
+}
+
+func (r *pathResolver) BlockDevice(volume string) (string, error) {
+	return r.expand("blockDevice", r.layout.BlockDevice, volume, 0)
+}
+
+func (r *pathResolver) ConfigMapISO(volume string) (string, error) {
+	return r.expand("configMapISO", r.layout.ConfigMapISO, volume, 0)
+}
+
+func (r *pathResolver) SecretISO(volume string) (string, error) {
+	return r.expand("secretISO", r.layout.SecretISO, volume, 0)
+}
+
+func (r *pathResolver) ServiceAccountISO() (string, error) {
+	return r.expand("serviceAccountISO", r.layout.ServiceAccountISO, "", 0)
+}
+
+func (r *pathResolver) ConfigMapDir(volume string) (string, error) {
+	return r.expand("configMapDir", r.layout.ConfigMapDir, volume, 0)
+}
+
+func (r *pathResolver) SecretDir(volume string) (string, error) {
+	return r.expand("secretDir", r.layout.SecretDir, volume, 0)
+}
+
+func (r *pathResolver) ServiceAccountDir() (string, error) {
+	return r.expand("serviceAccountDir", r.layout.ServiceAccountDir, "", 0)
+}
+
+func (r *pathResolver) SerialSocket(port uint) (string, error) {
+	return r.expand("serialSocket", r.layout.SerialSocket, "", port)
+}
+
+func (r *pathResolver) VNCSocket() (string, error) {
+	return r.expand("vncSocket", r.layout.VNCSocket, "", 0)
+}
+
+func (r *pathResolver) SpiceSocket() (string, error) {
+	return r.expand("spiceSocket", r.layout.SpiceSocket, "", 0)
+}
+
+func (r *pathResolver) GuestAgentSocket() (string, error) {
+	return r.expand("guestAgentSocket", r.layout.GuestAgentSocket, "", 0)
+}
+
+func (r *pathResolver) ChannelSocket(channel string) (string, error) {
+	return r.expand("channelSocket", r.layout.ChannelSocket, channel, 0)
+}
+
+func (r *pathResolver) VhostUserSocket(iface string) (string, error) {
+	return r.expand("vhostUserSocket", r.layout.VhostUserSocket, iface, 0)
+}
+
+func (r *pathResolver) NVRAM() (string, error) {
+	return r.expand("nvram", r.layout.NVRAM, "", 0)
+}
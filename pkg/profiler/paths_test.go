@@ -0,0 +1,162 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathResolver(t *testing.T) {
+	kubevirt := newPathResolver(nil, "/var/run/kubevirt-private", "default", "testvmi")
+	host := newPathResolver(HostPathLayout(), testBaseDiskPath, "default", "testvmi")
+	tests := []struct {
+		name    string
+		resolve func() (string, error)
+		want    string
+	}{
+		{
+			name:    "kubevirt disk image",
+			resolve: func() (string, error) { return kubevirt.DiskImage("root") },
+			want:    "/var/run/kubevirt-private/vmi-disks/root/disk.img",
+		},
+		{
+			name:    "kubevirt empty disk in a sibling directory",
+			resolve: func() (string, error) { return kubevirt.EmptyDisk("scratch") },
+			want:    "/var/run/libvirt/empty-disks/scratch.qcow2",
+		},
+		{
+			name:    "kubevirt cloud-init ISO",
+			resolve: func() (string, error) { return kubevirt.CloudInitISO() },
+			want:    "/var/run/kubevirt-ephemeral-disks/cloud-init-data/default/testvmi/noCloud.iso",
+		},
+		{
+			name:    "kubevirt ephemeral disk",
+			resolve: func() (string, error) { return kubevirt.EphemeralDisk("root") },
+			want:    "/var/run/kubevirt-ephemeral-disks/disk-data/root/disk.qcow2",
+		},
+		{
+			name:    "kubevirt serial socket",
+			resolve: func() (string, error) { return kubevirt.SerialSocket(1) },
+			want:    "/var/run/kubevirt-private/default/testvmi/virt-serial1",
+		},
+		{
+			name:    "host disk image",
+			resolve: func() (string, error) { return host.DiskImage("root") },
+			want:    testBaseDiskPath + "/default/testvmi/disks/root.img",
+		},
+		{
+			name:    "host VNC socket",
+			resolve: func() (string, error) { return host.VNCSocket() },
+			want:    testBaseDiskPath + "/default/testvmi/sockets/vnc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.resolve()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathResolverDefaults(t *testing.T) {
+	r := newPathResolver(nil, "", "default", "testvmi")
+	path, err := r.VNCSocket()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := DefaultBaseDiskPath + "/default/testvmi/virt-vnc"; path != want {
+		t.Errorf("got %s, want %s", path, want)
+	}
+}
+
+func TestPathResolverErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout *PathLayout
+		want   string
+	}{
+		{
+			name:   "missing template",
+			layout: &PathLayout{},
+			want:   "missing template for diskImage",
+		},
+		{
+			name:   "malformed template",
+			layout: &PathLayout{DiskImage: "{{.Base"},
+			want:   "malformed template for diskImage",
+		},
+		{
+			name:   "unknown field",
+			layout: &PathLayout{DiskImage: "{{.Base}}/{{.Pod}}/disk.img"},
+			want:   "cannot expand template for diskImage",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newPathResolver(tt.layout, testBaseDiskPath, "default", "testvmi").DiskImage("root")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerDiskFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		wantErr bool
+	}{
+		{name: "qcow2", format: "qcow2"},
+		{name: "raw", format: "raw"},
+		{name: "missing format", wantErr: true},
+		{name: "unsupported format", format: "vmdk", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout := &PathLayout{
+				ContainerDisk:       "{{.Base}}/{{.Volume}}.{{.Format}}",
+				ContainerDiskFormat: tt.format,
+			}
+			path, format, err := newPathResolver(layout, testBaseDiskPath, "default", "testvmi").ContainerDisk("root")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("got format %s, want %s", format, tt.format)
+			}
+			if want := filepath.Join(testBaseDiskPath, "root."+tt.format); path != want {
+				t.Errorf("got path %s, want %s", path, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+const (
+	// first slot of the root bus free for the planned devices; the lower ones are used by
+	// the host bridge, the ISA bridge and the video card
+	pciFirstFreeSlot = 3
+	pciLastSlot      = 31
+	// the q35 LPC, SATA and SMBus controllers live in the slot 0x1f
+	pcieLastSlot    = 0x1e
+	pciFunctions    = 8
+	pciRootIndex    = 0
+	pcieRootPortPfx = "pci.port"
+	pciBridgePfx    = "pci.bridge"
+)
+
+// pciDevice is a device of the domain which needs a guest PCI address
+type pciDevice struct {
+	// group orders the devices: disks and interfaces come first, so the controllers and the
+	// other devices the profiles add never move them
+	group int
+	kind  string
+	// index is the position of the device in the domain list of its kind
+	index   int
+	address **libvirtxml.DomainAddress
+}
+
+// pciPlanner assigns the guest PCI addresses, building the controllers the topology needs
+type pciPlanner struct {
+	nextIndex uint
+	used      map[string]bool
+	// controllers are added to the domain only once all the addresses are assigned,
+	// because the planned devices point into the domain controller list
+	controllers []libvirtxml.DomainController
+}
+
+// completePCIAddresses assigns a stable PCI address to every device which has none.
+// On q35 machines each device gets its own pcie-root-port, on i440fx machines the devices
+// are placed on the root bus, then on pci-bridges once it is full.
+func completePCIAddresses(dom *libvirtxml.Domain, c *ConverterContext) error {
+	if dom.Devices == nil {
+		return nil
+	}
+	conf := c.Profile.PCI
+	if conf == nil {
+		conf = &PCIConfig{}
+	}
+	if conf.Disabled {
+		return nil
+	}
+
+	planner := newPCIPlanner(dom)
+	devices := collectPCIDevices(dom)
+	// devices keep the order of their domain list, so appending a device never moves
+	// the ones of the same kind declared before it
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].group != devices[j].group {
+			return devices[i].group < devices[j].group
+		}
+		if devices[i].kind != devices[j].kind {
+			return devices[i].kind < devices[j].kind
+		}
+		return devices[i].index < devices[j].index
+	})
+
+	var err error
+	if isQ35Machine(dom) {
+		err = planner.planPCIe(devices, conf.HotplugPorts)
+	} else {
+		if conf.HotplugPorts > 0 {
+			c.warn("Ignoring the hotplug ports: i440fx machines have hotpluggable slots on every PCI bus")
+		}
+		err = planner.planPCI(devices)
+	}
+	if err != nil {
+		return err
+	}
+	dom.Devices.Controllers = append(dom.Devices.Controllers, planner.controllers...)
+	return nil
+}
+
+func isQ35Machine(dom *libvirtxml.Domain) bool {
+	return dom.OS != nil && dom.OS.Type != nil && strings.Contains(dom.OS.Type.Machine, "q35")
+}
+
+func newPCIPlanner(dom *libvirtxml.Domain) *pciPlanner {
+	planner := &pciPlanner{
+		nextIndex: pciRootIndex + 1,
+		used:      make(map[string]bool),
+	}
+	for _, ctrl := range dom.Devices.Controllers {
+		if ctrl.Type == "pci" && ctrl.Index != nil && *ctrl.Index >= planner.nextIndex {
+			planner.nextIndex = *ctrl.Index + 1
+		}
+	}
+	// addresses set by the profiles are kept
+	for _, dev := range collectPCIDevices(dom) {
+		if addr := *dev.address; addr != nil && addr.PCI != nil {
+			planner.used[formatPCIAddress(addr.PCI)] = true
+		}
+	}
+	for _, ctrl := range dom.Devices.Controllers {
+		if ctrl.Address != nil && ctrl.Address.PCI != nil {
+			planner.used[formatPCIAddress(ctrl.Address.PCI)] = true
+		}
+	}
+	return planner
+}
+
+// collectPCIDevices lists the devices of the domain which sit on the PCI bus
+func collectPCIDevices(dom *libvirtxml.Domain) []pciDevice {
+	devs := []pciDevice{}
+	add := func(group int, kind string, idx int, address **libvirtxml.DomainAddress) {
+		devs = append(devs, pciDevice{group: group, kind: kind, index: idx, address: address})
+	}
+
+	for i := range dom.Devices.Interfaces {
+		iface := &dom.Devices.Interfaces[i]
+		add(0, "interface", i, &iface.Address)
+	}
+	for i := range dom.Devices.Disks {
+		disk := &dom.Devices.Disks[i]
+		if disk.Target != nil && disk.Target.Bus == "virtio" {
+			add(0, "disk", i, &disk.Address)
+		}
+	}
+	for i := range dom.Devices.Hostdevs {
+		hostdev := &dom.Devices.Hostdevs[i]
+		if hostdev.SubsysPCI != nil {
+			add(1, "hostdev", i, &hostdev.Address)
+		}
+	}
+	for i := range dom.Devices.Controllers {
+		ctrl := &dom.Devices.Controllers[i]
+		switch ctrl.Type {
+		case "scsi", "virtio-serial":
+			add(1, "controller", i, &ctrl.Address)
+		case "usb":
+			if ctrl.Model != "none" {
+				add(1, "controller", i, &ctrl.Address)
+			}
+		}
+	}
+	if dom.Devices.Watchdog != nil && dom.Devices.Watchdog.Model == "i6300esb" {
+		add(1, "watchdog", 0, &dom.Devices.Watchdog.Address)
+	}
+	if dom.Devices.MemBalloon != nil && dom.Devices.MemBalloon.Model == "virtio" {
+		add(1, "memballoon", 0, &dom.Devices.MemBalloon.Address)
+	}
+	for i := range dom.Devices.RNGs {
+		rng := &dom.Devices.RNGs[i]
+		add(1, "rng", i, &rng.Address)
+	}
+	for i := range dom.Devices.Filesystems {
+		fs := &dom.Devices.Filesystems[i]
+		if fs.Driver != nil && fs.Driver.Type == "virtiofs" {
+			add(1, "filesystem", i, &fs.Address)
+		}
+	}
+	for i := range dom.Devices.Inputs {
+		input := &dom.Devices.Inputs[i]
+		if input.Bus == "virtio" {
+			add(1, "input", i, &input.Address)
+		}
+	}
+	return devs
+}
+
+func pciAddress(bus, slot, function uint) *libvirtxml.DomainAddress {
+	domain := uint(0)
+	return &libvirtxml.DomainAddress{
+		PCI: &libvirtxml.DomainAddressPCI{
+			Domain:   &domain,
+			Bus:      &bus,
+			Slot:     &slot,
+			Function: &function,
+		},
+	}
+}
+
+// nextRootSlot returns the next free function on the root bus, up to the given slot
+func (p *pciPlanner) nextRootSlot(lastSlot uint, byFunction bool) (*libvirtxml.DomainAddress, error) {
+	functions := uint(1)
+	if byFunction {
+		functions = pciFunctions
+	}
+	for slot := uint(pciFirstFreeSlot); slot <= lastSlot; slot++ {
+		for function := uint(0); function < functions; function++ {
+			addr := pciAddress(pciRootIndex, slot, function)
+			key := formatPCIAddress(addr.PCI)
+			if p.used[key] {
+				continue
+			}
+			p.used[key] = true
+			if byFunction && function == 0 {
+				addr.PCI.MultiFunction = "on"
+			}
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("no free slot left on the PCI root bus")
+}
+
+func (p *pciPlanner) addController(model, alias string, addr *libvirtxml.DomainAddress) uint {
+	index := p.nextIndex
+	p.nextIndex++
+	p.controllers = append(p.controllers, libvirtxml.DomainController{
+		Type:    "pci",
+		Index:   &index,
+		Model:   model,
+		Address: addr,
+		Alias: &libvirtxml.DomainAlias{
+			Name: fmt.Sprintf("%s%d", alias, index),
+		},
+	})
+	return index
+}
+
+// planPCIe gives each device its own pcie-root-port, and adds the spare ports for hotplug.
+// The root ports are packed as functions of the root bus slots.
+func (p *pciPlanner) planPCIe(devices []pciDevice, hotplugPorts uint) error {
+	addPort := func() (uint, error) {
+		addr, err := p.nextRootSlot(pcieLastSlot, true)
+		if err != nil {
+			return 0, err
+		}
+		return p.addController("pcie-root-port", pcieRootPortPfx, addr), nil
+	}
+
+	for _, dev := range devices {
+		if *dev.address != nil {
+			continue
+		}
+		bus, err := addPort()
+		if err != nil {
+			return err
+		}
+		*dev.address = pciAddress(bus, 0, 0)
+	}
+	for i := uint(0); i < hotplugPorts; i++ {
+		_, err := addPort()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planPCI places the devices on the root bus, then on as many pci-bridges as needed.
+// The first bridge takes the last free root slot, each further bridge the last slot of the previous one.
+func (p *pciPlanner) planPCI(devices []pciDevice) error {
+	pending := []pciDevice{}
+	for _, dev := range devices {
+		if *dev.address == nil {
+			pending = append(pending, dev)
+		}
+	}
+
+	onRoot := len(pending)
+	if free := p.freeRootSlots(); onRoot > free {
+		onRoot = free - 1
+	}
+	if onRoot < 0 {
+		return fmt.Errorf("no free slot left on the PCI root bus")
+	}
+	for _, dev := range pending[:onRoot] {
+		addr, err := p.nextRootSlot(pciLastSlot, false)
+		if err != nil {
+			return err
+		}
+		*dev.address = addr
+	}
+
+	rest := pending[onRoot:]
+	if len(rest) == 0 {
+		return nil
+	}
+	addr, err := p.nextRootSlot(pciLastSlot, false)
+	if err != nil {
+		return err
+	}
+	bus := p.addController("pci-bridge", pciBridgePfx, addr)
+	slot := uint(1)
+	for i, dev := range rest {
+		if slot == pciLastSlot && len(rest)-i > 1 {
+			bus = p.addController("pci-bridge", pciBridgePfx, pciAddress(bus, slot, 0))
+			slot = 1
+		}
+		*dev.address = pciAddress(bus, slot, 0)
+		slot++
+	}
+	return nil
+}
+
+func (p *pciPlanner) freeRootSlots() int {
+	free := 0
+	for slot := uint(pciFirstFreeSlot); slot <= pciLastSlot; slot++ {
+		if !p.used[formatPCIAddress(pciAddress(pciRootIndex, slot, 0).PCI)] {
+			free++
+		}
+	}
+	return free
+}
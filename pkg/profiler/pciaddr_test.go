@@ -0,0 +1,235 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// pciAddresses returns the PCI addresses of the disks and the interfaces, by alias
+func pciAddresses(domain *libvirtxml.Domain) map[string]string {
+	addrs := map[string]string{}
+	for _, disk := range domain.Devices.Disks {
+		if disk.Address != nil && disk.Address.PCI != nil {
+			addrs[disk.Alias.Name] = formatPCIAddress(disk.Address.PCI)
+		}
+	}
+	for _, iface := range domain.Devices.Interfaces {
+		if iface.Address != nil && iface.Address.PCI != nil {
+			addrs[iface.Alias.Name] = formatPCIAddress(iface.Address.PCI)
+		}
+	}
+	return addrs
+}
+
+func TestCompletePCIAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		machine string
+		disks   int
+		conf    *PCIConfig
+		modify  func(domain *libvirtxml.Domain)
+		// want are some expected addresses, by alias
+		want            map[string]string
+		wantAddresses   int
+		wantControllers []string
+		wantWarning     bool
+	}{
+		{
+			name:            "i440fx root bus",
+			machine:         "pc-i440fx-2.12",
+			disks:           2,
+			want:            map[string]string{"vda": "0000:00:03.0", "vdb": "0000:00:04.0", "default": "0000:00:05.0"},
+			wantAddresses:   3,
+			wantControllers: []string{},
+		},
+		{
+			name:            "i440fx pci-bridge once the root bus is full",
+			machine:         "pc-i440fx-2.12",
+			disks:           30,
+			want:            map[string]string{"vdab": "0000:00:1e.0", "vdac": "0000:01:01.0", "default": "0000:01:03.0"},
+			wantAddresses:   31,
+			wantControllers: []string{"pci-bridge@0000:00:1f.0"},
+		},
+		{
+			name:            "i440fx ignores the hotplug ports",
+			machine:         "pc-i440fx-2.12",
+			disks:           1,
+			conf:            &PCIConfig{HotplugPorts: 2},
+			want:            map[string]string{"vda": "0000:00:03.0", "default": "0000:00:04.0"},
+			wantAddresses:   2,
+			wantControllers: []string{},
+			wantWarning:     true,
+		},
+		{
+			name:          "q35 root ports",
+			machine:       "pc-q35-2.12",
+			disks:         2,
+			want:          map[string]string{"vda": "0000:01:00.0", "vdb": "0000:02:00.0", "default": "0000:03:00.0"},
+			wantAddresses: 3,
+			wantControllers: []string{
+				"pcie-root-port@0000:00:03.0",
+				"pcie-root-port@0000:00:03.1",
+				"pcie-root-port@0000:00:03.2",
+			},
+		},
+		{
+			name:          "q35 hotplug ports",
+			machine:       "q35",
+			disks:         1,
+			conf:          &PCIConfig{HotplugPorts: 2},
+			want:          map[string]string{"vda": "0000:01:00.0", "default": "0000:02:00.0"},
+			wantAddresses: 2,
+			wantControllers: []string{
+				"pcie-root-port@0000:00:03.0",
+				"pcie-root-port@0000:00:03.1",
+				"pcie-root-port@0000:00:03.2",
+				"pcie-root-port@0000:00:03.3",
+			},
+		},
+		{
+			name:    "q35 controllers numbered after the existing ones",
+			machine: "q35",
+			modify: func(domain *libvirtxml.Domain) {
+				index := uint(1)
+				domain.Devices.Controllers = []libvirtxml.DomainController{
+					{Type: "pci", Index: &index, Model: "pcie-root-port", Address: pciAddress(0, 3, 0)},
+				}
+			},
+			want:          map[string]string{"default": "0000:02:00.0"},
+			wantAddresses: 1,
+			wantControllers: []string{
+				"pcie-root-port@0000:00:03.0",
+				"pcie-root-port@0000:00:03.1",
+			},
+		},
+		{
+			name:    "addresses set by the profiles are kept",
+			machine: "pc-i440fx-2.12",
+			disks:   2,
+			modify: func(domain *libvirtxml.Domain) {
+				domain.Devices.Disks[1].Address = pciAddress(0, 3, 0)
+			},
+			want:            map[string]string{"vda": "0000:00:04.0", "vdb": "0000:00:03.0", "default": "0000:00:05.0"},
+			wantAddresses:   3,
+			wantControllers: []string{},
+		},
+		{
+			name:            "disabled",
+			machine:         "q35",
+			disks:           2,
+			conf:            &PCIConfig{Disabled: true},
+			want:            map[string]string{},
+			wantControllers: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{PCI: tt.conf})
+			domain := newTestDomain(tt.machine, tt.disks, 0)
+			if tt.modify != nil {
+				tt.modify(domain)
+			}
+			err := completePCIAddresses(domain, c)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			addrs := pciAddresses(domain)
+			if len(addrs) != tt.wantAddresses {
+				t.Errorf("got %d addresses, want %d", len(addrs), tt.wantAddresses)
+			}
+			for alias, want := range tt.want {
+				if addrs[alias] != want {
+					t.Errorf("%s: got address %s, want %s", alias, addrs[alias], want)
+				}
+			}
+
+			ctrls := []string{}
+			for _, ctrl := range domain.Devices.Controllers {
+				ctrls = append(ctrls, ctrl.Model+"@"+formatPCIAddress(ctrl.Address.PCI))
+			}
+			if fmt.Sprint(ctrls) != fmt.Sprint(tt.wantControllers) {
+				t.Errorf("got controllers %v, want %v", ctrls, tt.wantControllers)
+			}
+			if got := containsWarning(c.Warnings, "Ignoring the hotplug ports"); got != tt.wantWarning {
+				t.Errorf("got warnings %v, want the hotplug warning: %v", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCompletePCIAddressesAppendedDisk(t *testing.T) {
+	for _, machine := range []string{"pc-i440fx-2.12", "q35"} {
+		t.Run(machine, func(t *testing.T) {
+			addrs := map[int]map[string]string{}
+			// vdaa sorts before vdb, but follows vdz in the domain
+			for _, disks := range []int{26, 27} {
+				domain := newTestDomain(machine, disks, 0)
+				err := completePCIAddresses(domain, newTestContext(nil))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				addrs[disks] = pciAddresses(domain)
+			}
+			for i := 0; i < 26; i++ {
+				alias := formatDeviceName("vd", i)
+				if addrs[26][alias] != addrs[27][alias] {
+					t.Errorf("%s: moved from %s to %s", alias, addrs[26][alias], addrs[27][alias])
+				}
+			}
+		})
+	}
+}
+
+func TestCompletePCIAddressesRootBusFull(t *testing.T) {
+	domain := newTestDomain("pc-i440fx-2.12", 0, 0)
+	for slot := uint(pciFirstFreeSlot); slot <= pciLastSlot; slot++ {
+		domain.Devices.Hostdevs = append(domain.Devices.Hostdevs, libvirtxml.DomainHostdev{
+			SubsysPCI: &libvirtxml.DomainHostdevSubsysPCI{},
+			Address:   pciAddress(0, slot, 0),
+		})
+	}
+	err := completePCIAddresses(domain, newTestContext(nil))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestCompletePCIAddressesMultiFunction(t *testing.T) {
+	domain := newTestDomain("q35", 1, 0)
+	err := completePCIAddresses(domain, newTestContext(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctrls := domain.Devices.Controllers
+	if len(ctrls) != 2 {
+		t.Fatalf("got %d controllers, want 2", len(ctrls))
+	}
+	if ctrls[0].Address.PCI.MultiFunction != "on" || ctrls[1].Address.PCI.MultiFunction != "" {
+		t.Errorf("only the first function of the slot must enable multifunction")
+	}
+	for i, ctrl := range ctrls {
+		if want := fmt.Sprintf("%s%d", pcieRootPortPfx, i+1); ctrl.Alias.Name != want || *ctrl.Index != uint(i+1) {
+			t.Errorf("got controller %s with index %d, want %s", ctrl.Alias.Name, *ctrl.Index, want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// Stage identifies a step of the profiler pipeline
+type Stage string
+
+const (
+	StagePresets   Stage = "presets"
+	StageTranslate Stage = "translate"
+	StageProfiles  Stage = "profiles"
+	StageComplete  Stage = "complete"
+)
+
+// Stages lists all the pipeline stages, in execution order
+var Stages = []Stage{StagePresets, StageTranslate, StageProfiles, StageComplete}
+
+// Request holds all the inputs of a pipeline run
+type Request struct {
+	// Presets to apply in the stage1
+	Presets []k6tv1.VirtualMachineInstancePreset
+	// XMLProfiles to apply in the stage3
+	XMLProfiles []string
+	// Profiles to use in this run, after the ones added to the Profiler
+	Profiles []*Profile
+	// DNS settings to use in this run, overriding the ones found in the profiles
+	DNS *DNSConfig
+	// HostCPUSet is the host CPU set the dedicated CPUs are pinned to in this run,
+	// overriding the one of the Host. See CompleteWithHostCPUSet.
+	HostCPUSet string
+	// StopAfter makes the pipeline stop after the given stage. Empty means run all the stages.
+	StopAfter Stage
+	// Timeouts sets the maximum running time of each stage. Zero or missing means no timeout.
+	// A stage which times out is abandoned: it stops at its next checkpoint, like between two
+	// presets or two XML profiles, and its output is dropped.
+	Timeouts map[Stage]time.Duration
+}
+
+// Result holds the outputs of a pipeline run. The output of any stage which did not run is nil.
+type Result struct {
+	// Presets is the stage1 output: the DomainSpec with all the presets applied
+	Presets *k6tv1.DomainSpec
+	// Translated is the stage2 output
+	Translated *libvirtxml.Domain
+	// Secrets are the libvirt secrets the domain needs, also produced by the stage2
+	Secrets []Secret
+	// Profiled is the stage3 output, with all the XML profiles applied
+	Profiled *libvirtxml.Domain
+	// Completed is the final output of the pipeline
+	Completed *libvirtxml.Domain
+	// Warnings collects the warnings of all the stages which ran, prefixed by the stage name
+	Warnings []string
+}
+
+// Output returns the output of the last stage which ran, as libvirt XML or as DomainSpec.
+func (r *Result) Output() (*libvirtxml.Domain, *k6tv1.DomainSpec) {
+	if r.Completed != nil {
+		return r.Completed, nil
+	}
+	if r.Profiled != nil {
+		return r.Profiled, nil
+	}
+	if r.Translated != nil {
+		return r.Translated, nil
+	}
+	return nil, r.Presets
+}
+
+func (r *Result) addWarnings(stage Stage, warnings []string) {
+	for _, warning := range warnings {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("%s: %s", stage, warning))
+	}
+}
+
+// Run executes all the pipeline stages in order on the given VirtualMachineInstance.
+// The outputs of all the stages are saved in the returned Result, which is returned
+// also in case of error, holding the output of the stages completed so far.
+// On cancellation or timeout Run returns at once; the running stage works on its own copy
+// of the data, so it doesn't touch the Result, nor the VMI and the Request, while it stops.
+func (p *Profiler) Run(ctx context.Context, vmi *k6tv1.VirtualMachineInstance, req *Request) (*Result, error) {
+	res := &Result{
+		Warnings: []string{},
+	}
+	if req == nil {
+		req = &Request{}
+	}
+	if req.StopAfter != "" && !isValidStage(req.StopAfter) {
+		return res, fmt.Errorf("unknown stage: %s", req.StopAfter)
+	}
+
+	// the request settings must not leak in the next runs, so they are applied on a copy
+	rp := *p
+	rp.profiles = append([]*Profile{}, p.profiles...)
+	rp.profiles = append(rp.profiles, req.Profiles...)
+	if req.DNS != nil {
+		rp.AddProfile(&Profile{
+			Spec: ProfileSpec{
+				DNS: req.DNS,
+			},
+		})
+	}
+	// the stages read the VMI from the profiler, so they get a copy the caller can't change under them
+	rp.SetVirtualMachine(vmi.DeepCopy())
+	return rp.run(ctx, vmi, req, res)
+}
+
+func (p *Profiler) run(ctx context.Context, vmi *k6tv1.VirtualMachineInstance, req *Request, res *Result) (*Result, error) {
+	// every stage works on a private copy of its input and writes its output in a local
+	// variable, published in the Result only once the stage completed: an abandoned stage
+	// shares nothing with the caller.
+	input, err := cloneDomainSpec(&vmi.Spec.Domain)
+	if err != nil {
+		return res, err
+	}
+	presets := append([]k6tv1.VirtualMachineInstancePreset{}, req.Presets...)
+	var domSpec *k6tv1.DomainSpec
+	err = p.runStage(ctx, req, StagePresets, res, func(ctx context.Context) (warnings []string, err error) {
+		domSpec, warnings, err = p.applyPresets(ctx, input, presets)
+		return warnings, err
+	})
+	if err != nil {
+		return res, err
+	}
+	res.Presets = domSpec
+	if req.StopAfter == StagePresets {
+		return res, nil
+	}
+
+	translateInput := domSpec.DeepCopy()
+	var translated *libvirtxml.Domain
+	var secrets []Secret
+	err = p.runStage(ctx, req, StageTranslate, res, func(ctx context.Context) (warnings []string, err error) {
+		translated, secrets, warnings, err = p.translateSpecs(ctx, translateInput)
+		return warnings, err
+	})
+	if err != nil {
+		return res, err
+	}
+	res.Translated = translated
+	res.Secrets = secrets
+	if req.StopAfter == StageTranslate {
+		return res, nil
+	}
+
+	profilesInput, err := cloneDomain(translated)
+	if err != nil {
+		return res, err
+	}
+	xmlProfiles := append([]string{}, req.XMLProfiles...)
+	var profiled *libvirtxml.Domain
+	err = p.runStage(ctx, req, StageProfiles, res, func(ctx context.Context) (warnings []string, err error) {
+		profiled, warnings, err = p.applyProfiles(ctx, profilesInput, xmlProfiles)
+		return warnings, err
+	})
+	if err != nil {
+		return res, err
+	}
+	res.Profiled = profiled
+	if req.StopAfter == StageProfiles {
+		return res, nil
+	}
+
+	completeInput, err := cloneDomain(profiled)
+	if err != nil {
+		return res, err
+	}
+	var completed *libvirtxml.Domain
+	err = p.runStage(ctx, req, StageComplete, res, func(ctx context.Context) (warnings []string, err error) {
+		completed, warnings, err = p.complete(ctx, completeInput, req.HostCPUSet)
+		return warnings, err
+	})
+	if err != nil {
+		return res, err
+	}
+	res.Completed = completed
+	return res, nil
+}
+
+type stageOutcome struct {
+	warnings []string
+	err      error
+}
+
+// runStage runs a single stage, honouring the cancellation of the given context and the stage timeout.
+// The stage gets the context, and stops at its next checkpoint once the context is done; runStage
+// doesn't wait for it, and the outputs of a stage which didn't complete in time are dropped.
+func (p *Profiler) runStage(ctx context.Context, req *Request, stage Stage, res *Result, fn func(ctx context.Context) ([]string, error)) error {
+	if timeout, ok := req.Timeouts[stage]; ok && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("stage %s: %v", stage, err)
+	}
+
+	done := make(chan stageOutcome, 1)
+	go func() {
+		warnings, err := fn(ctx)
+		done <- stageOutcome{warnings: warnings, err: err}
+	}()
+
+	outcome, err := awaitStage(ctx, done)
+	if err != nil {
+		return fmt.Errorf("stage %s: %v", stage, err)
+	}
+	res.addWarnings(stage, outcome.warnings)
+	if outcome.err != nil {
+		return fmt.Errorf("stage %s: %v", stage, outcome.err)
+	}
+	return nil
+}
+
+// awaitStage waits for the outcome of a stage, or for the context to be done.
+// A stage which completed right when the context was done keeps its output.
+func awaitStage(ctx context.Context, done <-chan stageOutcome) (stageOutcome, error) {
+	select {
+	case outcome := <-done:
+		return outcome, nil
+	case <-ctx.Done():
+		select {
+		case outcome := <-done:
+			return outcome, nil
+		default:
+			return stageOutcome{}, ctx.Err()
+		}
+	}
+}
+
+func isValidStage(stage Stage) bool {
+	for _, st := range Stages {
+		if st == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneDomain(dom *libvirtxml.Domain) (*libvirtxml.Domain, error) {
+	data, err := dom.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	ret := &libvirtxml.Domain{}
+	err = ret.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
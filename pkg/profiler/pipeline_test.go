@@ -0,0 +1,209 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestRunStopAfter(t *testing.T) {
+	tests := []struct {
+		stopAfter      Stage
+		wantTranslated bool
+		wantProfiled   bool
+		wantCompleted  bool
+	}{
+		{stopAfter: StagePresets},
+		{stopAfter: StageTranslate, wantTranslated: true},
+		{stopAfter: StageProfiles, wantTranslated: true, wantProfiled: true},
+		{stopAfter: StageComplete, wantTranslated: true, wantProfiled: true, wantCompleted: true},
+		{stopAfter: "", wantTranslated: true, wantProfiled: true, wantCompleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.stopAfter), func(t *testing.T) {
+			res, err := newTestProfiler().Run(context.Background(), newTestVMI(), &Request{StopAfter: tt.stopAfter})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.Presets == nil {
+				t.Errorf("missing the presets output")
+			}
+			if got := res.Translated != nil; got != tt.wantTranslated {
+				t.Errorf("got translated output: %v, want %v", got, tt.wantTranslated)
+			}
+			if got := res.Profiled != nil; got != tt.wantProfiled {
+				t.Errorf("got profiled output: %v, want %v", got, tt.wantProfiled)
+			}
+			if got := res.Completed != nil; got != tt.wantCompleted {
+				t.Errorf("got completed output: %v, want %v", got, tt.wantCompleted)
+			}
+
+			dom, domSpec := res.Output()
+			switch {
+			case tt.wantCompleted:
+				if dom != res.Completed {
+					t.Errorf("the output is not the completed domain")
+				}
+			case tt.wantProfiled:
+				if dom != res.Profiled {
+					t.Errorf("the output is not the profiled domain")
+				}
+			case tt.wantTranslated:
+				if dom != res.Translated {
+					t.Errorf("the output is not the translated domain")
+				}
+			default:
+				if dom != nil || domSpec != res.Presets {
+					t.Errorf("the output is not the presets DomainSpec")
+				}
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *Request
+		want string
+	}{
+		{
+			name: "unknown stage",
+			ctx:  context.Background(),
+			req:  &Request{StopAfter: "unknown"},
+			want: "unknown stage: unknown",
+		},
+		{
+			name: "canceled context",
+			ctx:  canceled,
+			req:  &Request{},
+			want: "stage presets: context canceled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := newTestProfiler().Run(tt.ctx, newTestVMI(), tt.req)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+			if res == nil {
+				t.Fatalf("missing the partial result")
+			}
+			if res.Profiled != nil || res.Completed != nil {
+				t.Errorf("got the outputs of stages which failed")
+			}
+		})
+	}
+}
+
+func TestRunWarnings(t *testing.T) {
+	// presets without the priority annotation can't be sorted, which is reported as a warning
+	presets := []k6tv1.VirtualMachineInstancePreset{newTestPreset("unmarked", "", "", nil)}
+	res, err := newTestProfiler().Run(context.Background(), newTestVMI(), &Request{
+		Presets:   presets,
+		StopAfter: StagePresets,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Warnings) == 0 {
+		t.Fatalf("expected the preset sorting warning")
+	}
+	for _, warning := range res.Warnings {
+		if !strings.HasPrefix(warning, "presets: ") {
+			t.Errorf("warning %q lacks the stage prefix", warning)
+		}
+	}
+}
+
+func TestRunDoesNotChangeTheInputs(t *testing.T) {
+	vmi := newTestVMI()
+	want := vmi.DeepCopy()
+	_, err := newTestProfiler().Run(context.Background(), vmi, &Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(vmi, want) {
+		t.Errorf("the VMI was changed")
+	}
+}
+
+func TestIsValidStage(t *testing.T) {
+	for _, stage := range Stages {
+		if !isValidStage(stage) {
+			t.Errorf("stage %s reported as invalid", stage)
+		}
+	}
+	for _, stage := range []Stage{"", "stage1", "Presets"} {
+		if isValidStage(stage) {
+			t.Errorf("stage %q reported as valid", stage)
+		}
+	}
+}
+
+func TestAwaitStagePrefersTheOutcome(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ready   bool
+		wantErr bool
+	}{
+		{name: "completed stage", ready: true},
+		{name: "pending stage", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan stageOutcome, 1)
+			if tt.ready {
+				done <- stageOutcome{warnings: []string{"finished"}}
+			}
+			outcome, err := awaitStage(canceled, done)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.ready && !reflect.DeepEqual(outcome.warnings, []string{"finished"}) {
+				t.Errorf("got outcome %+v, want the completed one", outcome)
+			}
+		})
+	}
+}
+
+func TestTranslateSpecsStopsWhenDone(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vmi := newTestVMI()
+	p := newTestProfiler().SetVirtualMachine(vmi)
+	_, _, _, err := p.translateSpecs(canceled, &vmi.Spec.Domain)
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,482 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProfileKind is the kind of the Profile objects
+const ProfileKind = "VirtProfile"
+
+// ProfileAPIVersion is the API version of the Profile objects
+const ProfileAPIVersion = "virt-profiles/v1alpha1"
+
+// Profile holds the settings which drive the translation (stage2) and the completion of the domains.
+// Unlike the XML profiles, which are applied as-is to the translated domain, these profiles
+// change how the domain is built.
+type Profile struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ProfileSpec `json:"spec"`
+}
+
+// ProfileSpec holds the profile settings, grouped by section. All the sections are optional;
+// when more profiles are used, each section found in a later profile replaces the same section
+// of the earlier profiles.
+type ProfileSpec struct {
+	// DNS configures the DNS settings of the user-mode (slirp) networks
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// Network configures how the VM interfaces are connected to the host networks
+	Network *NetworkConfig `json:"network,omitempty"`
+	// HostDevices configures the PCI devices passed through to the VMs
+	HostDevices *HostDevicesConfig `json:"hostDevices,omitempty"`
+	// PCI configures the guest PCI topology
+	PCI *PCIConfig `json:"pci,omitempty"`
+	// SCSI configures the controllers of the SCSI disks
+	SCSI *SCSIConfig `json:"scsi,omitempty"`
+	// IOThreads configures the IOThreads of the virtio disks and controllers
+	IOThreads *IOThreadsConfig `json:"ioThreads,omitempty"`
+	// CPU configures the CPU topology and the CPU pinning
+	CPU *CPUConfig `json:"cpu,omitempty"`
+	// NUMA configures the guest NUMA topology
+	NUMA *NUMAConfig `json:"numa,omitempty"`
+	// Memory configures the memory backing
+	Memory *MemoryConfig `json:"memory,omitempty"`
+	// Firmware selects the VM firmware
+	Firmware *FirmwareConfig `json:"firmware,omitempty"`
+	// SMBIOS fills the SMBIOS tables the guest sees
+	SMBIOS *SMBIOSConfig `json:"smbios,omitempty"`
+	// Display configures the graphics and the video devices
+	Display *DisplayConfig `json:"display,omitempty"`
+	// Channels configures the virtio-serial channels to the guest agents
+	Channels *ChannelsConfig `json:"channels,omitempty"`
+	// Volumes configures how the volumes are exposed to the guest
+	Volumes *VolumesConfig `json:"volumes,omitempty"`
+	// Disks tunes the disk drivers and throttles the disks
+	Disks *DisksConfig `json:"disks,omitempty"`
+	// RNG adds a virtio-rng device
+	RNG *RNGConfig `json:"rng,omitempty"`
+	// Balloon configures the memory balloon
+	Balloon *BalloonConfig `json:"balloon,omitempty"`
+	// Inputs configures the input devices
+	Inputs *InputsConfig `json:"inputs,omitempty"`
+	// Clock sets the clock offset of the VMs whose spec has none
+	Clock *ClockConfig `json:"clock,omitempty"`
+	// HyperV enables the Hyper-V enlightenments the VM spec leaves unset
+	HyperV *HyperVConfig `json:"hyperv,omitempty"`
+	// Selector picks the VMs the catalogue applies the profile to, by label, like the presets do.
+	// Profiles without selector are used only when asked by name.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// DNSConfig describes the DNS settings the translated VMs should use
+type DNSConfig struct {
+	// Nameservers is the list of the DNS servers. Only the first is used by slirp networks.
+	Nameservers []string `json:"nameservers,omitempty"`
+	// SearchDomains is the list of the DNS search domains
+	SearchDomains []string `json:"searchDomains,omitempty"`
+	// FromResolvConf makes the translator read the settings from the resolv.conf of the host
+	// running the profiler. Meant only for tools which translate and run the VMs on the same host.
+	FromResolvConf bool `json:"fromResolvConf,omitempty"`
+}
+
+// NetworkConfig describes how the VM interfaces are connected to the host networks
+type NetworkConfig struct {
+	// Model is the NIC model used when the interface has none; virtio if empty
+	Model string `json:"model,omitempty"`
+	// Queues is the number of queues of the virtio interfaces; libvirt decides if zero
+	Queues uint `json:"queues,omitempty"`
+	// MTU is the MTU of the interfaces; libvirt decides if zero
+	MTU uint `json:"mtu,omitempty"`
+	// PodBridge is the host bridge the pod network is connected to; DefaultBridgeName if empty
+	PodBridge string `json:"podBridge,omitempty"`
+	// Attachments describe the host side of the Multus networks, by network name.
+	// Multus networks without an attachment are connected to the bridge with the same name.
+	Attachments map[string]NetworkAttachment `json:"attachments,omitempty"`
+}
+
+// NetworkAttachment describes the host side of a network
+type NetworkAttachment struct {
+	// Type is the binding type: bridge, macvtap, vhostuser or sriov
+	Type string `json:"type"`
+	// Bridge is the name of the host bridge (bridge)
+	Bridge string `json:"bridge,omitempty"`
+	// Device is the host network device (macvtap)
+	Device string `json:"device,omitempty"`
+	// Mode is the macvtap mode, "bridge" if empty, or the vhost-user socket mode, "client" if empty
+	Mode string `json:"mode,omitempty"`
+	// Socket is the path of the vhost-user socket; taken from the PathLayout if empty (vhostuser)
+	Socket string `json:"socket,omitempty"`
+	// Address is the PCI address of the VF to assign (sriov)
+	Address string `json:"address,omitempty"`
+	// ResourceName selects a free VF among the host devices with this resource name, when no Address is given (sriov)
+	ResourceName string `json:"resourceName,omitempty"`
+	// Model, Queues and MTU override the NetworkConfig defaults for this network
+	Model  string `json:"model,omitempty"`
+	Queues uint   `json:"queues,omitempty"`
+	MTU    uint   `json:"mtu,omitempty"`
+}
+
+// HostDevicesConfig configures the PCI devices passed through to the VMs.
+// Devices requested as extended resources are taken from the Host PCIDevices.
+type HostDevicesConfig struct {
+	// Devices lists more devices to pass through
+	Devices []HostDeviceRequest `json:"devices,omitempty"`
+	// Defaults are applied by Complete to all the passed through devices, SR-IOV interfaces included
+	Defaults *HostDeviceSettings `json:"defaults,omitempty"`
+	// Resources override the Defaults for the devices with the given resource name
+	Resources map[string]HostDeviceSettings `json:"resources,omitempty"`
+}
+
+// HostDeviceRequest selects a host device, by address or by resource name
+type HostDeviceRequest struct {
+	Name         string `json:"name,omitempty"`
+	Address      string `json:"address,omitempty"`
+	ResourceName string `json:"resourceName,omitempty"`
+}
+
+// HostDeviceSettings tunes how a host device is passed through
+type HostDeviceSettings struct {
+	// Managed makes libvirt detach the device from the host driver before the VM starts
+	Managed *bool `json:"managed,omitempty"`
+	// Driver is the passthrough backend, like "vfio"
+	Driver string `json:"driver,omitempty"`
+	// ROMBar enables ("on") or disables ("off") the device ROM BAR
+	ROMBar string `json:"romBar,omitempty"`
+	// ROMFile is the ROM image to present to the guest instead of the device one
+	ROMFile string `json:"romFile,omitempty"`
+}
+
+// PCIConfig configures how Complete lays out the guest PCI topology
+type PCIConfig struct {
+	// HotplugPorts is the number of spare pcie-root-ports added for hotplug (q35 only)
+	HotplugPorts uint `json:"hotplugPorts,omitempty"`
+	// Disabled leaves the addresses to libvirt
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// SCSIConfig configures the controllers of the SCSI disks
+type SCSIConfig struct {
+	// Model is the controller model; DefaultSCSIModel if empty
+	Model string `json:"model,omitempty"`
+	// DisksPerController spreads the disks on more controllers; all the disks go on one controller if zero
+	DisksPerController uint `json:"disksPerController,omitempty"`
+	// Queues is the number of request queues of each controller; libvirt decides if zero
+	Queues uint `json:"queues,omitempty"`
+	// IOThreadPerController gives each controller its own IOThread
+	IOThreadPerController bool `json:"ioThreadPerController,omitempty"`
+}
+
+// IOThreadsConfig configures the IOThreads of the virtio disks and of the virtio-scsi controllers
+type IOThreadsConfig struct {
+	// Count is the number of IOThreads, besides the ones of the SCSI controllers
+	Count uint `json:"count,omitempty"`
+	// DisksPerIOThread allocates one IOThread each this many devices, when Count is zero
+	DisksPerIOThread uint `json:"disksPerIOThread,omitempty"`
+	// Assignments binds devices, by name, to IOThreads, by id starting from 1.
+	// The other devices are assigned round-robin.
+	Assignments map[string]uint `json:"assignments,omitempty"`
+	// CPUSet pins all the IOThreads not listed in Pins
+	CPUSet string `json:"cpuSet,omitempty"`
+	// Pins pins single IOThreads
+	Pins []IOThreadPin `json:"pins,omitempty"`
+}
+
+// IOThreadPin pins an IOThread to a set of host CPUs
+type IOThreadPin struct {
+	IOThread uint   `json:"ioThread"`
+	CPUSet   string `json:"cpuSet"`
+}
+
+// CPUConfig configures the CPU topology and the CPU pinning.
+// The topology splits the vCPUs of the spec; if the spec has none, the vCPUs are sockets*cores*threads.
+type CPUConfig struct {
+	Sockets uint `json:"sockets,omitempty"`
+	Cores   uint `json:"cores,omitempty"`
+	Threads uint `json:"threads,omitempty"`
+	// Dedicated pins each vCPU on its own CPU of the Host CPUSet
+	Dedicated bool `json:"dedicated,omitempty"`
+	// EmulatorCPUSet pins the emulator and the IOThreads not pinned otherwise;
+	// defaults to the host CPUs left by the vCPUs (dedicated only)
+	EmulatorCPUSet string `json:"emulatorCPUSet,omitempty"`
+	// Realtime makes the vCPUs use a realtime scheduler
+	Realtime *RealtimeConfig `json:"realtime,omitempty"`
+}
+
+// RealtimeConfig configures the realtime scheduling of the vCPUs
+type RealtimeConfig struct {
+	// VCPUs are the realtime vCPUs, all if empty
+	VCPUs string `json:"vcpus,omitempty"`
+	// Scheduler is "fifo" (default) or "rr"
+	Scheduler string `json:"scheduler,omitempty"`
+	// Priority is the realtime priority, 1 if zero
+	Priority uint `json:"priority,omitempty"`
+}
+
+// NUMAConfig configures the guest NUMA topology, built by Complete
+type NUMAConfig struct {
+	// Cells is the number of guest NUMA cells; one per host node if zero
+	Cells uint `json:"cells,omitempty"`
+	// HostNodes are the host nodes the cells are bound to, round-robin; all the nodes
+	// in the Host capabilities if empty. Pinned vCPUs bind their cell to the node they run on.
+	HostNodes []uint `json:"hostNodes,omitempty"`
+	// Mode is the memory binding mode: strict (default), preferred or interleave
+	Mode string `json:"mode,omitempty"`
+}
+
+// MemoryConfig configures the memory backing
+type MemoryConfig struct {
+	// Locked keeps the guest memory from being swapped out
+	Locked bool `json:"locked,omitempty"`
+	// NoSharePages keeps KSM from merging the guest memory pages
+	NoSharePages bool `json:"noSharePages,omitempty"`
+	// Source is the memory source: anonymous, file or memfd
+	Source string `json:"source,omitempty"`
+	// Allocation is the allocation mode: immediate or ondemand
+	Allocation string `json:"allocation,omitempty"`
+	// HugepageNodes sets the page size of some guest NUMA nodes, when the VM uses hugepages
+	HugepageNodes []HugepageNodeConfig `json:"hugepageNodes,omitempty"`
+}
+
+// HugepageNodeConfig sets the hugepage size of a set of guest NUMA nodes
+type HugepageNodeConfig struct {
+	// Nodeset are the guest NUMA nodes, like "0-1"
+	Nodeset string `json:"nodeset"`
+	// PageSize is the page size, like "1Gi"
+	PageSize string `json:"pageSize"`
+}
+
+// FirmwareConfig selects the VM firmware
+type FirmwareConfig struct {
+	// Type is the firmware type: bios (default) or efi
+	Type string `json:"type,omitempty"`
+	// SecureBoot enables the UEFI secure boot; needs SMM, so q35 machines
+	SecureBoot bool `json:"secureBoot,omitempty"`
+}
+
+// SMBIOSConfig holds the SMBIOS entries, by section and by libvirt entry name, like "manufacturer".
+// Values are text/templates, expanded with .Name, .Namespace and .UUID of the VM, like "{{.Name}}-serial".
+type SMBIOSConfig struct {
+	// BIOS entries: vendor, version, date, release
+	BIOS map[string]string `json:"bios,omitempty"`
+	// System entries: manufacturer, product, version, serial, sku, family
+	System map[string]string `json:"system,omitempty"`
+	// BaseBoard entries: manufacturer, product, version, serial, asset, location
+	BaseBoard map[string]string `json:"baseBoard,omitempty"`
+	// Chassis entries: manufacturer, version, serial, asset, sku
+	Chassis map[string]string `json:"chassis,omitempty"`
+	// OEMStrings are the type 11 OEM strings
+	OEMStrings []string `json:"oemStrings,omitempty"`
+}
+
+// DisplayConfig describes how the VMs are displayed
+type DisplayConfig struct {
+	// Autoattach tells if the graphics and video devices are added to the VMs whose spec doesn't tell
+	Autoattach *bool `json:"autoattach,omitempty"`
+	// Headless drops the graphics and the video devices
+	Headless bool `json:"headless,omitempty"`
+	// Graphics is the graphics protocol: vnc (default) or spice
+	Graphics string `json:"graphics,omitempty"`
+	// Spice configures the SPICE server
+	Spice *SpiceConfig `json:"spice,omitempty"`
+	// Video configures the video device
+	Video *VideoConfig `json:"video,omitempty"`
+}
+
+// SpiceConfig holds the SPICE server settings
+type SpiceConfig struct {
+	// Channels sets the mode of each channel, by channel name, like "main": any or insecure.
+	// The secure mode is rejected, because the SPICE server listens on a unix socket.
+	Channels map[string]string `json:"channels,omitempty"`
+	// ImageCompression is the image compression: auto_glz, auto_lz, quic, glz, lz or off
+	ImageCompression string `json:"imageCompression,omitempty"`
+	// StreamingMode is the video streaming mode: filter, all or off
+	StreamingMode string `json:"streamingMode,omitempty"`
+}
+
+// VideoConfig describes the video device
+type VideoConfig struct {
+	// Model is the video device: vga (default), virtio, qxl, bochs, cirrus or none
+	Model string `json:"model,omitempty"`
+	// Heads is the number of displays; only virtio and qxl have more than one
+	Heads uint `json:"heads,omitempty"`
+	// VRAM is the video memory size, like "16Mi"; virtio devices have none
+	VRAM string `json:"vram,omitempty"`
+}
+
+// ChannelsConfig describes the virtio-serial channels of the VMs
+type ChannelsConfig struct {
+	// DisableGuestAgent removes the QEMU guest agent channel, which every VM has otherwise
+	DisableGuestAgent bool `json:"disableGuestAgent,omitempty"`
+	// SpiceAgent adds the SPICE vdagent channel; needs SPICE graphics
+	SpiceAgent bool `json:"spiceAgent,omitempty"`
+	// Custom are the names of more channels, like "org.example.agent.0", each on its own unix socket
+	Custom []string `json:"custom,omitempty"`
+}
+
+// VolumesConfig describes how the volumes are exposed to the guest
+type VolumesConfig struct {
+	// ConfigVolumes tells how the ConfigMap, Secret and ServiceAccount volumes are exposed: as ISO images
+	// (iso, the default) or as shared directories (virtiofs), tagged with the volume name
+	ConfigVolumes string `json:"configVolumes,omitempty"`
+}
+
+// DisksConfig holds the disk tuning, by scope. The settings for a disk bus override the defaults,
+// the ones for a volume source override the bus ones, and the ones for a disk name override all.
+type DisksConfig struct {
+	// Defaults apply to all the disks
+	Defaults *DiskTuning `json:"defaults,omitempty"`
+	// Buses holds the tuning by disk bus, like "virtio"
+	Buses map[string]DiskTuning `json:"buses,omitempty"`
+	// Sources holds the tuning by volume source, as named in the VMI spec, like "persistentVolumeClaim"
+	Sources map[string]DiskTuning `json:"sources,omitempty"`
+	// Disks holds the tuning by disk name
+	Disks map[string]DiskTuning `json:"disks,omitempty"`
+}
+
+// DiskTuning holds the disk driver settings; the empty ones are left to libvirt
+type DiskTuning struct {
+	// Cache is the cache mode: default, none, writethrough, writeback, directsync or unsafe
+	Cache string `json:"cache,omitempty"`
+	// IO is the I/O mode: native, threads or io_uring; native needs the none or directsync cache
+	IO string `json:"io,omitempty"`
+	// Discard tells if the discard requests are passed down (unmap) or not (ignore)
+	Discard string `json:"discard,omitempty"`
+	// DetectZeroes is off, on or unmap; unmap needs the unmap discard
+	DetectZeroes string `json:"detectZeroes,omitempty"`
+	// ErrorPolicy is what to do on I/O errors: stop, report, ignore or enospace
+	ErrorPolicy string `json:"errorPolicy,omitempty"`
+	// CopyOnRead copies the backing image data read into the image
+	CopyOnRead *bool `json:"copyOnRead,omitempty"`
+	// IOTune throttles the disk
+	IOTune *DiskIOTune `json:"ioTune,omitempty"`
+}
+
+// DiskIOTune holds the disk throttling limits; the total limits exclude the read and write ones
+type DiskIOTune struct {
+	TotalBytesSec uint64 `json:"totalBytesSec,omitempty"`
+	ReadBytesSec  uint64 `json:"readBytesSec,omitempty"`
+	WriteBytesSec uint64 `json:"writeBytesSec,omitempty"`
+	TotalIOPSSec  uint64 `json:"totalIOPSSec,omitempty"`
+	ReadIOPSSec   uint64 `json:"readIOPSSec,omitempty"`
+	WriteIOPSSec  uint64 `json:"writeIOPSSec,omitempty"`
+}
+
+// RNGConfig describes the virtio-rng device
+type RNGConfig struct {
+	// Backend is the host device feeding the guest, /dev/urandom by default
+	Backend string `json:"backend,omitempty"`
+	// RateBytes limits the bytes the guest can read in each period
+	RateBytes uint `json:"rateBytes,omitempty"`
+	// RatePeriod is the rate limit period, in milliseconds; libvirt defaults to one second
+	RatePeriod uint `json:"ratePeriod,omitempty"`
+}
+
+// BalloonConfig describes the memory balloon
+type BalloonConfig struct {
+	// Model is the balloon model: virtio (default) or none, to have no balloon
+	Model string `json:"model,omitempty"`
+	// Autodeflate lets the guest deflate the balloon when it runs out of memory
+	Autodeflate *bool `json:"autodeflate,omitempty"`
+	// StatsPeriod is the memory statistics period, in seconds
+	StatsPeriod uint `json:"statsPeriod,omitempty"`
+}
+
+// InputsConfig describes the input devices
+type InputsConfig struct {
+	// Devices are the input devices to add
+	Devices []InputDevice `json:"devices,omitempty"`
+	// DisableUSB drops the default USB controller
+	DisableUSB bool `json:"disableUSB,omitempty"`
+}
+
+// InputDevice is an input device
+type InputDevice struct {
+	// Type is the device type: tablet, keyboard or mouse
+	Type string `json:"type"`
+	// Bus is the device bus: usb (default) or virtio
+	Bus string `json:"bus,omitempty"`
+}
+
+// ClockConfig describes the guest clock offset
+type ClockConfig struct {
+	// Offset is the clock offset: utc, localtime, timezone or variable
+	Offset string `json:"offset"`
+	// Basis is the base of the variable offset: utc or localtime
+	Basis string `json:"basis,omitempty"`
+	// Adjustment is the offset from the basis, in seconds
+	Adjustment *int64 `json:"adjustment,omitempty"`
+	// Timezone is the timezone name of the timezone offset, like "Europe/Rome"
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// HyperVConfig holds the Hyper-V enlightenments; unset ones are left as the VM spec has them
+type HyperVConfig struct {
+	Relaxed    *bool `json:"relaxed,omitempty"`
+	VAPIC      *bool `json:"vapic,omitempty"`
+	VPIndex    *bool `json:"vpindex,omitempty"`
+	Runtime    *bool `json:"runtime,omitempty"`
+	SyNIC      *bool `json:"synic,omitempty"`
+	SyNICTimer *bool `json:"synictimer,omitempty"`
+	Reset      *bool `json:"reset,omitempty"`
+	// Frequencies exposes the TSC and APIC frequencies
+	Frequencies *bool `json:"frequencies,omitempty"`
+	// Reenlightenment notifies the guest of the TSC frequency changes after a migration
+	Reenlightenment *bool `json:"reenlightenment,omitempty"`
+	// TLBFlush and IPI let the guest ask the hypervisor to flush the TLBs and send IPIs; both need VPIndex
+	TLBFlush *bool `json:"tlbflush,omitempty"`
+	IPI      *bool `json:"ipi,omitempty"`
+	// EVMCS enables the enlightened VMCS for nested guests; needs VAPIC
+	EVMCS *bool `json:"evmcs,omitempty"`
+	// SpinlockRetries enables the spinlock enlightenment, with the given retries (at least 4095)
+	SpinlockRetries *uint32 `json:"spinlockRetries,omitempty"`
+	// VendorID sets the hypervisor vendor id, up to 12 characters
+	VendorID string `json:"vendorId,omitempty"`
+	// Clock enables the hypervclock timer, needed by SyNICTimer
+	Clock *bool `json:"clock,omitempty"`
+}
+
+// AddProfile adds a profile to the ones used by the profiler. Profiles are applied in order.
+func (p *Profiler) AddProfile(prof *Profile) *Profiler {
+	p.profiles = append(p.profiles, prof)
+	return p
+}
+
+// effectiveProfile merges all the profiles in use, later sections replacing earlier ones.
+func (p *Profiler) effectiveProfile() *ProfileSpec {
+	return mergeProfileSpecs(p.profiles)
+}
+
+func mergeProfileSpecs(profiles []*Profile) *ProfileSpec {
+	ret := &ProfileSpec{}
+	dst := reflect.ValueOf(ret).Elem()
+	for _, prof := range profiles {
+		if prof == nil {
+			continue
+		}
+		src := reflect.ValueOf(&prof.Spec).Elem()
+		for i := 0; i < src.NumField(); i++ {
+			if !src.Field(i).IsNil() {
+				dst.Field(i).Set(src.Field(i))
+			}
+		}
+	}
+	return ret
+}
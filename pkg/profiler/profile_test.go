@@ -0,0 +1,196 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestMergeProfileSpecs(t *testing.T) {
+	first := &DNSConfig{Nameservers: []string{"10.0.0.1"}}
+	second := &DNSConfig{SearchDomains: []string{"example.com"}}
+	tests := []struct {
+		name     string
+		profiles []*Profile
+		want     *ProfileSpec
+	}{
+		{
+			name: "no profiles",
+			want: &ProfileSpec{},
+		},
+		{
+			name: "later sections replace the earlier ones",
+			profiles: []*Profile{
+				{Spec: ProfileSpec{DNS: first}},
+				{Spec: ProfileSpec{DNS: second}},
+			},
+			want: &ProfileSpec{DNS: second},
+		},
+		{
+			name: "missing sections are kept",
+			profiles: []*Profile{
+				{Spec: ProfileSpec{DNS: first}},
+				nil,
+				{Spec: ProfileSpec{}},
+			},
+			want: &ProfileSpec{DNS: first},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeProfileSpecs(tt.profiles); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunDNS(t *testing.T) {
+	vmi := newTestVMI()
+	vmi.Spec.Domain.Devices.Interfaces = []k6tv1.Interface{
+		{
+			Name:  "default",
+			Model: "e1000",
+			InterfaceBindingMethod: k6tv1.InterfaceBindingMethod{
+				Slirp: &k6tv1.InterfaceSlirp{},
+			},
+		},
+	}
+	vmi.Spec.Networks = []k6tv1.Network{
+		{
+			Name:          "default",
+			NetworkSource: k6tv1.NetworkSource{Pod: &k6tv1.PodNetwork{}},
+		},
+	}
+	tests := []struct {
+		name       string
+		profileDNS *DNSConfig
+		requestDNS *DNSConfig
+		want       string
+	}{
+		{
+			name:       "from the profiles",
+			profileDNS: &DNSConfig{Nameservers: []string{"10.0.0.1"}},
+			want:       ",dns=10.0.0.1",
+		},
+		{
+			name:       "the request overrides the profiles",
+			profileDNS: &DNSConfig{Nameservers: []string{"10.0.0.1"}},
+			requestDNS: &DNSConfig{Nameservers: []string{"10.0.0.2"}},
+			want:       ",dns=10.0.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProfiler()
+			p.AddProfile(&Profile{Spec: ProfileSpec{DNS: tt.profileDNS}})
+			res, err := p.Run(context.Background(), vmi, &Request{DNS: tt.requestDNS, StopAfter: StageTranslate})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			args := res.Translated.QEMUCommandline.Args
+			if netdev := args[len(args)-1].Value; !strings.Contains(netdev, tt.want) {
+				t.Errorf("got netdev %s, want %s", netdev, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigDNS(t *testing.T) {
+	tests := []struct {
+		name        string
+		dns         *DNSConfig
+		want        string
+		wantWarning bool
+		wantErr     bool
+	}{
+		{
+			name: "no DNS settings",
+			want: "user,id=default",
+		},
+		{
+			name: "nameserver and search domains",
+			dns: &DNSConfig{
+				Nameservers:   []string{"10.0.0.1"},
+				SearchDomains: []string{"example.com", "example.org"},
+			},
+			want: "user,id=default,dns=10.0.0.1,dnssearch=example.com,dnssearch=example.org",
+		},
+		{
+			name:        "only the first nameserver is used",
+			dns:         &DNSConfig{Nameservers: []string{"10.0.0.1", "10.0.0.2"}},
+			want:        "user,id=default,dns=10.0.0.1",
+			wantWarning: true,
+		},
+		{
+			name:    "invalid nameserver",
+			dns:     &DNSConfig{Nameservers: []string{"ns.example.com"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{DNS: tt.dns})
+			arg := &libvirtxml.DomainQEMUCommandlineArg{Value: "user,id=default"}
+			err := configDNS(arg, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if arg.Value != tt.want {
+				t.Errorf("got %s, want %s", arg.Value, tt.want)
+			}
+			if got := containsWarning(c.Warnings, "only one nameserver"); got != tt.wantWarning {
+				t.Errorf("got warnings %v, want the nameserver warning: %v", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestRunRequestProfilesDoNotLeak(t *testing.T) {
+	vmi := newTestVMI()
+	p := newTestProfiler()
+	req := &Request{
+		Profiles:  []*Profile{{Spec: ProfileSpec{DNS: &DNSConfig{SearchDomains: []string{"example.com"}}}}},
+		DNS:       &DNSConfig{Nameservers: []string{"10.0.0.1"}},
+		StopAfter: StageTranslate,
+	}
+	_, err := p.Run(context.Background(), vmi, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.profiles) != 0 {
+		t.Errorf("the request profiles leaked in the profiler: %v", p.profiles)
+	}
+	if spec := p.effectiveProfile(); spec.DNS != nil {
+		t.Errorf("the request DNS settings leaked in the profiler: %+v", spec.DNS)
+	}
+}
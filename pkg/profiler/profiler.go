@@ -25,9 +25,15 @@ const priorityMarking = "virtualmachineinstancepresets.admission.kubevirt.io/pri
 
 type Profiler struct {
 	secrets           map[string]*k8sv1.Secret
+	claims            map[string]*k8sv1.PersistentVolumeClaim
+	volumes           map[string]*k8sv1.PersistentVolume
 	virtualMachine    *k6tv1.VirtualMachineInstance
 	baseDiskPath      string
 	sortingAnnotation string
+	useEmulation      bool
+	pathLayout        *PathLayout
+	host              *Host
+	profiles          []*Profile
 }
 
 func (p *Profiler) AddSecret(key string, value *k8sv1.Secret) *Profiler {
@@ -35,13 +41,25 @@ func (p *Profiler) AddSecret(key string, value *k8sv1.Secret) *Profiler {
 	return p
 }
 
+// AddPersistentVolumeClaim registers a claim used by the VMs, to tell the block mode volumes
+func (p *Profiler) AddPersistentVolumeClaim(key string, value *k8sv1.PersistentVolumeClaim) *Profiler {
+	p.claims[key] = value
+	return p
+}
+
+// AddPersistentVolume registers a volume bound to a claim of the VMs, to reach the iSCSI volumes directly
+func (p *Profiler) AddPersistentVolume(key string, value *k8sv1.PersistentVolume) *Profiler {
+	p.volumes[key] = value
+	return p
+}
+
 func (p *Profiler) SetVirtualMachine(vm *k6tv1.VirtualMachineInstance) *Profiler {
 	p.virtualMachine = vm
 	return p
 }
 
 func (p *Profiler) SetPriorityMarking(marking string) *Profiler {
-	p.sortingAnnotation = priorityMarking
+	p.sortingAnnotation = marking
 	return p
 }
 
@@ -50,6 +68,24 @@ func (p *Profiler) SetBaseDiskPath(path string) *Profiler {
 	return p
 }
 
+func (p *Profiler) SetUseEmulation(useEmulation bool) *Profiler {
+	p.useEmulation = useEmulation
+	return p
+}
+
+// SetPathLayout sets the layout of the files backing the VMs, rooted in BaseDiskPath
+func (p *Profiler) SetPathLayout(layout *PathLayout) *Profiler {
+	p.pathLayout = layout
+	return p
+}
+
+// SetHost sets the description of the host on which the VMs will run.
+// Without it the translation warns and assumes a DefaultHost.
+func (p *Profiler) SetHost(host *Host) *Profiler {
+	p.host = host
+	return p
+}
+
 func (p *Profiler) BaseDiskPath() string {
 	return p.baseDiskPath
 }
@@ -57,7 +93,10 @@ func (p *Profiler) BaseDiskPath() string {
 func NewProfiler(basePath string) *Profiler {
 	return &Profiler{
 		secrets:           make(map[string]*k8sv1.Secret),
+		claims:            make(map[string]*k8sv1.PersistentVolumeClaim),
+		volumes:           make(map[string]*k8sv1.PersistentVolume),
 		baseDiskPath:      basePath,
 		sortingAnnotation: priorityMarking,
+		pathLayout:        KubeVirtPathLayout(),
 	}
 }
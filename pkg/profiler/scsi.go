@@ -0,0 +1,117 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+const DefaultSCSIModel = "virtio-scsi"
+
+// maxDisksPerSCSIController is the number of targets of each SCSI controller model, as emulated by QEMU.
+// Each disk takes a target; virtio-scsi controllers have 256 targets, one is kept for hotplug.
+var maxDisksPerSCSIController = map[string]uint{
+	DefaultSCSIModel:          255,
+	"virtio-transitional":     255,
+	"virtio-non-transitional": 255,
+	"lsilogic":                7,
+	"lsisas1068":              8,
+	"vmpvscsi":                64,
+}
+
+// convert_api_SCSIDisks_To_api_Controllers creates the SCSI controllers the SCSI disks need,
+// and gives each disk a deterministic controller/bus/target/unit address, in disk order.
+func convert_api_SCSIDisks_To_api_Controllers(domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.SCSI
+	if conf == nil {
+		conf = &SCSIConfig{}
+	}
+	model := conf.Model
+	if model == "" {
+		model = DefaultSCSIModel
+	}
+	maxDisks, ok := maxDisksPerSCSIController[model]
+	if !ok {
+		return &TranslationError{Field: "scsi.model", Value: model, Reason: "unsupported SCSI controller model"}
+	}
+	perController := conf.DisksPerController
+	if perController == 0 || perController > maxDisks {
+		perController = maxDisks
+	}
+	if conf.Queues > 0 && model != DefaultSCSIModel {
+		c.warn("Ignoring the SCSI queues, supported only by %s controllers", DefaultSCSIModel)
+	}
+	if conf.IOThreadPerController && model != DefaultSCSIModel {
+		c.warn("Ignoring the SCSI IOThreads, supported only by %s controllers", DefaultSCSIModel)
+	}
+
+	scsiDisks := 0
+	for i := range domain.Devices.Disks {
+		disk := &domain.Devices.Disks[i]
+		if disk.Target == nil || disk.Target.Bus != "scsi" {
+			continue
+		}
+		controller := uint(scsiDisks) / perController
+		target := uint(scsiDisks) % perController
+		bus := uint(0)
+		unit := uint(0)
+		disk.Address = &libvirtxml.DomainAddress{
+			Drive: &libvirtxml.DomainAddressDrive{
+				Controller: &controller,
+				Bus:        &bus,
+				Target:     &target,
+				Unit:       &unit,
+			},
+		}
+		scsiDisks++
+	}
+	if scsiDisks == 0 {
+		return nil
+	}
+
+	controllers := (uint(scsiDisks) + perController - 1) / perController
+	for index := uint(0); index < controllers; index++ {
+		idx := index
+		ctrl := libvirtxml.DomainController{
+			Type:  "scsi",
+			Index: &idx,
+			Model: model,
+			Alias: &libvirtxml.DomainAlias{
+				Name: fmt.Sprintf("scsi%d", idx),
+			},
+		}
+		if model == DefaultSCSIModel && (conf.Queues > 0 || conf.IOThreadPerController) {
+			ctrl.Driver = &libvirtxml.DomainControllerDriver{}
+			if conf.Queues > 0 {
+				queues := conf.Queues
+				ctrl.Driver.Queues = &queues
+			}
+			if conf.IOThreadPerController {
+				// IOThread ids start from 1
+				iothread := idx + 1
+				ctrl.Driver.IOThread = iothread
+				if domain.IOThreads < iothread {
+					domain.IOThreads = iothread
+				}
+			}
+		}
+		domain.Devices.Controllers = append(domain.Devices.Controllers, ctrl)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestConvertSCSIDisks(t *testing.T) {
+	tests := []struct {
+		name          string
+		virtioDisks   int
+		scsiDisks     int
+		conf          *SCSIConfig
+		wantModel     string
+		wantAddresses []string
+		// wantDrivers are the queues/iothread of each controller, empty if it has no driver
+		wantDrivers   []string
+		wantIOThreads uint
+		wantWarning   string
+		wantErr       bool
+	}{
+		{
+			name:          "no SCSI disks",
+			virtioDisks:   2,
+			wantAddresses: []string{},
+			wantDrivers:   []string{},
+		},
+		{
+			name:          "single controller",
+			virtioDisks:   1,
+			scsiDisks:     3,
+			wantModel:     DefaultSCSIModel,
+			wantAddresses: []string{"0:0:0:0", "0:0:1:0", "0:0:2:0"},
+			wantDrivers:   []string{""},
+		},
+		{
+			name:          "disks spread on more controllers",
+			scsiDisks:     3,
+			conf:          &SCSIConfig{DisksPerController: 2},
+			wantModel:     DefaultSCSIModel,
+			wantAddresses: []string{"0:0:0:0", "0:0:1:0", "1:0:0:0"},
+			wantDrivers:   []string{"", ""},
+		},
+		{
+			name:          "too many disks per controller",
+			scsiDisks:     1,
+			conf:          &SCSIConfig{DisksPerController: 1000},
+			wantModel:     DefaultSCSIModel,
+			wantAddresses: []string{"0:0:0:0"},
+			wantDrivers:   []string{""},
+		},
+		{
+			name:          "disks spread by the model limit",
+			scsiDisks:     9,
+			conf:          &SCSIConfig{Model: "lsilogic", DisksPerController: 100},
+			wantModel:     "lsilogic",
+			wantAddresses: []string{"0:0:0:0", "0:0:1:0", "0:0:2:0", "0:0:3:0", "0:0:4:0", "0:0:5:0", "0:0:6:0", "1:0:0:0", "1:0:1:0"},
+			wantDrivers:   []string{"", ""},
+		},
+		{
+			name:      "unsupported model",
+			scsiDisks: 1,
+			conf:      &SCSIConfig{Model: "buslogic"},
+			wantErr:   true,
+		},
+		{
+			name:          "queues and IOThreads",
+			scsiDisks:     2,
+			conf:          &SCSIConfig{DisksPerController: 1, Queues: 4, IOThreadPerController: true},
+			wantModel:     DefaultSCSIModel,
+			wantAddresses: []string{"0:0:0:0", "1:0:0:0"},
+			wantDrivers:   []string{"queues=4 iothread=1", "queues=4 iothread=2"},
+			wantIOThreads: 2,
+		},
+		{
+			name:          "queues ignored by other models",
+			scsiDisks:     1,
+			conf:          &SCSIConfig{Model: "lsilogic", Queues: 4},
+			wantModel:     "lsilogic",
+			wantAddresses: []string{"0:0:0:0"},
+			wantDrivers:   []string{""},
+			wantWarning:   "Ignoring the SCSI queues",
+		},
+		{
+			name:          "IOThreads ignored by other models",
+			scsiDisks:     1,
+			conf:          &SCSIConfig{Model: "lsilogic", IOThreadPerController: true},
+			wantModel:     "lsilogic",
+			wantAddresses: []string{"0:0:0:0"},
+			wantDrivers:   []string{""},
+			wantWarning:   "Ignoring the SCSI IOThreads",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{SCSI: tt.conf})
+			domain := newTestDomain("q35", tt.virtioDisks, tt.scsiDisks)
+			err := convert_api_SCSIDisks_To_api_Controllers(domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			addrs := []string{}
+			for _, disk := range domain.Devices.Disks {
+				if disk.Target.Bus != "scsi" {
+					if disk.Address != nil {
+						t.Errorf("disk %s: unexpected address", disk.Target.Dev)
+					}
+					continue
+				}
+				drive := disk.Address.Drive
+				addrs = append(addrs, fmt.Sprintf("%d:%d:%d:%d", *drive.Controller, *drive.Bus, *drive.Target, *drive.Unit))
+			}
+			if !reflect.DeepEqual(addrs, tt.wantAddresses) {
+				t.Errorf("got addresses %v, want %v", addrs, tt.wantAddresses)
+			}
+
+			drivers := []string{}
+			for i, ctrl := range domain.Devices.Controllers {
+				if ctrl.Model != tt.wantModel || *ctrl.Index != uint(i) || ctrl.Alias.Name != fmt.Sprintf("scsi%d", i) {
+					t.Errorf("got controller %s with index %d of model %s", ctrl.Alias.Name, *ctrl.Index, ctrl.Model)
+				}
+				driver := ""
+				if ctrl.Driver != nil {
+					driver = fmt.Sprintf("queues=%d iothread=%d", *ctrl.Driver.Queues, ctrl.Driver.IOThread)
+				}
+				drivers = append(drivers, driver)
+			}
+			if !reflect.DeepEqual(drivers, tt.wantDrivers) {
+				t.Errorf("got controller drivers %v, want %v", drivers, tt.wantDrivers)
+			}
+			if domain.IOThreads != tt.wantIOThreads {
+				t.Errorf("got %d IOThreads, want %d", domain.IOThreads, tt.wantIOThreads)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) != 0 {
+					t.Errorf("unexpected warnings: %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"net"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// EncryptionSecretAnnotationPrefix marks the VMI annotations which bind a volume to the
+	// Kubernetes Secret holding its LUKS passphrase, e.g. "virt-profiles/luks-secret.rootdisk: mysecret"
+	EncryptionSecretAnnotationPrefix = "virt-profiles/luks-secret."
+
+	iscsiUsernameKey    = "node.session.auth.username"
+	iscsiPasswordKey    = "node.session.auth.password"
+	luksPassphraseKey   = "passphrase"
+	defaultISCSIPortStr = "3260"
+)
+
+// Secret is a libvirt secret definition, together with the value to set once defined.
+type Secret struct {
+	Definition *libvirtxml.Secret
+	Value      []byte
+}
+
+// findSecret looks up a Secret registered with AddSecret, either by name or by namespace/name
+func (c *ConverterContext) findSecret(name string) (*k8sv1.Secret, error) {
+	if secret, ok := c.Secrets[name]; ok {
+		return secret, nil
+	}
+	key := fmt.Sprintf("%s/%s", c.VirtualMachine.Namespace, name)
+	if secret, ok := c.Secrets[key]; ok {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("secret %s not found", name)
+}
+
+func (c *ConverterContext) addSecret(secret Secret) {
+	for _, sec := range c.LibvirtSecrets {
+		if sec.Definition.Usage != nil && secret.Definition.Usage != nil && *sec.Definition.Usage == *secret.Definition.Usage {
+			return
+		}
+	}
+	c.LibvirtSecrets = append(c.LibvirtSecrets, secret)
+}
+
+// findISCSIVolume looks up a volume registered with AddPersistentVolume, backed by iSCSI and bound to the claim
+func (c *ConverterContext) findISCSIVolume(claimName string) (*k8sv1.PersistentVolume, bool) {
+	for _, pv := range c.Volumes {
+		ref := pv.Spec.ClaimRef
+		if pv.Spec.ISCSI == nil || ref == nil || ref.Name != claimName {
+			continue
+		}
+		if ref.Namespace == "" || ref.Namespace == c.VirtualMachine.Namespace {
+			return pv, true
+		}
+	}
+	return nil, false
+}
+
+// convert_v1_ISCSIPersistentVolumeSource_To_api_Disk builds a network disk, with CHAP authentication if the source references a Secret
+func convert_v1_ISCSIPersistentVolumeSource_To_api_Disk(source *k8sv1.ISCSIPersistentVolumeSource, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	host, port, err := net.SplitHostPort(source.TargetPortal)
+	if err != nil {
+		// the port is optional
+		host = source.TargetPortal
+		port = defaultISCSIPortStr
+	}
+
+	disk.Driver.Type = "raw"
+	disk.Source = &libvirtxml.DomainDiskSource{
+		Network: &libvirtxml.DomainDiskSourceNetwork{
+			Protocol: "iscsi",
+			Name:     fmt.Sprintf("%s/%d", source.IQN, source.Lun),
+			Hosts: []libvirtxml.DomainDiskSourceHost{
+				{
+					Name: host,
+					Port: port,
+				},
+			},
+		},
+	}
+
+	if source.SecretRef == nil {
+		return nil
+	}
+	secretName := source.SecretRef.Name
+	if source.SecretRef.Namespace != "" {
+		secretName = fmt.Sprintf("%s/%s", source.SecretRef.Namespace, source.SecretRef.Name)
+	}
+	secret, err := c.findSecret(secretName)
+	if err != nil {
+		return fmt.Errorf("disk %s: %v", disk.Alias.Name, err)
+	}
+	username, ok := secret.Data[iscsiUsernameKey]
+	if !ok {
+		return fmt.Errorf("disk %s: secret %s lacks %s", disk.Alias.Name, secret.Name, iscsiUsernameKey)
+	}
+	password, ok := secret.Data[iscsiPasswordKey]
+	if !ok {
+		return fmt.Errorf("disk %s: secret %s lacks %s", disk.Alias.Name, secret.Name, iscsiPasswordKey)
+	}
+
+	usage := secretToLibvirtSecret(c.VirtualMachine, secret.Name)
+	disk.Auth = &libvirtxml.DomainDiskAuth{
+		Username: string(username),
+		Secret: &libvirtxml.DomainDiskSecret{
+			Type:  "iscsi",
+			Usage: usage,
+		},
+	}
+	c.addSecret(Secret{
+		Definition: &libvirtxml.Secret{
+			Ephemeral:   "no",
+			Private:     "yes",
+			Description: fmt.Sprintf("CHAP secret %s of %s/%s", secret.Name, c.VirtualMachine.Namespace, c.VirtualMachine.Name),
+			Usage: &libvirtxml.SecretUsage{
+				Type:   "iscsi",
+				Target: usage,
+			},
+		},
+		Value: password,
+	})
+	return nil
+}
+
+// convert_v1_EncryptionSecret_To_api_Disk adds the LUKS encryption settings to the disk, if its volume is bound to a Secret
+func convert_v1_EncryptionSecret_To_api_Disk(volumeName string, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	secretName, ok := c.VirtualMachine.Annotations[EncryptionSecretAnnotationPrefix+volumeName]
+	if !ok {
+		return nil
+	}
+	if disk.Source == nil || disk.Source.File == nil {
+		return fmt.Errorf("disk %s: encryption is supported only on file based disks", disk.Alias.Name)
+	}
+	secret, err := c.findSecret(secretName)
+	if err != nil {
+		return fmt.Errorf("disk %s: %v", disk.Alias.Name, err)
+	}
+	passphrase, ok := secret.Data[luksPassphraseKey]
+	if !ok {
+		return fmt.Errorf("disk %s: secret %s lacks %s", disk.Alias.Name, secret.Name, luksPassphraseKey)
+	}
+
+	// libvirt requires the volume secrets to use the volume path as usage
+	usage := disk.Source.File.File
+	disk.Source.Encryption = &libvirtxml.DomainDiskEncryption{
+		Format: "luks",
+		Secret: &libvirtxml.DomainDiskSecret{
+			Type:  "passphrase",
+			Usage: usage,
+		},
+	}
+	c.addSecret(Secret{
+		Definition: &libvirtxml.Secret{
+			Ephemeral:   "no",
+			Private:     "yes",
+			Description: fmt.Sprintf("LUKS passphrase %s of %s/%s", secret.Name, c.VirtualMachine.Namespace, c.VirtualMachine.Name),
+			Usage: &libvirtxml.SecretUsage{
+				Type:   "volume",
+				Volume: usage,
+			},
+		},
+		Value: passphrase,
+	})
+	return nil
+}
@@ -0,0 +1,279 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+)
+
+func TestConvertISCSIPersistentVolumeSource(t *testing.T) {
+	chap := newTestSecret("chap", map[string]string{iscsiUsernameKey: "admin", iscsiPasswordKey: "secret"})
+	tests := []struct {
+		name      string
+		source    k8sv1.ISCSIPersistentVolumeSource
+		secrets   map[string]*k8sv1.Secret
+		wantHost  string
+		wantPort  string
+		wantAuth  bool
+		wantError bool
+	}{
+		{
+			name:     "portal with port",
+			source:   k8sv1.ISCSIPersistentVolumeSource{TargetPortal: "10.0.0.1:3261", IQN: "iqn.2018-01.com.example:data", Lun: 1},
+			wantHost: "10.0.0.1",
+			wantPort: "3261",
+		},
+		{
+			name:     "portal without port",
+			source:   k8sv1.ISCSIPersistentVolumeSource{TargetPortal: "10.0.0.1", IQN: "iqn.2018-01.com.example:data"},
+			wantHost: "10.0.0.1",
+			wantPort: "3260",
+		},
+		{
+			name: "CHAP secret",
+			source: k8sv1.ISCSIPersistentVolumeSource{
+				TargetPortal: "10.0.0.1",
+				IQN:          "iqn.2018-01.com.example:data",
+				SecretRef:    &k8sv1.SecretReference{Name: "chap"},
+			},
+			secrets:  map[string]*k8sv1.Secret{"chap": chap},
+			wantHost: "10.0.0.1",
+			wantPort: "3260",
+			wantAuth: true,
+		},
+		{
+			name: "CHAP secret registered with its namespace",
+			source: k8sv1.ISCSIPersistentVolumeSource{
+				TargetPortal: "10.0.0.1",
+				IQN:          "iqn.2018-01.com.example:data",
+				SecretRef:    &k8sv1.SecretReference{Name: "chap"},
+			},
+			secrets:  map[string]*k8sv1.Secret{"default/chap": chap},
+			wantHost: "10.0.0.1",
+			wantPort: "3260",
+			wantAuth: true,
+		},
+		{
+			name: "CHAP secret of another namespace",
+			source: k8sv1.ISCSIPersistentVolumeSource{
+				TargetPortal: "10.0.0.1",
+				IQN:          "iqn.2018-01.com.example:data",
+				SecretRef:    &k8sv1.SecretReference{Name: "chap", Namespace: "storage"},
+			},
+			secrets:  map[string]*k8sv1.Secret{"storage/chap": chap},
+			wantHost: "10.0.0.1",
+			wantPort: "3260",
+			wantAuth: true,
+		},
+		{
+			name: "missing secret",
+			source: k8sv1.ISCSIPersistentVolumeSource{
+				TargetPortal: "10.0.0.1",
+				SecretRef:    &k8sv1.SecretReference{Name: "chap"},
+			},
+			wantError: true,
+		},
+		{
+			name: "secret without password",
+			source: k8sv1.ISCSIPersistentVolumeSource{
+				TargetPortal: "10.0.0.1",
+				SecretRef:    &k8sv1.SecretReference{Name: "chap"},
+			},
+			secrets:   map[string]*k8sv1.Secret{"chap": newTestSecret("chap", map[string]string{iscsiUsernameKey: "admin"})},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			c.Secrets = tt.secrets
+			disk := newTestDisk("data", "disk", "")
+			err := convert_v1_ISCSIPersistentVolumeSource_To_api_Disk(&tt.source, disk, c)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			host := disk.Source.Network.Hosts[0]
+			if host.Name != tt.wantHost || host.Port != tt.wantPort {
+				t.Errorf("got host %s:%s, want %s:%s", host.Name, host.Port, tt.wantHost, tt.wantPort)
+			}
+			if !tt.wantAuth {
+				if disk.Auth != nil || len(c.LibvirtSecrets) != 0 {
+					t.Errorf("unexpected authentication settings")
+				}
+				return
+			}
+			if disk.Auth == nil || disk.Auth.Username != "admin" {
+				t.Fatalf("got authentication %+v, want the admin user", disk.Auth)
+			}
+			if len(c.LibvirtSecrets) != 1 {
+				t.Fatalf("got %d libvirt secrets, want 1", len(c.LibvirtSecrets))
+			}
+			secret := c.LibvirtSecrets[0]
+			if secret.Definition.Usage.Target != disk.Auth.Secret.Usage || string(secret.Value) != "secret" {
+				t.Errorf("got libvirt secret %+v with value %s", secret.Definition.Usage, secret.Value)
+			}
+		})
+	}
+}
+
+func TestConvertISCSIPersistentVolumeSourceSharedSecret(t *testing.T) {
+	c := newTestContext(nil)
+	c.Secrets = map[string]*k8sv1.Secret{
+		"chap": newTestSecret("chap", map[string]string{iscsiUsernameKey: "admin", iscsiPasswordKey: "secret"}),
+	}
+	for _, lun := range []int32{0, 1} {
+		source := &k8sv1.ISCSIPersistentVolumeSource{
+			TargetPortal: "10.0.0.1",
+			IQN:          "iqn.2018-01.com.example:data",
+			Lun:          lun,
+			SecretRef:    &k8sv1.SecretReference{Name: "chap"},
+		}
+		err := convert_v1_ISCSIPersistentVolumeSource_To_api_Disk(source, newTestDisk("data", "disk", ""), c)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(c.LibvirtSecrets) != 1 {
+		t.Errorf("got %d libvirt secrets, want the shared one only", len(c.LibvirtSecrets))
+	}
+}
+
+func TestFindISCSIVolume(t *testing.T) {
+	newVolume := func(namespace, claim string, iscsi bool) *k8sv1.PersistentVolume {
+		pv := &k8sv1.PersistentVolume{}
+		pv.Spec.ClaimRef = &k8sv1.ObjectReference{Namespace: namespace, Name: claim}
+		if iscsi {
+			pv.Spec.ISCSI = &k8sv1.ISCSIPersistentVolumeSource{TargetPortal: "10.0.0.1"}
+		}
+		return pv
+	}
+	tests := []struct {
+		name   string
+		volume *k8sv1.PersistentVolume
+		want   bool
+	}{
+		{"bound to the claim", newVolume("default", "data", true), true},
+		{"bound without namespace", newVolume("", "data", true), true},
+		{"bound to another claim", newVolume("default", "logs", true), false},
+		{"bound in another namespace", newVolume("storage", "data", true), false},
+		{"not iSCSI", newVolume("default", "data", false), false},
+		{"not bound", &k8sv1.PersistentVolume{Spec: k8sv1.PersistentVolumeSpec{
+			PersistentVolumeSource: k8sv1.PersistentVolumeSource{ISCSI: &k8sv1.ISCSIPersistentVolumeSource{}},
+		}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			c.Volumes = map[string]*k8sv1.PersistentVolume{"pv0": tt.volume}
+			pv, ok := c.findISCSIVolume("data")
+			if ok != tt.want || (ok && pv != tt.volume) {
+				t.Errorf("got volume %v (found %v), want found %v", pv, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertEncryptionSecret(t *testing.T) {
+	const path = testBaseDiskPath + "/default/testvmi/disks/data.img"
+	tests := []struct {
+		name           string
+		annotated      bool
+		source         *libvirtxml.DomainDiskSource
+		passphrase     string
+		wantEncryption bool
+		wantError      bool
+	}{
+		{
+			name:   "not annotated",
+			source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}},
+		},
+		{
+			name:           "file disk",
+			annotated:      true,
+			source:         &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}},
+			passphrase:     "passphrase",
+			wantEncryption: true,
+		},
+		{
+			name:       "block disk",
+			annotated:  true,
+			source:     &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: "/dev/data"}},
+			passphrase: "passphrase",
+			wantError:  true,
+		},
+		{
+			name:      "secret without passphrase",
+			annotated: true,
+			source:    &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: path}},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			data := map[string]string{}
+			if tt.passphrase != "" {
+				data[luksPassphraseKey] = tt.passphrase
+			}
+			c.Secrets = map[string]*k8sv1.Secret{"luks": newTestSecret("luks", data)}
+			if tt.annotated {
+				c.VirtualMachine.Annotations = map[string]string{EncryptionSecretAnnotationPrefix + "data": "luks"}
+			}
+			disk := newTestDisk("data", "disk", "")
+			disk.Source = tt.source
+
+			err := convert_v1_EncryptionSecret_To_api_Disk("data", disk, c)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantEncryption {
+				if disk.Source.Encryption != nil || len(c.LibvirtSecrets) != 0 {
+					t.Errorf("unexpected encryption settings")
+				}
+				return
+			}
+			encryption := disk.Source.Encryption
+			if encryption == nil || encryption.Format != "luks" || encryption.Secret.Usage != path {
+				t.Fatalf("got encryption %+v, want LUKS using the image path", encryption)
+			}
+			if len(c.LibvirtSecrets) != 1 || c.LibvirtSecrets[0].Definition.Usage.Volume != path {
+				t.Errorf("got libvirt secrets %+v, want the volume secret", c.LibvirtSecrets)
+			}
+			if string(c.LibvirtSecrets[0].Value) != tt.passphrase {
+				t.Errorf("got passphrase %s, want %s", c.LibvirtSecrets[0].Value, tt.passphrase)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"text/template"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// the entries libvirt accepts in each sysinfo section. The system UUID is not here
+// because it must match the domain UUID, which comes from the VM firmware.
+var smbiosEntries = map[string][]string{
+	"bios":      {"vendor", "version", "date", "release"},
+	"system":    {"manufacturer", "product", "version", "serial", "sku", "family"},
+	"baseBoard": {"manufacturer", "product", "version", "serial", "asset", "location"},
+	"chassis":   {"manufacturer", "version", "serial", "asset", "sku"},
+}
+
+type smbiosParams struct {
+	Name      string
+	Namespace string
+	UUID      string
+}
+
+// convert_api_SMBIOS_To_api_SysInfo fills the SMBIOS tables from the profile, and makes the guest see them.
+// Every value is a text/template, expanded with .Name, .Namespace and .UUID of the VM.
+func convert_api_SMBIOS_To_api_SysInfo(vmi *k6tv1.VirtualMachineInstance, domain *libvirtxml.Domain, c *ConverterContext) error {
+	conf := c.Profile.SMBIOS
+	if conf == nil {
+		return nil
+	}
+	params := smbiosParams{
+		Name:      vmi.Name,
+		Namespace: vmi.Namespace,
+	}
+	if vmi.Spec.Domain.Firmware != nil {
+		params.UUID = string(vmi.Spec.Domain.Firmware.UUID)
+	}
+
+	bios, err := smbiosSection("bios", conf.BIOS, params)
+	if err != nil {
+		return err
+	}
+	if len(bios) > 0 {
+		domain.SysInfo.BIOS = &libvirtxml.DomainSysInfoBIOS{Entry: bios}
+	}
+
+	system, err := smbiosSection("system", conf.System, params)
+	if err != nil {
+		return err
+	}
+	if len(system) > 0 {
+		if domain.SysInfo.System == nil {
+			domain.SysInfo.System = &libvirtxml.DomainSysInfoSystem{}
+		}
+		domain.SysInfo.System.Entry = append(domain.SysInfo.System.Entry, system...)
+	}
+
+	baseBoard, err := smbiosSection("baseBoard", conf.BaseBoard, params)
+	if err != nil {
+		return err
+	}
+	if len(baseBoard) > 0 {
+		domain.SysInfo.BaseBoard = []libvirtxml.DomainSysInfoBaseBoard{{Entry: baseBoard}}
+	}
+
+	chassis, err := smbiosSection("chassis", conf.Chassis, params)
+	if err != nil {
+		return err
+	}
+	if len(chassis) > 0 {
+		domain.SysInfo.Chassis = &libvirtxml.DomainSysInfoChassis{Entry: chassis}
+	}
+
+	if len(conf.OEMStrings) > 0 {
+		oem := &libvirtxml.DomainSysInfoOEMStrings{}
+		for _, value := range conf.OEMStrings {
+			expanded, err := expandSMBIOSValue("oemStrings", value, params)
+			if err != nil {
+				return err
+			}
+			oem.Entry = append(oem.Entry, expanded)
+		}
+		domain.SysInfo.OEMStrings = oem
+	}
+
+	domain.OS.SMBios = &libvirtxml.DomainSMBios{
+		Mode: "sysinfo",
+	}
+	return nil
+}
+
+// smbiosSection validates and expands the entries of a section, sorted by name
+func smbiosSection(section string, values map[string]string, params smbiosParams) ([]libvirtxml.DomainSysInfoEntry, error) {
+	names := []string{}
+	for name := range values {
+		if !isSMBIOSEntry(section, name) {
+			return nil, fmt.Errorf("smbios: unknown %s entry %s", section, name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := []libvirtxml.DomainSysInfoEntry{}
+	for _, name := range names {
+		value, err := expandSMBIOSValue(section+"."+name, values[name], params)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, libvirtxml.DomainSysInfoEntry{
+			Name:  name,
+			Value: value,
+		})
+	}
+	return entries, nil
+}
+
+func isSMBIOSEntry(section, name string) bool {
+	for _, entry := range smbiosEntries[section] {
+		if entry == name {
+			return true
+		}
+	}
+	return false
+}
+
+func expandSMBIOSValue(what, value string, params smbiosParams) (string, error) {
+	t, err := template.New(what).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("smbios: malformed template for %s: %v", what, err)
+	}
+	var buf bytes.Buffer
+	err = t.Execute(&buf, params)
+	if err != nil {
+		return "", fmt.Errorf("smbios: cannot expand template for %s: %v", what, err)
+	}
+	return buf.String(), nil
+}
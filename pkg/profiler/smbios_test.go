@@ -0,0 +1,156 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestConvertSMBIOS(t *testing.T) {
+	const uuid = "5d307ca9-b3ef-428c-8861-06e72d69f223"
+	tests := []struct {
+		name          string
+		conf          *SMBIOSConfig
+		wantSystem    []libvirtxml.DomainSysInfoEntry
+		wantBIOS      []libvirtxml.DomainSysInfoEntry
+		wantBaseBoard bool
+		wantChassis   bool
+		wantOEM       []string
+		wantErr       bool
+	}{
+		{
+			name:       "no SMBIOS profile",
+			wantSystem: []libvirtxml.DomainSysInfoEntry{{Name: "uuid", Value: uuid}},
+		},
+		{
+			name: "system entries after the UUID, sorted",
+			conf: &SMBIOSConfig{
+				System: map[string]string{
+					"serial":       "{{.Namespace}}-{{.Name}}",
+					"manufacturer": "Example",
+				},
+			},
+			wantSystem: []libvirtxml.DomainSysInfoEntry{
+				{Name: "uuid", Value: uuid},
+				{Name: "manufacturer", Value: "Example"},
+				{Name: "serial", Value: "default-testvmi"},
+			},
+		},
+		{
+			name: "all the sections",
+			conf: &SMBIOSConfig{
+				BIOS:       map[string]string{"vendor": "Example", "version": "1.0"},
+				BaseBoard:  map[string]string{"product": "board"},
+				Chassis:    map[string]string{"asset": "{{.UUID}}"},
+				OEMStrings: []string{"vm={{.Name}}", "static"},
+			},
+			wantSystem: []libvirtxml.DomainSysInfoEntry{{Name: "uuid", Value: uuid}},
+			wantBIOS: []libvirtxml.DomainSysInfoEntry{
+				{Name: "vendor", Value: "Example"},
+				{Name: "version", Value: "1.0"},
+			},
+			wantBaseBoard: true,
+			wantChassis:   true,
+			wantOEM:       []string{"vm=testvmi", "static"},
+		},
+		{
+			name:    "the UUID is not a profile entry",
+			conf:    &SMBIOSConfig{System: map[string]string{"uuid": uuid}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown entry",
+			conf:    &SMBIOSConfig{Chassis: map[string]string{"product": "chassis"}},
+			wantErr: true,
+		},
+		{
+			name:    "malformed template",
+			conf:    &SMBIOSConfig{System: map[string]string{"serial": "{{.Name"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown template field",
+			conf:    &SMBIOSConfig{OEMStrings: []string{"{{.Pod}}"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(&ProfileSpec{SMBIOS: tt.conf})
+			vmi := c.VirtualMachine
+			vmi.Spec.Domain.Firmware = &k6tv1.Firmware{UUID: uuid}
+			dom := &libvirtxml.Domain{
+				OS: &libvirtxml.DomainOS{},
+				SysInfo: &libvirtxml.DomainSysInfo{
+					Type: "smbios",
+					System: &libvirtxml.DomainSysInfoSystem{
+						Entry: []libvirtxml.DomainSysInfoEntry{{Name: "uuid", Value: uuid}},
+					},
+				},
+			}
+			err := convert_api_SMBIOS_To_api_SysInfo(vmi, dom, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sysInfo := dom.SysInfo
+			if !reflect.DeepEqual(sysInfo.System.Entry, tt.wantSystem) {
+				t.Errorf("got system entries %+v, want %+v", sysInfo.System.Entry, tt.wantSystem)
+			}
+			var bios []libvirtxml.DomainSysInfoEntry
+			if sysInfo.BIOS != nil {
+				bios = sysInfo.BIOS.Entry
+			}
+			if !reflect.DeepEqual(bios, tt.wantBIOS) {
+				t.Errorf("got BIOS entries %+v, want %+v", bios, tt.wantBIOS)
+			}
+			if got := len(sysInfo.BaseBoard) > 0; got != tt.wantBaseBoard {
+				t.Errorf("got base board: %v, want %v", got, tt.wantBaseBoard)
+			}
+			if tt.wantChassis {
+				if sysInfo.Chassis == nil || sysInfo.Chassis.Entry[0].Value != uuid {
+					t.Errorf("got chassis %+v, want the asset from the UUID", sysInfo.Chassis)
+				}
+			} else if sysInfo.Chassis != nil {
+				t.Errorf("unexpected chassis %+v", sysInfo.Chassis)
+			}
+			var oem []string
+			if sysInfo.OEMStrings != nil {
+				oem = sysInfo.OEMStrings.Entry
+			}
+			if !reflect.DeepEqual(oem, tt.wantOEM) {
+				t.Errorf("got OEM strings %v, want %v", oem, tt.wantOEM)
+			}
+
+			gotSMBIOS := dom.OS.SMBios != nil && dom.OS.SMBios.Mode == "sysinfo"
+			if gotSMBIOS != (tt.conf != nil) {
+				t.Errorf("got SMBIOS sysinfo mode: %v, want %v", gotSMBIOS, tt.conf != nil)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@
 package virtprofiles
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,13 +30,18 @@ import (
 
 // ApplyPresets applies all the given presets to the stage1 domain specification
 func (p *Profiler) ApplyPresets(domSpec *k6tv1.DomainSpec, presets []k6tv1.VirtualMachineInstancePreset) (*k6tv1.DomainSpec, []string, error) {
+	return p.applyPresets(context.Background(), domSpec, presets)
+}
+
+// applyPresets is ApplyPresets, stopping before the next preset once the context is done
+func (p *Profiler) applyPresets(ctx context.Context, domSpec *k6tv1.DomainSpec, presets []k6tv1.VirtualMachineInstancePreset) (*k6tv1.DomainSpec, []string, error) {
 	warnings := []string{}
 	ret, err := cloneDomainSpec(domSpec)
 	if err != nil {
 		return nil, warnings, err
 	}
 
-	domPresets, err := SortPresets(presets)
+	domPresets, err := p.SortPresets(presets)
 	if err != nil {
 		// sorting errors are not critical for this flow
 		warnings = append(warnings, fmt.Sprintf("%v", err))
@@ -48,6 +54,9 @@ func (p *Profiler) ApplyPresets(domSpec *k6tv1.DomainSpec, presets []k6tv1.Virtu
 	}
 
 	for _, preset := range domPresets {
+		if err := ctx.Err(); err != nil {
+			return nil, warnings, err
+		}
 		applied, err := mergeDomainSpec(ret, preset.Spec.Domain)
 		if err != nil {
 			msg := fmt.Sprintf("Unable to apply VirtualMachineInstancePreset '%s': %v", preset.Name, err)
@@ -61,7 +70,7 @@ func (p *Profiler) ApplyPresets(domSpec *k6tv1.DomainSpec, presets []k6tv1.Virtu
 	return ret, warnings, nil
 }
 
-func mergeDomainSpec(domSpec *k6tv1.DomainSpec, presetSpec *k6tv1.DomainPresetSpec) (bool, error) {
+func mergeDomainSpec(domSpec *k6tv1.DomainSpec, presetSpec *k6tv1.DomainSpec) (bool, error) {
 	presetConflicts := checkMergeConflicts(presetSpec, domSpec)
 	applied := false
 
@@ -168,7 +177,7 @@ func checkPresetConflicts(presets []k6tv1.VirtualMachineInstancePreset) error {
 	return nil
 }
 
-func checkMergeConflicts(presetSpec *k6tv1.DomainPresetSpec, vmiSpec *k6tv1.DomainSpec) error {
+func checkMergeConflicts(presetSpec *k6tv1.DomainSpec, vmiSpec *k6tv1.DomainSpec) error {
 	errors := []error{}
 
 	// resource request never conflicts: we pick the union of the requests, and the larger value among overlapping requests
@@ -17,30 +17,31 @@
 package virtprofiles
 
 import (
-	"errors"
+	"fmt"
 	"sort"
+	"strconv"
 
 	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
 )
 
 // sortPresets sorts and returns a slice of VirtualMachinePresets, using optional annotations.
 func (p *Profiler) SortPresets(presets []k6tv1.VirtualMachineInstancePreset) ([]k6tv1.VirtualMachineInstancePreset, error) {
-	err := checkAnnotations(presets)
+	err := checkAnnotations(presets, p.sortingAnnotation)
 	if err != nil {
 		return presets, err
 	}
-	sort.Stable(byPriority{Presets: presets, Annotation: p.Annotation})
+	sort.Stable(&byPriority{Presets: presets, Annotation: p.sortingAnnotation})
 	return presets, nil
 }
 
-func checkAnnotations(presets []k6tv1.VirtualMachineInstancePreset) error {
+func checkAnnotations(presets []k6tv1.VirtualMachineInstancePreset, annotation string) error {
 	for _, preset := range presets {
 		if preset.Annotations == nil {
-			return errors.New("preset %v lacks annotations", preset.Name)
+			return fmt.Errorf("preset %v lacks annotations", preset.Name)
 		}
-		_, ok := preset.Annotations[p.Annotation]
+		_, ok := preset.Annotations[annotation]
 		if !ok {
-			return errors.New("preset %v lacks priority annotation", preset.Name)
+			return fmt.Errorf("preset %v lacks priority annotation", preset.Name)
 		}
 	}
 	return nil
@@ -0,0 +1,206 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"testing"
+
+	k8sv1 "k8s.io/api/core/v1"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func presetNames(presets []k6tv1.VirtualMachineInstancePreset) []string {
+	names := []string{}
+	for _, preset := range presets {
+		names = append(names, preset.Name)
+	}
+	return names
+}
+
+func TestSortPresets(t *testing.T) {
+	const custom = "example.com/priority"
+	tests := []struct {
+		name    string
+		marking string
+		presets []k6tv1.VirtualMachineInstancePreset
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "default marking",
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("low", priorityMarking, "1", nil),
+				newTestPreset("high", priorityMarking, "10", nil),
+				newTestPreset("mid", priorityMarking, "5", nil),
+			},
+			want: []string{"high", "mid", "low"},
+		},
+		{
+			name:    "custom marking",
+			marking: custom,
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("low", custom, "1", nil),
+				newTestPreset("high", custom, "10", nil),
+			},
+			want: []string{"high", "low"},
+		},
+		{
+			name:    "presets marked with another annotation",
+			marking: custom,
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("low", priorityMarking, "1", nil),
+				newTestPreset("high", priorityMarking, "10", nil),
+			},
+			want:    []string{"low", "high"},
+			wantErr: true,
+		},
+		{
+			name: "presets without annotations",
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("first", "", "", nil),
+			},
+			want:    []string{"first"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProfiler("")
+			if tt.marking != "" {
+				p.SetPriorityMarking(tt.marking)
+			}
+			sorted, err := p.SortPresets(tt.presets)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if got := presetNames(sorted); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got presets %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPresets(t *testing.T) {
+	tests := []struct {
+		name         string
+		spec         k6tv1.DomainSpec
+		presets      []k6tv1.VirtualMachineInstancePreset
+		wantCores    uint32
+		wantModel    string
+		wantWarnings int
+		wantErr      bool
+	}{
+		{
+			name: "cores and model from the preset",
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("cpu", priorityMarking, "1", &k6tv1.DomainSpec{
+					CPU: &k6tv1.CPU{Cores: 4, Model: "Haswell"},
+				}),
+			},
+			wantCores: 4,
+			wantModel: "Haswell",
+		},
+		{
+			name: "the larger core count wins",
+			spec: k6tv1.DomainSpec{CPU: &k6tv1.CPU{Cores: 8, Model: "Haswell"}},
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("cpu", priorityMarking, "1", &k6tv1.DomainSpec{
+					CPU: &k6tv1.CPU{Cores: 4, Model: "Haswell"},
+				}),
+			},
+			wantCores: 8,
+			wantModel: "Haswell",
+		},
+		{
+			name: "the spec model wins, with a warning",
+			spec: k6tv1.DomainSpec{CPU: &k6tv1.CPU{Model: "Skylake"}},
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("cpu", priorityMarking, "1", &k6tv1.DomainSpec{
+					CPU: &k6tv1.CPU{Cores: 2, Model: "Haswell"},
+				}),
+			},
+			wantCores:    2,
+			wantModel:    "Skylake",
+			wantWarnings: 1,
+		},
+		{
+			name: "conflicting presets",
+			presets: []k6tv1.VirtualMachineInstancePreset{
+				newTestPreset("haswell", priorityMarking, "1", &k6tv1.DomainSpec{
+					CPU: &k6tv1.CPU{Model: "Haswell"},
+				}),
+				newTestPreset("skylake", priorityMarking, "2", &k6tv1.DomainSpec{
+					CPU: &k6tv1.CPU{Model: "Skylake"},
+				}),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProfiler("")
+			spec := tt.spec.DeepCopy()
+			domSpec, warnings, err := p.ApplyPresets(spec, tt.presets)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("got warnings %v, want %d", warnings, tt.wantWarnings)
+			}
+			if domSpec.CPU == nil || domSpec.CPU.Cores != tt.wantCores || domSpec.CPU.Model != tt.wantModel {
+				t.Errorf("got CPU %+v, want %d cores of model %s", domSpec.CPU, tt.wantCores, tt.wantModel)
+			}
+			if !reflect.DeepEqual(spec, tt.spec.DeepCopy()) {
+				t.Errorf("the input spec was changed")
+			}
+		})
+	}
+}
+
+func TestApplyPresetsResources(t *testing.T) {
+	spec := &k6tv1.DomainSpec{}
+	spec.Resources.Requests = k8sv1.ResourceList{
+		k8sv1.ResourceMemory: k8sres.MustParse("1Gi"),
+	}
+	preset := newTestPreset("memory", priorityMarking, "1", &k6tv1.DomainSpec{})
+	preset.Spec.Domain.Resources.Requests = k8sv1.ResourceList{
+		k8sv1.ResourceMemory: k8sres.MustParse("2Gi"),
+		k8sv1.ResourceCPU:    k8sres.MustParse("1"),
+	}
+
+	domSpec, _, err := NewProfiler("").ApplyPresets(spec, []k6tv1.VirtualMachineInstancePreset{preset})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	memory := domSpec.Resources.Requests[k8sv1.ResourceMemory]
+	if memory.Cmp(k8sres.MustParse("2Gi")) != 0 {
+		t.Errorf("got memory %s, want 2Gi", memory.String())
+	}
+	if _, ok := domSpec.Resources.Requests[k8sv1.ResourceCPU]; !ok {
+		t.Errorf("missing the CPU request of the preset")
+	}
+}
@@ -18,21 +18,14 @@ package virtprofiles
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
-	"os"
-	"path/filepath"
 	"regexp"
-
-	"strconv"
 	"strings"
 
-	"kubevirt.io/kubevirt/pkg/cloud-init"
-	"kubevirt.io/kubevirt/pkg/emptydisk"
-	"kubevirt.io/kubevirt/pkg/ephemeral-disk"
-	"kubevirt.io/kubevirt/pkg/registry-disk"
+	"kubevirt.io/kubevirt/pkg/precond"
 
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 	k8sv1 "k8s.io/api/core/v1"
@@ -45,53 +38,151 @@ const (
 	CPUModeHostModel       = "host-model"
 )
 
+const (
+	DefaultBridgeName = "br1"
+	DefaultVMCIDR     = "10.0.2.0/24"
+	DefaultProtocol   = "TCP"
+)
+
+const (
+	resolvConf          = "/etc/resolv.conf"
+	defaultDNS          = "8.8.8.8"
+	defaultSearchDomain = "cluster.local"
+	domainSearchPrefix  = "search"
+	nameserverPrefix    = "nameserver"
+)
+
+// ConverterContext holds the state shared by all the stage2 conversion functions
+type ConverterContext struct {
+	VirtualMachine *k6tv1.VirtualMachineInstance
+	UseEmulation   bool
+	Host           *Host
+	Secrets        map[string]*k8sv1.Secret
+	Claims         map[string]*k8sv1.PersistentVolumeClaim
+	Volumes        map[string]*k8sv1.PersistentVolume
+	Paths          *pathResolver
+	Profile        *ProfileSpec
+	LibvirtSecrets []Secret
+	// PCIDevicesInUse tracks the host PCI devices already assigned, by address
+	PCIDevicesInUse map[string]bool
+	Warnings        []string
+	// ctx stops the translation at its next checkpoint once done; nil means never
+	ctx context.Context
+}
+
+// checkpoint returns the error of the context once done, to abandon the translation
+func (c *ConverterContext) checkpoint() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+func (c *ConverterContext) warn(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// TranslationError reports a setting, of the VM spec or of the profiles, the translation can't express in libvirt
+type TranslationError struct {
+	// Field is the setting, like "clock.timer.kvmclock.tickPolicy"
+	Field string
+	// Value is the rejected value
+	Value string
+	// Reason tells why the value is rejected
+	Reason string
+}
+
+func (e *TranslationError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
 // TranslateSpecs implements the stage2, translating the stage1 domain specification into the stage3 format
-func (p *Profiler) TranslateSpecs(domSpec *k6tv1.DomainSpec) (*libvirtxml.Domain, error) {
-	ret := &libvirtxml.Domain{}
-	return ret, nil
+func (p *Profiler) TranslateSpecs(domSpec *k6tv1.DomainSpec) (*libvirtxml.Domain, []string, error) {
+	dom, _, warnings, err := p.TranslateSpecsWithSecrets(domSpec)
+	return dom, warnings, err
+}
+
+// TranslateSpecsWithSecrets is like TranslateSpecs, but also returns the libvirt secrets the domain needs,
+// translated from the Kubernetes Secrets registered with AddSecret.
+func (p *Profiler) TranslateSpecsWithSecrets(domSpec *k6tv1.DomainSpec) (*libvirtxml.Domain, []Secret, []string, error) {
+	return p.translateSpecs(context.Background(), domSpec)
+}
+
+// translateSpecs is TranslateSpecsWithSecrets, stopping at the next checkpoint once the context is done
+func (p *Profiler) translateSpecs(ctx context.Context, domSpec *k6tv1.DomainSpec) (*libvirtxml.Domain, []Secret, []string, error) {
+	vmi := &k6tv1.VirtualMachineInstance{}
+	if p.virtualMachine != nil {
+		p.virtualMachine.DeepCopyInto(vmi)
+	}
+	domSpec.DeepCopyInto(&vmi.Spec.Domain)
+	return p.translate(ctx, vmi)
 }
 
-func convert_v1_Disk_To_api_Disk(diskDevice *v1.Disk, disk *Disk, devicePerBus map[string]int) error {
+func (p *Profiler) translate(ctx context.Context, vmi *k6tv1.VirtualMachineInstance) (*libvirtxml.Domain, []Secret, []string, error) {
+	c := &ConverterContext{
+		ctx:             ctx,
+		VirtualMachine:  vmi,
+		UseEmulation:    p.useEmulation,
+		Host:            p.host,
+		Profile:         p.effectiveProfile(),
+		Secrets:         p.secrets,
+		Claims:          p.claims,
+		Volumes:         p.volumes,
+		Paths:           newPathResolver(p.pathLayout, p.baseDiskPath, vmi.Namespace, vmi.Name),
+		LibvirtSecrets:  []Secret{},
+		PCIDevicesInUse: make(map[string]bool),
+		Warnings:        []string{},
+	}
+	ensureHost(c)
+	ret := &libvirtxml.Domain{}
+	err := convert_v1_VirtualMachine_To_api_Domain(vmi, ret, c)
+	if err != nil {
+		return nil, nil, c.Warnings, err
+	}
+	return ret, c.LibvirtSecrets, c.Warnings, nil
+}
 
+func convert_v1_Disk_To_api_Disk(diskDevice *k6tv1.Disk, disk *libvirtxml.DomainDisk, devicePerBus map[string]int, c *ConverterContext) error {
+	disk.Target = &libvirtxml.DomainDiskTarget{}
 	if diskDevice.Disk != nil {
 		disk.Device = "disk"
 		disk.Target.Bus = diskDevice.Disk.Bus
-		disk.Target.Device = makeDeviceName(diskDevice.Disk.Bus, devicePerBus)
+		disk.Target.Dev = makeDeviceName(diskDevice.Disk.Bus, devicePerBus, c)
 		disk.ReadOnly = toApiReadOnly(diskDevice.Disk.ReadOnly)
 	} else if diskDevice.LUN != nil {
 		disk.Device = "lun"
 		disk.Target.Bus = diskDevice.LUN.Bus
-		disk.Target.Device = makeDeviceName(diskDevice.LUN.Bus, devicePerBus)
+		disk.Target.Dev = makeDeviceName(diskDevice.LUN.Bus, devicePerBus, c)
 		disk.ReadOnly = toApiReadOnly(diskDevice.LUN.ReadOnly)
 	} else if diskDevice.Floppy != nil {
 		disk.Device = "floppy"
 		disk.Target.Bus = "fdc"
 		disk.Target.Tray = string(diskDevice.Floppy.Tray)
-		disk.Target.Device = makeDeviceName(disk.Target.Bus, devicePerBus)
+		disk.Target.Dev = makeDeviceName(disk.Target.Bus, devicePerBus, c)
 		disk.ReadOnly = toApiReadOnly(diskDevice.Floppy.ReadOnly)
 	} else if diskDevice.CDRom != nil {
 		disk.Device = "cdrom"
 		disk.Target.Tray = string(diskDevice.CDRom.Tray)
 		disk.Target.Bus = diskDevice.CDRom.Bus
-		disk.Target.Device = makeDeviceName(diskDevice.CDRom.Bus, devicePerBus)
+		disk.Target.Dev = makeDeviceName(diskDevice.CDRom.Bus, devicePerBus, c)
 		if diskDevice.CDRom.ReadOnly != nil {
 			disk.ReadOnly = toApiReadOnly(*diskDevice.CDRom.ReadOnly)
 		} else {
 			disk.ReadOnly = toApiReadOnly(true)
 		}
 	}
-	disk.Driver = &DiskDriver{
+	disk.Driver = &libvirtxml.DomainDiskDriver{
 		Name: "qemu",
 	}
-	disk.Alias = &Alias{Name: diskDevice.Name}
+	disk.Alias = &libvirtxml.DomainAlias{Name: diskDevice.Name}
 	if diskDevice.BootOrder != nil {
-		disk.BootOrder = &BootOrder{Order: *diskDevice.BootOrder}
+		disk.Boot = &libvirtxml.DomainDeviceBoot{Order: *diskDevice.BootOrder}
 	}
 
 	return nil
 }
 
-func makeDeviceName(bus string, devicePerBus map[string]int) string {
+func makeDeviceName(bus string, devicePerBus map[string]int, c *ConverterContext) string {
 	index := devicePerBus[bus]
 	devicePerBus[bus] += 1
 
@@ -106,7 +197,7 @@ func makeDeviceName(bus string, devicePerBus map[string]int) string {
 	case "fdc":
 		prefix = "fd"
 	default:
-		log.Log.Errorf("Unrecognized bus '%s'", bus)
+		c.warn("Unrecognized bus '%s'", bus)
 		return ""
 	}
 	return formatDeviceName(prefix, index)
@@ -118,23 +209,23 @@ func formatDeviceName(prefix string, index int) string {
 	name := ""
 
 	for index >= 0 {
-		name = string('a'+(index%base)) + name
+		name = string(rune('a'+(index%base))) + name
 		index = (index / base) - 1
 	}
 	return prefix + name
 }
 
-func toApiReadOnly(src bool) *ReadOnly {
+func toApiReadOnly(src bool) *libvirtxml.DomainDiskReadOnly {
 	if src {
-		return &ReadOnly{}
+		return &libvirtxml.DomainDiskReadOnly{}
 	}
 	return nil
 }
 
-func convert_v1_Volume_To_api_Disk(source *v1.Volume, disk *Disk, c *ConverterContext) error {
+func convert_v1_Volume_To_api_Disk(source *k6tv1.Volume, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
 
-	if source.RegistryDisk != nil {
-		return convert_v1_RegistryDiskSource_To_api_Disk(source.Name, source.RegistryDisk, disk, c)
+	if source.ContainerDisk != nil {
+		return convert_v1_ContainerDiskSource_To_api_Disk(source.Name, source.ContainerDisk, disk, c)
 	}
 
 	if source.CloudInitNoCloud != nil {
@@ -142,7 +233,20 @@ func convert_v1_Volume_To_api_Disk(source *v1.Volume, disk *Disk, c *ConverterCo
 	}
 
 	if source.PersistentVolumeClaim != nil {
-		return convert_v1_FilesystemVolumeSource_To_api_Disk(source.Name, disk, c)
+		return convert_v1_PersistentVolumeClaim_To_api_Disk(source.Name, source.PersistentVolumeClaim.ClaimName, disk, c)
+	}
+
+	if source.DataVolume != nil {
+		// the DataVolumes are backed by a PersistentVolumeClaim with the same name
+		return convert_v1_PersistentVolumeClaim_To_api_Disk(source.Name, source.DataVolume.Name, disk, c)
+	}
+
+	if source.HostDisk != nil {
+		return convert_v1_HostDisk_To_api_Disk(source.HostDisk, disk, c)
+	}
+
+	if source.ConfigMap != nil || source.Secret != nil || source.ServiceAccount != nil {
+		return convert_v1_ConfigVolume_To_api_Disk(source, disk, c)
 	}
 
 	if source.Ephemeral != nil {
@@ -156,77 +260,106 @@ func convert_v1_Volume_To_api_Disk(source *v1.Volume, disk *Disk, c *ConverterCo
 }
 
 // convert_v1_FilesystemVolumeSource_To_api_Disk takes a FS source and builds the KVM Disk representation
-func convert_v1_FilesystemVolumeSource_To_api_Disk(volumeName string, disk *Disk, c *ConverterContext) error {
+func convert_v1_FilesystemVolumeSource_To_api_Disk(volumeName string, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
 
-	disk.Type = "file"
+	diskPath, err := c.Paths.DiskImage(volumeName)
+	if err != nil {
+		return err
+	}
 	disk.Driver.Type = "raw"
-	disk.Source.File = filepath.Join(
-		"/var/run/kubevirt-private",
-		"vmi-disks",
-		volumeName,
-		"disk.img")
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: diskPath,
+		},
+	}
 	return nil
 }
 
-func convert_v1_CloudInitNoCloudSource_To_api_Disk(source *v1.CloudInitNoCloudSource, disk *Disk, c *ConverterContext) error {
-	if disk.Type == "lun" {
+func convert_v1_CloudInitNoCloudSource_To_api_Disk(source *k6tv1.CloudInitNoCloudSource, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	if disk.Device == "lun" {
 		return fmt.Errorf("device %s is of type lun. Not compatible with a file based disk", disk.Alias.Name)
 	}
 
-	disk.Source.File = fmt.Sprintf("%s/%s", cloudinit.GetDomainBasePath(c.VirtualMachine.Name, c.VirtualMachine.Namespace), cloudinit.NoCloudFile)
-	disk.Type = "file"
+	isoPath, err := c.Paths.CloudInitISO()
+	if err != nil {
+		return err
+	}
 	disk.Driver.Type = "raw"
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: isoPath,
+		},
+	}
 	return nil
 }
 
-func convert_v1_EmptyDiskSource_To_api_Disk(volumeName string, _ *v1.EmptyDiskSource, disk *Disk, c *ConverterContext) error {
-	if disk.Type == "lun" {
+func convert_v1_EmptyDiskSource_To_api_Disk(volumeName string, _ *k6tv1.EmptyDiskSource, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	if disk.Device == "lun" {
 		return fmt.Errorf("device %s is of type lun. Not compatible with a file based disk", disk.Alias.Name)
 	}
 
-	disk.Type = "file"
+	diskPath, err := c.Paths.EmptyDisk(volumeName)
+	if err != nil {
+		return err
+	}
 	disk.Driver.Type = "qcow2"
-	disk.Source.File = emptydisk.FilePathForVolumeName(volumeName)
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: diskPath,
+		},
+	}
 
 	return nil
 }
 
-func convert_v1_RegistryDiskSource_To_api_Disk(volumeName string, _ *v1.RegistryDiskSource, disk *Disk, c *ConverterContext) error {
-	if disk.Type == "lun" {
+func convert_v1_ContainerDiskSource_To_api_Disk(volumeName string, _ *k6tv1.ContainerDiskSource, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	if disk.Device == "lun" {
 		return fmt.Errorf("device %s is of type lun. Not compatible with a file based disk", disk.Alias.Name)
 	}
 
-	disk.Type = "file"
-	diskPath, diskType, err := registrydisk.GetFilePath(c.VirtualMachine, volumeName)
+	diskPath, diskType, err := c.Paths.ContainerDisk(volumeName)
 	if err != nil {
 		return err
 	}
 	disk.Driver.Type = diskType
-	disk.Source.File = diskPath
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: diskPath,
+		},
+	}
 	return nil
 }
 
-func convert_v1_EphemeralVolumeSource_To_api_Disk(volumeName string, source *v1.EphemeralVolumeSource, disk *Disk, c *ConverterContext) error {
-	disk.Type = "file"
+func convert_v1_EphemeralVolumeSource_To_api_Disk(volumeName string, source *k6tv1.EphemeralVolumeSource, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	diskPath, err := c.Paths.EphemeralDisk(volumeName)
+	if err != nil {
+		return err
+	}
 	disk.Driver.Type = "qcow2"
-	disk.Source.File = ephemeraldisk.GetFilePath(volumeName)
-	disk.BackingStore = &BackingStore{}
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: diskPath,
+		},
+	}
 
-	backingDisk := &Disk{Driver: &DiskDriver{}}
-	err := convert_v1_FilesystemVolumeSource_To_api_Disk(volumeName, backingDisk, c)
+	backingDisk := &libvirtxml.DomainDisk{Driver: &libvirtxml.DomainDiskDriver{}}
+	err = convert_v1_FilesystemVolumeSource_To_api_Disk(volumeName, backingDisk, c)
 	if err != nil {
 		return err
 	}
 
-	disk.BackingStore.Format.Type = backingDisk.Driver.Type
-	disk.BackingStore.Source = &backingDisk.Source
-	disk.BackingStore.Type = backingDisk.Type
+	disk.BackingStore = &libvirtxml.DomainDiskBackingStore{
+		Format: &libvirtxml.DomainDiskFormat{
+			Type: backingDisk.Driver.Type,
+		},
+		Source: backingDisk.Source,
+	}
 
 	return nil
 }
 
-func convert_v1_Watchdog_To_api_Watchdog(source *v1.Watchdog, watchdog *Watchdog, _ *ConverterContext) error {
-	watchdog.Alias = &Alias{
+func convert_v1_Watchdog_To_api_Watchdog(source *k6tv1.Watchdog, watchdog *libvirtxml.DomainWatchdog, _ *ConverterContext) error {
+	watchdog.Alias = &libvirtxml.DomainAlias{
 		Name: source.Name,
 	}
 	if source.I6300ESB != nil {
@@ -237,381 +370,388 @@ func convert_v1_Watchdog_To_api_Watchdog(source *v1.Watchdog, watchdog *Watchdog
 	return fmt.Errorf("watchdog %s can't be mapped, no watchdog type specified", source.Name)
 }
 
-func convert_v1_Clock_To_api_Clock(source *v1.Clock, clock *Clock, c *ConverterContext) error {
-	if source.UTC != nil {
-		clock.Offset = "utc"
-		if source.UTC.OffsetSeconds != nil {
-			clock.Adjustment = strconv.Itoa(*source.UTC.OffsetSeconds)
-		} else {
-			clock.Adjustment = "reset"
-		}
-	} else if source.Timezone != nil {
-		clock.Offset = "timezone"
-	}
-
-	if source.Timer != nil {
-		if source.Timer.RTC != nil {
-			newTimer := Timer{Name: "rtc"}
-			newTimer.Track = string(source.Timer.RTC.Track)
-			newTimer.TickPolicy = string(source.Timer.RTC.TickPolicy)
-			newTimer.Present = boolToYesNo(source.Timer.RTC.Enabled, true)
-			clock.Timer = append(clock.Timer, newTimer)
-		}
-		if source.Timer.PIT != nil {
-			newTimer := Timer{Name: "pit"}
-			newTimer.Present = boolToYesNo(source.Timer.PIT.Enabled, true)
-			newTimer.TickPolicy = string(source.Timer.PIT.TickPolicy)
-			clock.Timer = append(clock.Timer, newTimer)
-		}
-		if source.Timer.KVM != nil {
-			newTimer := Timer{Name: "kvmclock"}
-			newTimer.Present = boolToYesNo(source.Timer.KVM.Enabled, true)
-			clock.Timer = append(clock.Timer, newTimer)
-		}
-		if source.Timer.HPET != nil {
-			newTimer := Timer{Name: "hpet"}
-			newTimer.Present = boolToYesNo(source.Timer.HPET.Enabled, true)
-			newTimer.TickPolicy = string(source.Timer.HPET.TickPolicy)
-			clock.Timer = append(clock.Timer, newTimer)
-		}
-		if source.Timer.Hyperv != nil {
-			newTimer := Timer{Name: "hypervclock"}
-			newTimer.Present = boolToYesNo(source.Timer.Hyperv.Enabled, true)
-			clock.Timer = append(clock.Timer, newTimer)
-		}
-	}
-
-	return nil
-}
-
-func convertFeatureState(source *v1.FeatureState) *FeatureState {
+func convertFeatureState(source *k6tv1.FeatureState) *libvirtxml.DomainFeatureState {
 	if source != nil {
-		return &FeatureState{
+		return &libvirtxml.DomainFeatureState{
 			State: boolToOnOff(source.Enabled, true),
 		}
 	}
 	return nil
 }
 
-func convert_v1_Features_To_api_Features(source *v1.Features, features *Features, c *ConverterContext) error {
+func convert_v1_Features_To_api_Features(source *k6tv1.Features, features *libvirtxml.DomainFeatureList, c *ConverterContext) error {
 	if source.ACPI.Enabled == nil || *source.ACPI.Enabled {
-		features.ACPI = &FeatureEnabled{}
+		features.ACPI = &libvirtxml.DomainFeature{}
 	}
 	if source.APIC != nil {
 		if source.APIC.Enabled == nil || *source.APIC.Enabled {
-			features.APIC = &FeatureEnabled{}
+			features.APIC = &libvirtxml.DomainFeatureAPIC{}
 		}
 	}
 	if source.Hyperv != nil {
-		features.Hyperv = &FeatureHyperv{}
-		err := convert_v1_FeatureHyperv_To_api_FeatureHyperv(source.Hyperv, features.Hyperv, c)
+		features.HyperV = &libvirtxml.DomainFeatureHyperV{}
+		err := convert_v1_FeatureHyperv_To_api_FeatureHyperv(source.Hyperv, features.HyperV, c)
 		if err != nil {
-			return nil
+			return err
 		}
 	}
 	return nil
 }
 
-func convert_v1_Machine_To_api_OSType(source *v1.Machine, ost *OSType, c *ConverterContext) error {
+func convert_v1_Machine_To_api_OSType(source *k6tv1.Machine, ost *libvirtxml.DomainOSType, c *ConverterContext) error {
 	ost.Machine = source.Type
 
 	return nil
 }
 
-func convert_v1_FeatureHyperv_To_api_FeatureHyperv(source *v1.FeatureHyperv, hyperv *FeatureHyperv, c *ConverterContext) error {
+func convert_v1_FeatureHyperv_To_api_FeatureHyperv(source *k6tv1.FeatureHyperv, hyperv *libvirtxml.DomainFeatureHyperV, c *ConverterContext) error {
 	if source.Spinlocks != nil {
-		hyperv.Spinlocks = &FeatureSpinlocks{
-			State:   boolToOnOff(source.Spinlocks.Enabled, true),
-			Retries: source.Spinlocks.Retries,
+		hyperv.Spinlocks = &libvirtxml.DomainFeatureHyperVSpinlocks{
+			DomainFeatureState: libvirtxml.DomainFeatureState{
+				State: boolToOnOff(source.Spinlocks.Enabled, true),
+			},
+		}
+		if source.Spinlocks.Retries != nil {
+			hyperv.Spinlocks.Retries = uint(*source.Spinlocks.Retries)
 		}
 	}
 	if source.VendorID != nil {
-		hyperv.VendorID = &FeatureVendorID{
-			State: boolToOnOff(source.VendorID.Enabled, true),
+		hyperv.VendorId = &libvirtxml.DomainFeatureHyperVVendorId{
+			DomainFeatureState: libvirtxml.DomainFeatureState{
+				State: boolToOnOff(source.VendorID.Enabled, true),
+			},
 			Value: source.VendorID.VendorID,
 		}
 	}
 	hyperv.Relaxed = convertFeatureState(source.Relaxed)
 	hyperv.Reset = convertFeatureState(source.Reset)
 	hyperv.Runtime = convertFeatureState(source.Runtime)
-	hyperv.SyNIC = convertFeatureState(source.SyNIC)
-	hyperv.SyNICTimer = convertFeatureState(source.SyNICTimer)
+	hyperv.Synic = convertFeatureState(source.SyNIC)
+	hyperv.STimer = convertFeatureState(source.SyNICTimer)
 	hyperv.VAPIC = convertFeatureState(source.VAPIC)
 	hyperv.VPIndex = convertFeatureState(source.VPIndex)
+	hyperv.Frequencies = convertFeatureState(source.Frequencies)
+	hyperv.ReEnlightenment = convertFeatureState(source.Reenlightenment)
+	hyperv.TLBFlush = convertFeatureState(source.TLBFlush)
+	hyperv.IPI = convertFeatureState(source.IPI)
+	hyperv.EVMCS = convertFeatureState(source.EVMCS)
 	return nil
 }
 
-func convert_v1_VirtualMachine_To_api_Domain(vmi *v1.VirtualMachineInstance, domain *Domain, c *ConverterContext) (err error) {
+func convert_v1_VirtualMachine_To_api_Domain(vmi *k6tv1.VirtualMachineInstance, domain *libvirtxml.Domain, c *ConverterContext) (err error) {
 	precond.MustNotBeNil(vmi)
 	precond.MustNotBeNil(domain)
 	precond.MustNotBeNil(c)
 
-	domain.Spec.Name = VMINamespaceKeyFunc(vmi)
-	domain.ObjectMeta.Name = vmi.ObjectMeta.Name
-	domain.ObjectMeta.Namespace = vmi.ObjectMeta.Namespace
+	domain.Type = VirtTypeKVM
+	domain.Name = VMINamespaceKeyFunc(vmi)
+	domain.OS = &libvirtxml.DomainOS{
+		Type: &libvirtxml.DomainOSType{
+			Type: "hvm",
+		},
+	}
+	domain.CPU = &libvirtxml.DomainCPU{}
+	domain.Devices = &libvirtxml.DomainDeviceList{}
 
-	if _, err := os.Stat("/dev/kvm"); os.IsNotExist(err) {
-		if c.UseEmulation {
-			logger := log.DefaultLogger()
-			logger.Infof("Hardware emulation device '/dev/kvm' not present. Using software emulation.")
-			domain.Spec.Type = "qemu"
+	host := c.Host
+	if host == nil {
+		host = DefaultHost()
+	}
+	if !host.Supports(VirtTypeKVM) {
+		if c.UseEmulation && host.Supports(VirtTypeQEMU) {
+			c.warn("Hardware virtualization not supported by the host. Using software emulation.")
+			domain.Type = VirtTypeQEMU
 		} else {
-			return fmt.Errorf("hardware emulation device '/dev/kvm' not present")
+			return fmt.Errorf("hardware virtualization not supported by the host")
 		}
-	} else if err != nil {
-		return err
 	}
 
-	// Spec metadata
-	domain.Spec.Metadata.KubeVirt.UID = vmi.UID
-	if vmi.Spec.TerminationGracePeriodSeconds != nil {
-		domain.Spec.Metadata.KubeVirt.GracePeriod.DeletionGracePeriodSeconds = *vmi.Spec.TerminationGracePeriodSeconds
+	domain.SysInfo = &libvirtxml.DomainSysInfo{
+		Type: "smbios",
 	}
-
-	domain.Spec.SysInfo = &SysInfo{}
 	if vmi.Spec.Domain.Firmware != nil {
-		domain.Spec.SysInfo.System = []Entry{
-			{
-				Name:  "uuid",
-				Value: string(vmi.Spec.Domain.Firmware.UUID),
+		domain.SysInfo.System = &libvirtxml.DomainSysInfoSystem{
+			Entry: []libvirtxml.DomainSysInfoEntry{
+				{
+					Name:  "uuid",
+					Value: string(vmi.Spec.Domain.Firmware.UUID),
+				},
 			},
 		}
 	}
+	err = convert_api_SMBIOS_To_api_SysInfo(vmi, domain, c)
+	if err != nil {
+		return err
+	}
 
 	if v, ok := vmi.Spec.Domain.Resources.Requests[k8sv1.ResourceMemory]; ok {
-		if domain.Spec.Memory, err = quantityToByte(v); err != nil {
+		if domain.Memory, err = quantityToByte(v); err != nil {
 			return err
 		}
 	}
 
-	if vmi.Spec.Domain.Memory != nil && vmi.Spec.Domain.Memory.Hugepages != nil {
-		domain.Spec.MemoryBacking = &MemoryBacking{
-			HugePages: &HugePages{},
-		}
+	err = convert_v1_Memory_To_api_MemoryBacking(vmi.Spec.Domain.Memory, domain, c)
+	if err != nil {
+		return err
 	}
 
-	volumes := map[string]*v1.Volume{}
+	volumes := map[string]*k6tv1.Volume{}
 	for _, volume := range vmi.Spec.Volumes {
 		volumes[volume.Name] = volume.DeepCopy()
 	}
 
 	devicePerBus := make(map[string]int)
 	for _, disk := range vmi.Spec.Domain.Devices.Disks {
-		newDisk := Disk{}
-
-		err := convert_v1_Disk_To_api_Disk(&disk, &newDisk, devicePerBus)
-		if err != nil {
+		if err := c.checkpoint(); err != nil {
 			return err
 		}
-		volume := volumes[disk.VolumeName]
+		newDisk := libvirtxml.DomainDisk{}
+
+		volume := volumes[disk.Name]
 		if volume == nil {
-			return fmt.Errorf("No matching volume with name %s found", disk.VolumeName)
+			return fmt.Errorf("No matching volume with name %s found", disk.Name)
+		}
+		if isConfigVolume(volume) && c.configVolumesMode() == ConfigVolumesVirtiofs {
+			err := convert_v1_ConfigVolume_To_api_Filesystem(volume, domain, c)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := convert_v1_Disk_To_api_Disk(&disk, &newDisk, devicePerBus, c)
+		if err != nil {
+			return err
 		}
 		err = convert_v1_Volume_To_api_Disk(volume, &newDisk, c)
 		if err != nil {
 			return err
 		}
-		domain.Spec.Devices.Disks = append(domain.Spec.Devices.Disks, newDisk)
+		err = convert_v1_EncryptionSecret_To_api_Disk(volume.Name, &newDisk, c)
+		if err != nil {
+			return err
+		}
+		err = convert_api_DiskTuning_To_api_Disk(volume, &newDisk, c)
+		if err != nil {
+			return err
+		}
+		domain.Devices.Disks = append(domain.Devices.Disks, newDisk)
+	}
+	err = convert_api_SCSIDisks_To_api_Controllers(domain, c)
+	if err != nil {
+		return err
+	}
+	err = convert_api_Devices_To_api_IOThreads(domain, c)
+	if err != nil {
+		return err
 	}
 
 	if vmi.Spec.Domain.Devices.Watchdog != nil {
-		newWatchdog := &Watchdog{}
+		newWatchdog := &libvirtxml.DomainWatchdog{}
 		err := convert_v1_Watchdog_To_api_Watchdog(vmi.Spec.Domain.Devices.Watchdog, newWatchdog, c)
 		if err != nil {
 			return err
 		}
-		domain.Spec.Devices.Watchdog = newWatchdog
+		domain.Devices.Watchdog = newWatchdog
 	}
 
-	if vmi.Spec.Domain.Clock != nil {
-		clock := vmi.Spec.Domain.Clock
-		newClock := &Clock{}
-		err := convert_v1_Clock_To_api_Clock(clock, newClock, c)
+	if vmi.Spec.Domain.Clock != nil || c.Profile.Clock != nil {
+		newClock := &libvirtxml.DomainClock{}
+		err := convert_v1_Clock_To_api_Clock(vmi.Spec.Domain.Clock, newClock, c)
 		if err != nil {
 			return err
 		}
-		domain.Spec.Clock = newClock
+		domain.Clock = newClock
 	}
 
 	if vmi.Spec.Domain.Features != nil {
-		domain.Spec.Features = &Features{}
-		err := convert_v1_Features_To_api_Features(vmi.Spec.Domain.Features, domain.Spec.Features, c)
+		domain.Features = &libvirtxml.DomainFeatureList{}
+		err := convert_v1_Features_To_api_Features(vmi.Spec.Domain.Features, domain.Features, c)
 		if err != nil {
 			return err
 		}
 	}
+	err = convert_api_HyperV_To_api_Features(domain, c)
+	if err != nil {
+		return err
+	}
 	apiOst := &vmi.Spec.Domain.Machine
-	err = convert_v1_Machine_To_api_OSType(apiOst, &domain.Spec.OS.Type, c)
+	err = convert_v1_Machine_To_api_OSType(apiOst, domain.OS.Type, c)
+	if err != nil {
+		return err
+	}
+	err = convert_api_Firmware_To_api_OS(domain, c)
 	if err != nil {
 		return err
 	}
 
-	if vmi.Spec.Domain.CPU != nil {
-		// Set VM CPU cores
-		if vmi.Spec.Domain.CPU.Cores != 0 {
-			domain.Spec.CPU.Topology = &CPUTopology{
-				Sockets: 1,
-				Cores:   vmi.Spec.Domain.CPU.Cores,
-				Threads: 1,
-			}
-			domain.Spec.VCPU = &VCPU{
-				Placement: "static",
-				CPUs:      vmi.Spec.Domain.CPU.Cores,
-			}
-		}
+	// Set VM CPU count and topology
+	err = convert_v1_CPU_To_api_Topology(vmi.Spec.Domain.CPU, domain, c)
+	if err != nil {
+		return err
+	}
 
+	if vmi.Spec.Domain.CPU != nil {
 		// Set VM CPU model and vendor
 		if vmi.Spec.Domain.CPU.Model != "" {
 			if vmi.Spec.Domain.CPU.Model == CPUModeHostModel || vmi.Spec.Domain.CPU.Model == CPUModeHostPassthrough {
-				domain.Spec.CPU.Mode = vmi.Spec.Domain.CPU.Model
+				domain.CPU.Mode = vmi.Spec.Domain.CPU.Model
 			} else {
-				domain.Spec.CPU.Mode = "custom"
-				domain.Spec.CPU.Model = vmi.Spec.Domain.CPU.Model
+				domain.CPU.Mode = "custom"
+				domain.CPU.Model = &libvirtxml.DomainCPUModel{
+					Value: vmi.Spec.Domain.CPU.Model,
+				}
 			}
 		}
 	}
 
 	if vmi.Spec.Domain.CPU == nil || vmi.Spec.Domain.CPU.Model == "" {
-		domain.Spec.CPU.Mode = CPUModeHostModel
+		domain.CPU.Mode = CPUModeHostModel
 	}
 
 	// Add mandatory console device
 	var serialPort uint = 0
-	var serialType string = "serial"
-	domain.Spec.Devices.Consoles = []Console{
+	serialPath, err := c.Paths.SerialSocket(serialPort)
+	if err != nil {
+		return err
+	}
+	domain.Devices.Consoles = []libvirtxml.DomainConsole{
 		{
-			Type: "pty",
-			Target: &ConsoleTarget{
-				Type: &serialType,
+			Source: &libvirtxml.DomainChardevSource{
+				Pty: &libvirtxml.DomainChardevSourcePty{},
+			},
+			Target: &libvirtxml.DomainConsoleTarget{
+				Type: "serial",
 				Port: &serialPort,
 			},
 		},
 	}
 
-	domain.Spec.Devices.Serials = []Serial{
+	domain.Devices.Serials = []libvirtxml.DomainSerial{
 		{
-			Type: "unix",
-			Target: &SerialTarget{
-				Port: &serialPort,
+			Source: &libvirtxml.DomainChardevSource{
+				UNIX: &libvirtxml.DomainChardevSourceUNIX{
+					Mode: "bind",
+					Path: serialPath,
+				},
 			},
-			Source: &SerialSource{
-				Mode: "bind",
-				Path: fmt.Sprintf("/var/run/kubevirt-private/%s/%s/virt-serial%d", vmi.ObjectMeta.Namespace, vmi.ObjectMeta.Name, serialPort),
+			Target: &libvirtxml.DomainSerialTarget{
+				Port: &serialPort,
 			},
 		},
 	}
 
-	if vmi.Spec.Domain.Devices.AutoattachGraphicsDevice == nil || *vmi.Spec.Domain.Devices.AutoattachGraphicsDevice == true {
-		var heads uint = 1
-		var vram uint = 16384
-		domain.Spec.Devices.Video = []Video{
-			{
-				Model: VideoModel{
-					Type:  "vga",
-					Heads: &heads,
-					VRam:  &vram,
-				},
-			},
-		}
-		domain.Spec.Devices.Graphics = []Graphics{
-			{
-				Listen: &GraphicsListen{
-					Type:   "socket",
-					Socket: fmt.Sprintf("/var/run/kubevirt-private/%s/%s/virt-vnc", vmi.ObjectMeta.Namespace, vmi.ObjectMeta.Name),
-				},
-				Type: "vnc",
-			},
-		}
+	err = c.checkpoint()
+	if err != nil {
+		return err
+	}
+	err = convert_api_Display_To_api_Devices(vmi, domain, c)
+	if err != nil {
+		return err
+	}
+	err = convert_api_Channels_To_api_Channels(domain, c)
+	if err != nil {
+		return err
+	}
+	err = convert_api_RNG_To_api_RNGs(domain, c)
+	if err != nil {
+		return err
+	}
+	err = convert_api_Balloon_To_api_MemBalloon(domain, c)
+	if err != nil {
+		return err
+	}
+	err = convert_api_Inputs_To_api_Inputs(domain, c)
+	if err != nil {
+		return err
 	}
 
-	getInterfaceType := func(iface *v1.Interface) string {
-		if iface.Slirp != nil {
-			// Slirp configuration works only with e1000 or rtl8139
-			if iface.Model != "e1000" && iface.Model != "rtl8139" {
-				log.Log.Infof("The network interface type of %s was changed to e1000 due to unsupported interface type by qemu slirp network", iface.Name)
-				return "e1000"
-			}
-			return iface.Model
-		}
-		if iface.Model != "" {
-			return iface.Model
+	getInterfaceType := func(iface *k6tv1.Interface) string {
+		// Slirp configuration works only with e1000 or rtl8139
+		if iface.Model != "e1000" && iface.Model != "rtl8139" {
+			c.warn("The network interface type of %s was changed to e1000 due to unsupported interface type by qemu slirp network", iface.Name)
+			return "e1000"
 		}
-		return "virtio"
+		return iface.Model
 	}
 
-	networks := map[string]*v1.Network{}
+	networks := map[string]*k6tv1.Network{}
 	for _, network := range vmi.Spec.Networks {
 		networks[network.Name] = network.DeepCopy()
 	}
 
 	for _, iface := range vmi.Spec.Domain.Devices.Interfaces {
+		if err := c.checkpoint(); err != nil {
+			return err
+		}
 		net, isExist := networks[iface.Name]
 		if !isExist {
 			return fmt.Errorf("failed to find network %s", iface.Name)
 		}
 
-		if net.Pod == nil {
-			return fmt.Errorf("network interface type not supported for %s", iface.Name)
-		}
+		if iface.Slirp != nil {
+			if net.Pod == nil {
+				return fmt.Errorf("slirp binding supported only on the pod network for %s", iface.Name)
+			}
 
-		if iface.Bridge != nil {
-			// TODO:(ihar) consider abstracting interface type conversion /
-			// detection into drivers
-			domainIface := Interface{
-				Model: &Model{
+			domainIface := libvirtxml.DomainInterface{
+				Model: &libvirtxml.DomainInterfaceModel{
 					Type: getInterfaceType(&iface),
 				},
-				Type: "bridge",
-				Source: InterfaceSource{
-					Bridge: DefaultBridgeName,
-				},
-				Alias: &Alias{
-					Name: iface.Name,
-				},
-			}
-			domain.Spec.Devices.Interfaces = append(domain.Spec.Devices.Interfaces, domainIface)
-		} else if iface.Slirp != nil {
-			domainIface := Interface{
-				Model: &Model{
-					Type: getInterfaceType(&iface),
+				Source: &libvirtxml.DomainInterfaceSource{
+					User: &libvirtxml.DomainInterfaceSourceUser{},
 				},
-				Type: "user",
-				Alias: &Alias{
+				Alias: &libvirtxml.DomainAlias{
 					Name: iface.Name,
 				},
 			}
-			domain.Spec.Devices.Interfaces = append(domain.Spec.Devices.Interfaces, domainIface)
+			domain.Devices.Interfaces = append(domain.Devices.Interfaces, domainIface)
 
 			// Create network interface
-			if domain.Spec.QEMUCmd == nil {
-				domain.Spec.QEMUCmd = &Commandline{}
-			}
-
-			if domain.Spec.QEMUCmd.QEMUArg == nil {
-				domain.Spec.QEMUCmd.QEMUArg = make([]Arg, 0)
+			if domain.QEMUCommandline == nil {
+				domain.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{}
 			}
 
 			// TODO: (seba) Need to change this if multiple interface can be connected to the same network
 			// append the ports from all the interfaces connected to the same network
-			err := createSlirpNetwork(iface, *net, domain)
+			err := createSlirpNetwork(iface, *net, domain, c)
+			if err != nil {
+				return err
+			}
+		} else {
+			attachment, err := networkAttachmentFor(&iface, net, c)
+			if err != nil {
+				return err
+			}
+			err = convert_v1_Interface_To_api_Interface(&iface, attachment, domain, c)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	err = convert_v1_HostDevices_To_api_Hostdevs(vmi, domain, c)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func createSlirpNetwork(iface v1.Interface, network v1.Network, domain *Domain) error {
-	qemuArg := Arg{Value: fmt.Sprintf("user,id=%s", iface.Name)}
+// VMINamespaceKeyFunc builds the libvirt domain name out of the VMI namespace and name
+func VMINamespaceKeyFunc(vmi *k6tv1.VirtualMachineInstance) string {
+	return fmt.Sprintf("%s_%s", vmi.Namespace, vmi.Name)
+}
+
+func createSlirpNetwork(iface k6tv1.Interface, network k6tv1.Network, domain *libvirtxml.Domain, c *ConverterContext) error {
+	qemuArg := libvirtxml.DomainQEMUCommandlineArg{Value: fmt.Sprintf("user,id=%s", iface.Name)}
 
 	err := configVMCIDR(&qemuArg, iface, network)
 	if err != nil {
 		return err
 	}
 
-	err = configDNSSearchName(&qemuArg)
+	err = configDNS(&qemuArg, c)
 	if err != nil {
 		return err
 	}
@@ -621,13 +761,13 @@ func createSlirpNetwork(iface v1.Interface, network v1.Network, domain *Domain)
 		return err
 	}
 
-	domain.Spec.QEMUCmd.QEMUArg = append(domain.Spec.QEMUCmd.QEMUArg, Arg{Value: "-netdev"})
-	domain.Spec.QEMUCmd.QEMUArg = append(domain.Spec.QEMUCmd.QEMUArg, qemuArg)
+	domain.QEMUCommandline.Args = append(domain.QEMUCommandline.Args, libvirtxml.DomainQEMUCommandlineArg{Value: "-netdev"})
+	domain.QEMUCommandline.Args = append(domain.QEMUCommandline.Args, qemuArg)
 
 	return nil
 }
 
-func configPortForward(qemuArg *Arg, iface v1.Interface) error {
+func configPortForward(qemuArg *libvirtxml.DomainQEMUCommandlineArg, iface k6tv1.Interface) error {
 	if iface.Ports == nil {
 		return nil
 	}
@@ -654,7 +794,7 @@ func configPortForward(qemuArg *Arg, iface v1.Interface) error {
 	return nil
 }
 
-func configVMCIDR(qemuArg *Arg, iface v1.Interface, network v1.Network) error {
+func configVMCIDR(qemuArg *libvirtxml.DomainQEMUCommandlineArg, iface k6tv1.Interface, network k6tv1.Network) error {
 	vmNetworkCIDR := ""
 	if network.Pod.VMNetworkCIDR != "" {
 		_, _, err := net.ParseCIDR(network.Pod.VMNetworkCIDR)
@@ -672,29 +812,57 @@ func configVMCIDR(qemuArg *Arg, iface v1.Interface, network v1.Network) error {
 	return nil
 }
 
-func configDNSSearchName(qemuArg *Arg) error {
-	_, dnsDoms, err := getResolvConfDetailsFromPod()
-	if err != nil {
-		return err
+// configDNS sets the DNS settings given in the profiles. The resolv.conf of the host running
+// the profiler is used only if explicitly requested, to not leak its settings in the VMs.
+func configDNS(qemuArg *libvirtxml.DomainQEMUCommandlineArg, c *ConverterContext) error {
+	dnsConf := c.Profile.DNS
+	if dnsConf == nil {
+		return nil
 	}
 
+	nameservers := dnsConf.Nameservers
+	dnsDoms := dnsConf.SearchDomains
+	if dnsConf.FromResolvConf {
+		resolvServers, resolvDoms, err := getResolvConfDetailsFromPod()
+		if err != nil {
+			return err
+		}
+		if len(nameservers) == 0 {
+			for _, server := range resolvServers {
+				nameservers = append(nameservers, net.IP(server).String())
+			}
+		}
+		if len(dnsDoms) == 0 {
+			dnsDoms = resolvDoms
+		}
+	}
+
+	if len(nameservers) > 0 {
+		if net.ParseIP(nameservers[0]) == nil {
+			return fmt.Errorf("invalid nameserver: %s", nameservers[0])
+		}
+		if len(nameservers) > 1 {
+			c.warn("slirp networks support only one nameserver, using %s", nameservers[0])
+		}
+		qemuArg.Value += fmt.Sprintf(",dns=%s", nameservers[0])
+	}
 	for _, dom := range dnsDoms {
 		qemuArg.Value += fmt.Sprintf(",dnssearch=%s", dom)
 	}
 	return nil
 }
 
-func secretToLibvirtSecret(vmi *v1.VirtualMachineInstance, secretName string) string {
+func secretToLibvirtSecret(vmi *k6tv1.VirtualMachineInstance, secretName string) string {
 	return fmt.Sprintf("%s-%s-%s---", secretName, vmi.Namespace, vmi.Name)
 }
 
-func quantityToByte(quantity resource.Quantity) (Memory, error) {
+func quantityToByte(quantity k8sres.Quantity) (*libvirtxml.DomainMemory, error) {
 	memorySize, _ := quantity.AsInt64()
 	if memorySize < 0 {
-		return Memory{Unit: "B"}, fmt.Errorf("Memory size '%s' must be greater than or equal to 0", quantity.String())
+		return nil, fmt.Errorf("Memory size '%s' must be greater than or equal to 0", quantity.String())
 	}
-	return Memory{
-		Value: uint64(memorySize),
+	return &libvirtxml.DomainMemory{
+		Value: uint(memorySize),
 		Unit:  "B",
 	}, nil
 }
@@ -744,9 +912,6 @@ func getResolvConfDetailsFromPod() ([][]byte, []string, error) {
 		return nil, nil, err
 	}
 
-	log.Log.Reason(err).Infof("Found nameservers in %s: %s", resolvConf, bytes.Join(nameservers, []byte{' '}))
-	log.Log.Reason(err).Infof("Found search domains in %s: %s", resolvConf, strings.Join(searchDomains, " "))
-
 	return nameservers, searchDomains, err
 }
 
@@ -0,0 +1,665 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// ReverseTranslation is the result of the translation of a libvirt domain back into the stage1 format
+type ReverseTranslation struct {
+	// Spec is the closest DomainSpec to the translated domain
+	Spec *k6tv1.DomainSpec
+	// Volumes backing the disks of Spec
+	Volumes []k6tv1.Volume
+	// Networks backing the interfaces of Spec
+	Networks []k6tv1.Network
+	// Unmapped lists the settings of the domain which have no KubeVirt equivalent, as XPath-like strings
+	Unmapped []string
+	// Leftover is a partial domain holding all the settings listed in Unmapped,
+	// suitable to seed a stage3 XML profile.
+	Leftover *libvirtxml.Domain
+}
+
+func (r *ReverseTranslation) unmapped(format string, args ...interface{}) {
+	r.Unmapped = append(r.Unmapped, fmt.Sprintf(format, args...))
+}
+
+// ReverseTranslateSpecs is the inverse of TranslateSpecs: translates a libvirt domain into the closest stage1 domain specification.
+func ReverseTranslateSpecs(dom *libvirtxml.Domain) (*ReverseTranslation, []string, error) {
+	c := &ConverterContext{
+		Warnings: []string{},
+	}
+	ret := &ReverseTranslation{
+		Spec:     &k6tv1.DomainSpec{},
+		Volumes:  []k6tv1.Volume{},
+		Networks: []k6tv1.Network{},
+		Unmapped: []string{},
+		Leftover: &libvirtxml.Domain{},
+	}
+	err := convert_api_Domain_To_v1_DomainSpec(dom, ret, c)
+	if err != nil {
+		return nil, c.Warnings, err
+	}
+	return ret, c.Warnings, nil
+}
+
+func convert_api_Domain_To_v1_DomainSpec(dom *libvirtxml.Domain, r *ReverseTranslation, c *ConverterContext) error {
+	spec := r.Spec
+	left := r.Leftover
+
+	if dom.Memory != nil {
+		size, err := domainMemoryToBytes(dom.Memory.Value, dom.Memory.Unit)
+		if err != nil {
+			return err
+		}
+		spec.Resources.Requests = k8sv1.ResourceList{
+			k8sv1.ResourceMemory: *k8sres.NewQuantity(int64(size), k8sres.BinarySI),
+		}
+	}
+
+	if dom.MemoryBacking != nil {
+		err := convert_api_MemoryBacking_To_v1_Memory(dom.MemoryBacking, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	convert_api_CPU_To_v1_CPU(dom, r)
+
+	if dom.OS != nil {
+		if dom.OS.Type != nil {
+			spec.Machine.Type = dom.OS.Type.Machine
+		}
+		if dom.OS.Loader != nil || dom.OS.NVRam != nil || len(dom.OS.BootDevices) > 0 || dom.OS.SMBios != nil {
+			left.OS = &libvirtxml.DomainOS{
+				Loader:      dom.OS.Loader,
+				NVRam:       dom.OS.NVRam,
+				BootDevices: dom.OS.BootDevices,
+				SMBios:      dom.OS.SMBios,
+			}
+			r.unmapped("os")
+		}
+	}
+
+	if dom.SysInfo != nil {
+		convert_api_SysInfo_To_v1_Firmware(dom.SysInfo, r)
+	}
+
+	if dom.Clock != nil {
+		spec.Clock = &k6tv1.Clock{}
+		convert_api_Clock_To_v1_Clock(dom.Clock, spec.Clock, r)
+	}
+
+	if dom.Features != nil {
+		spec.Features = &k6tv1.Features{}
+		convert_api_Features_To_v1_Features(dom.Features, spec.Features, r)
+	}
+
+	if dom.CPUTune != nil {
+		left.CPUTune = dom.CPUTune
+		r.unmapped("cputune")
+	}
+	if dom.NUMATune != nil {
+		left.NUMATune = dom.NUMATune
+		r.unmapped("numatune")
+	}
+	if dom.IOThreads > 0 {
+		left.IOThreads = dom.IOThreads
+		left.IOThreadIDs = dom.IOThreadIDs
+		r.unmapped("iothreads")
+	}
+	if dom.QEMUCommandline != nil {
+		left.QEMUCommandline = dom.QEMUCommandline
+		r.unmapped("qemu:commandline")
+	}
+
+	if dom.Devices != nil {
+		return convert_api_Devices_To_v1_Devices(dom.Devices, r, c)
+	}
+	return nil
+}
+
+func convert_api_MemoryBacking_To_v1_Memory(backing *libvirtxml.DomainMemoryBacking, r *ReverseTranslation) error {
+	if backing.MemoryHugePages != nil {
+		r.Spec.Memory = &k6tv1.Memory{
+			Hugepages: &k6tv1.Hugepages{},
+		}
+		pages := backing.MemoryHugePages.Hugepages
+		if len(pages) > 0 {
+			size, err := domainMemoryToBytes(pages[0].Size, pages[0].Unit)
+			if err != nil {
+				return err
+			}
+			r.Spec.Memory.Hugepages.PageSize = k8sres.NewQuantity(int64(size), k8sres.BinarySI).String()
+		}
+		if len(pages) > 1 || (len(pages) == 1 && pages[0].Nodeset != "") {
+			r.Leftover.MemoryBacking = &libvirtxml.DomainMemoryBacking{
+				MemoryHugePages: backing.MemoryHugePages,
+			}
+			r.unmapped("memoryBacking/hugepages/page")
+		}
+	}
+	if backing.MemoryNosharepages != nil || backing.MemoryLocked != nil || backing.MemorySource != nil ||
+		backing.MemoryAccess != nil || backing.MemoryAllocation != nil {
+		if r.Leftover.MemoryBacking == nil {
+			r.Leftover.MemoryBacking = &libvirtxml.DomainMemoryBacking{}
+		}
+		r.Leftover.MemoryBacking.MemoryNosharepages = backing.MemoryNosharepages
+		r.Leftover.MemoryBacking.MemoryLocked = backing.MemoryLocked
+		r.Leftover.MemoryBacking.MemorySource = backing.MemorySource
+		r.Leftover.MemoryBacking.MemoryAccess = backing.MemoryAccess
+		r.Leftover.MemoryBacking.MemoryAllocation = backing.MemoryAllocation
+		r.unmapped("memoryBacking")
+	}
+	return nil
+}
+
+func convert_api_CPU_To_v1_CPU(dom *libvirtxml.Domain, r *ReverseTranslation) {
+	cores := 0
+	if dom.VCPU != nil {
+		cores = dom.VCPU.Value
+	}
+	if dom.CPU != nil && dom.CPU.Topology != nil {
+		topo := dom.CPU.Topology
+		if topo.Sockets > 1 || topo.Threads > 1 {
+			r.Leftover.CPU = &libvirtxml.DomainCPU{
+				Topology: topo,
+			}
+			r.unmapped("cpu/topology")
+		}
+		if cores == 0 {
+			cores = topo.Sockets * topo.Cores * topo.Threads
+		}
+	}
+
+	model := ""
+	if dom.CPU != nil {
+		switch dom.CPU.Mode {
+		case CPUModeHostModel, CPUModeHostPassthrough:
+			model = dom.CPU.Mode
+		default:
+			if dom.CPU.Model != nil {
+				model = dom.CPU.Model.Value
+			}
+		}
+		if len(dom.CPU.Features) > 0 || dom.CPU.Numa != nil {
+			if r.Leftover.CPU == nil {
+				r.Leftover.CPU = &libvirtxml.DomainCPU{}
+			}
+			r.Leftover.CPU.Features = dom.CPU.Features
+			r.Leftover.CPU.Numa = dom.CPU.Numa
+			r.unmapped("cpu/feature")
+		}
+	}
+
+	if cores > 0 || model != "" {
+		r.Spec.CPU = &k6tv1.CPU{
+			Cores: uint32(cores),
+			Model: model,
+		}
+	}
+}
+
+func convert_api_SysInfo_To_v1_Firmware(sysInfo *libvirtxml.DomainSysInfo, r *ReverseTranslation) {
+	if sysInfo.System == nil {
+		return
+	}
+	for _, entry := range sysInfo.System.Entry {
+		if entry.Name == "uuid" {
+			r.Spec.Firmware = &k6tv1.Firmware{
+				UUID: types.UID(entry.Value),
+			}
+		} else {
+			r.unmapped("sysinfo/system/entry[@name='%s']", entry.Name)
+			r.Leftover.SysInfo = sysInfo
+		}
+	}
+}
+
+func convert_api_Clock_To_v1_Clock(source *libvirtxml.DomainClock, clock *k6tv1.Clock, r *ReverseTranslation) {
+	switch source.Offset {
+	case "utc":
+		clock.UTC = &k6tv1.ClockOffsetUTC{}
+		if offset, err := strconv.Atoi(source.Adjustment); err == nil {
+			clock.UTC.OffsetSeconds = &offset
+		}
+	case "timezone":
+		timezone := k6tv1.ClockOffsetTimezone(source.TimeZone)
+		clock.Timezone = &timezone
+	default:
+		r.Leftover.Clock = &libvirtxml.DomainClock{
+			Offset:     source.Offset,
+			Basis:      source.Basis,
+			Adjustment: source.Adjustment,
+			TimeZone:   source.TimeZone,
+		}
+		r.unmapped("clock/@offset")
+	}
+
+	for _, timer := range source.Timer {
+		enabled := timer.Present != "no"
+		switch timer.Name {
+		case "rtc":
+			if clock.Timer == nil {
+				clock.Timer = &k6tv1.Timer{}
+			}
+			clock.Timer.RTC = &k6tv1.RTCTimer{
+				TickPolicy: k6tv1.RTCTickPolicy(timer.TickPolicy),
+				Track:      k6tv1.RTCTimerTrack(timer.Track),
+				Enabled:    &enabled,
+			}
+		case "pit":
+			if clock.Timer == nil {
+				clock.Timer = &k6tv1.Timer{}
+			}
+			clock.Timer.PIT = &k6tv1.PITTimer{
+				TickPolicy: k6tv1.PITTickPolicy(timer.TickPolicy),
+				Enabled:    &enabled,
+			}
+		case "kvmclock":
+			if clock.Timer == nil {
+				clock.Timer = &k6tv1.Timer{}
+			}
+			clock.Timer.KVM = &k6tv1.KVMTimer{
+				Enabled: &enabled,
+			}
+		case "hpet":
+			if clock.Timer == nil {
+				clock.Timer = &k6tv1.Timer{}
+			}
+			clock.Timer.HPET = &k6tv1.HPETTimer{
+				TickPolicy: k6tv1.HPETTickPolicy(timer.TickPolicy),
+				Enabled:    &enabled,
+			}
+		case "hypervclock":
+			if clock.Timer == nil {
+				clock.Timer = &k6tv1.Timer{}
+			}
+			clock.Timer.Hyperv = &k6tv1.HypervTimer{
+				Enabled: &enabled,
+			}
+		default:
+			if r.Leftover.Clock == nil {
+				r.Leftover.Clock = &libvirtxml.DomainClock{}
+			}
+			r.Leftover.Clock.Timer = append(r.Leftover.Clock.Timer, timer)
+			r.unmapped("clock/timer[@name='%s']", timer.Name)
+		}
+	}
+}
+
+func convertDomainFeatureState(source *libvirtxml.DomainFeatureState) *k6tv1.FeatureState {
+	if source == nil {
+		return nil
+	}
+	enabled := source.State != "off"
+	return &k6tv1.FeatureState{
+		Enabled: &enabled,
+	}
+}
+
+func convert_api_Features_To_v1_Features(source *libvirtxml.DomainFeatureList, features *k6tv1.Features, r *ReverseTranslation) {
+	disabled := false
+	if source.ACPI == nil {
+		features.ACPI.Enabled = &disabled
+	}
+	if source.APIC != nil {
+		features.APIC = &k6tv1.FeatureAPIC{}
+	}
+	if source.HyperV != nil {
+		hyperv := source.HyperV
+		features.Hyperv = &k6tv1.FeatureHyperv{
+			Relaxed:    convertDomainFeatureState(hyperv.Relaxed),
+			VAPIC:      convertDomainFeatureState(hyperv.VAPIC),
+			VPIndex:    convertDomainFeatureState(hyperv.VPIndex),
+			Runtime:    convertDomainFeatureState(hyperv.Runtime),
+			SyNIC:      convertDomainFeatureState(hyperv.Synic),
+			SyNICTimer: convertDomainFeatureState(hyperv.STimer),
+			Reset:      convertDomainFeatureState(hyperv.Reset),
+
+			Frequencies:     convertDomainFeatureState(hyperv.Frequencies),
+			Reenlightenment: convertDomainFeatureState(hyperv.ReEnlightenment),
+			TLBFlush:        convertDomainFeatureState(hyperv.TLBFlush),
+			IPI:             convertDomainFeatureState(hyperv.IPI),
+			EVMCS:           convertDomainFeatureState(hyperv.EVMCS),
+		}
+		if hyperv.Spinlocks != nil {
+			enabled := hyperv.Spinlocks.State != "off"
+			retries := uint32(hyperv.Spinlocks.Retries)
+			features.Hyperv.Spinlocks = &k6tv1.FeatureSpinlocks{
+				Enabled: &enabled,
+				Retries: &retries,
+			}
+		}
+		if hyperv.VendorId != nil {
+			enabled := hyperv.VendorId.State != "off"
+			features.Hyperv.VendorID = &k6tv1.FeatureVendorID{
+				Enabled:  &enabled,
+				VendorID: hyperv.VendorId.Value,
+			}
+		}
+	}
+
+	// everything else has no KubeVirt equivalent
+	left := &libvirtxml.DomainFeatureList{
+		PAE:        source.PAE,
+		HAP:        source.HAP,
+		Viridian:   source.Viridian,
+		PrivNet:    source.PrivNet,
+		KVM:        source.KVM,
+		PVSpinlock: source.PVSpinlock,
+		PMU:        source.PMU,
+		VMPort:     source.VMPort,
+		GIC:        source.GIC,
+		SMM:        source.SMM,
+		IOAPIC:     source.IOAPIC,
+		HPT:        source.HPT,
+	}
+	if source.APIC != nil && source.APIC.EOI != "" {
+		left.APIC = &libvirtxml.DomainFeatureAPIC{
+			EOI: source.APIC.EOI,
+		}
+		r.unmapped("features/apic/@eoi")
+	}
+	if !reflect.DeepEqual(left, &libvirtxml.DomainFeatureList{}) {
+		r.Leftover.Features = left
+		r.unmapped("features")
+	}
+}
+
+func convert_api_Devices_To_v1_Devices(devices *libvirtxml.DomainDeviceList, r *ReverseTranslation, c *ConverterContext) error {
+	left := &libvirtxml.DomainDeviceList{}
+
+	for i := range devices.Disks {
+		err := convert_api_Disk_To_v1_Disk(&devices.Disks[i], r, left, c)
+		if err != nil {
+			return fmt.Errorf("disk %d: %v", i, err)
+		}
+	}
+
+	for i := range devices.Interfaces {
+		convert_api_Interface_To_v1_Interface(&devices.Interfaces[i], r, left, c)
+	}
+
+	if devices.Watchdog != nil {
+		if devices.Watchdog.Model == "i6300esb" {
+			r.Spec.Devices.Watchdog = &k6tv1.Watchdog{
+				Name: deviceAliasName(devices.Watchdog.Alias, "watchdog"),
+				WatchdogDevice: k6tv1.WatchdogDevice{
+					I6300ESB: &k6tv1.I6300ESBWatchdog{
+						Action: k6tv1.WatchdogAction(devices.Watchdog.Action),
+					},
+				},
+			}
+		} else {
+			left.Watchdog = devices.Watchdog
+			r.unmapped("devices/watchdog[@model='%s']", devices.Watchdog.Model)
+		}
+	}
+
+	if len(devices.Graphics) == 0 {
+		autoattach := false
+		r.Spec.Devices.AutoattachGraphicsDevice = &autoattach
+	} else {
+		// KubeVirt always uses VNC with a VGA device, anything else needs a profile
+		left.Graphics = devices.Graphics
+		left.Videos = devices.Videos
+		r.unmapped("devices/graphics")
+	}
+
+	// consoles and serials are always created by the translation, the rest has no KubeVirt equivalent
+	left.Controllers = devices.Controllers
+	left.Channels = devices.Channels
+	left.Inputs = devices.Inputs
+	left.Hostdevs = devices.Hostdevs
+	left.RNGs = devices.RNGs
+	left.MemBalloon = devices.MemBalloon
+	left.Sounds = devices.Sounds
+	left.Filesystems = devices.Filesystems
+	left.RedirDevs = devices.RedirDevs
+	left.Smartcards = devices.Smartcards
+	left.TPMs = devices.TPMs
+	left.Panics = devices.Panics
+	left.Memorydevs = devices.Memorydevs
+	leftovers := []struct {
+		name  string
+		count int
+	}{
+		{"controller", len(left.Controllers)},
+		{"channel", len(left.Channels)},
+		{"input", len(left.Inputs)},
+		{"hostdev", len(left.Hostdevs)},
+		{"rng", len(left.RNGs)},
+		{"memballoon", boolToCount(left.MemBalloon != nil)},
+		{"sound", len(left.Sounds)},
+		{"filesystem", len(left.Filesystems)},
+		{"redirdev", len(left.RedirDevs)},
+		{"smartcard", len(left.Smartcards)},
+		{"tpm", len(left.TPMs)},
+		{"panic", len(left.Panics)},
+		{"memory", len(left.Memorydevs)},
+	}
+	for _, leftover := range leftovers {
+		if leftover.count > 0 {
+			r.unmapped("devices/%s", leftover.name)
+		}
+	}
+
+	r.Leftover.Devices = left
+	return nil
+}
+
+func convert_api_Disk_To_v1_Disk(source *libvirtxml.DomainDisk, r *ReverseTranslation, left *libvirtxml.DomainDeviceList, c *ConverterContext) error {
+	if source.Target == nil {
+		return fmt.Errorf("missing target")
+	}
+	name := deviceAliasName(source.Alias, source.Target.Dev)
+	readOnly := source.ReadOnly != nil
+
+	disk := k6tv1.Disk{
+		Name: name,
+	}
+	switch source.Device {
+	case "", "disk":
+		disk.Disk = &k6tv1.DiskTarget{
+			Bus:      source.Target.Bus,
+			ReadOnly: readOnly,
+		}
+	case "lun":
+		disk.LUN = &k6tv1.LunTarget{
+			Bus:      source.Target.Bus,
+			ReadOnly: readOnly,
+		}
+	case "cdrom":
+		disk.CDRom = &k6tv1.CDRomTarget{
+			Bus:      source.Target.Bus,
+			ReadOnly: &readOnly,
+			Tray:     k6tv1.TrayState(source.Target.Tray),
+		}
+	case "floppy":
+		disk.Floppy = &k6tv1.FloppyTarget{
+			ReadOnly: readOnly,
+			Tray:     k6tv1.TrayState(source.Target.Tray),
+		}
+	default:
+		c.warn("disk %s: unsupported device %s, skipped", name, source.Device)
+		left.Disks = append(left.Disks, *source)
+		r.unmapped("devices/disk[@device='%s']", source.Device)
+		return nil
+	}
+	if source.Boot != nil {
+		order := source.Boot.Order
+		disk.BootOrder = &order
+	}
+
+	// the disk images must be imported in the cluster, so the closest volume
+	// is a PVC named after the image.
+	path := ""
+	if source.Source != nil {
+		if source.Source.File != nil {
+			path = source.Source.File.File
+		} else if source.Source.Block != nil {
+			path = source.Source.Block.Dev
+		}
+	}
+	if path == "" {
+		c.warn("disk %s: only file and block sources can be translated, skipped", name)
+		left.Disks = append(left.Disks, *source)
+		r.unmapped("devices/disk[@dev='%s']/source", source.Target.Dev)
+		return nil
+	}
+	claimName := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	r.Volumes = append(r.Volumes, k6tv1.Volume{
+		Name: name,
+		VolumeSource: k6tv1.VolumeSource{
+			PersistentVolumeClaim: &k8sv1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName,
+				ReadOnly:  readOnly,
+			},
+		},
+	})
+	r.unmapped("devices/disk[@dev='%s']/source: import %s into the PVC %s", source.Target.Dev, path, claimName)
+	r.Spec.Devices.Disks = append(r.Spec.Devices.Disks, disk)
+
+	// the leftover disk is named like the translated one, so that the XML profile matches it
+	leftover := libvirtxml.DomainDisk{
+		Device: source.Device,
+		Target: &libvirtxml.DomainDiskTarget{
+			Dev: source.Target.Dev,
+		},
+		Alias: &libvirtxml.DomainAlias{
+			Name: name,
+		},
+	}
+	if source.Driver != nil {
+		driver := *source.Driver
+		driver.Name = ""
+		driver.Type = ""
+		if !reflect.DeepEqual(driver, libvirtxml.DomainDiskDriver{}) {
+			leftover.Driver = &driver
+			r.unmapped("devices/disk[@dev='%s']/driver", source.Target.Dev)
+		}
+	}
+	if source.IOTune != nil {
+		leftover.IOTune = source.IOTune
+		r.unmapped("devices/disk[@dev='%s']/iotune", source.Target.Dev)
+	}
+	if leftover.Driver != nil || leftover.IOTune != nil {
+		left.Disks = append(left.Disks, leftover)
+	}
+	return nil
+}
+
+func convert_api_Interface_To_v1_Interface(source *libvirtxml.DomainInterface, r *ReverseTranslation, left *libvirtxml.DomainDeviceList, c *ConverterContext) {
+	name := deviceAliasName(source.Alias, fmt.Sprintf("net%d", len(r.Spec.Devices.Interfaces)))
+	iface := k6tv1.Interface{
+		Name: name,
+	}
+	if source.Model != nil {
+		iface.Model = source.Model.Type
+	}
+	if source.MAC != nil {
+		iface.MacAddress = source.MAC.Address
+	}
+
+	if source.Source != nil && source.Source.Bridge != nil {
+		iface.Bridge = &k6tv1.InterfaceBridge{}
+	} else if source.Source != nil && source.Source.Network != nil {
+		// the pod network plays the role of the libvirt virtual network, which has no KubeVirt equivalent
+		iface.Bridge = &k6tv1.InterfaceBridge{}
+		r.unmapped("devices/interface[%s]/source[@network='%s']", name, source.Source.Network.Network)
+	} else if source.Source != nil && source.Source.User != nil {
+		iface.Slirp = &k6tv1.InterfaceSlirp{}
+	} else {
+		c.warn("interface %s: only bridge, network and user interfaces can be translated, skipped", name)
+		left.Interfaces = append(left.Interfaces, *source)
+		r.unmapped("devices/interface[%s]", name)
+		return
+	}
+
+	r.Spec.Devices.Interfaces = append(r.Spec.Devices.Interfaces, iface)
+	r.Networks = append(r.Networks, k6tv1.Network{
+		Name: name,
+		NetworkSource: k6tv1.NetworkSource{
+			Pod: &k6tv1.PodNetwork{},
+		},
+	})
+	if source.Driver != nil || source.MTU != nil || source.Tune != nil {
+		left.Interfaces = append(left.Interfaces, libvirtxml.DomainInterface{
+			Driver: source.Driver,
+			MTU:    source.MTU,
+			Tune:   source.Tune,
+			Alias: &libvirtxml.DomainAlias{
+				Name: name,
+			},
+		})
+		r.unmapped("devices/interface[%s]/driver", name)
+	}
+}
+
+func deviceAliasName(alias *libvirtxml.DomainAlias, fallback string) string {
+	if alias != nil && alias.Name != "" {
+		return strings.TrimPrefix(alias.Name, "ua-")
+	}
+	return fallback
+}
+
+func boolToCount(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// domainMemoryToBytes converts a libvirt memory size, expressed in the given unit, in bytes
+func domainMemoryToBytes(value uint, unit string) (uint64, error) {
+	mult := uint64(1)
+	switch unit {
+	case "b", "bytes", "B":
+		mult = 1
+	case "KB":
+		mult = 1000
+	case "", "k", "KiB":
+		mult = 1024
+	case "MB":
+		mult = 1000 * 1000
+	case "M", "MiB":
+		mult = 1024 * 1024
+	case "GB":
+		mult = 1000 * 1000 * 1000
+	case "G", "GiB":
+		mult = 1024 * 1024 * 1024
+	case "TB":
+		mult = 1000 * 1000 * 1000 * 1000
+	case "T", "TiB":
+		mult = 1024 * 1024 * 1024 * 1024
+	default:
+		return 0, fmt.Errorf("unknown memory unit: %s", unit)
+	}
+	return uint64(value) * mult, nil
+}
@@ -0,0 +1,399 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func reverseTranslate(t *testing.T, dom *libvirtxml.Domain) *ReverseTranslation {
+	r, _, err := ReverseTranslateSpecs(dom)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return r
+}
+
+func TestReverseTranslateDisks(t *testing.T) {
+	tests := []struct {
+		name         string
+		disk         libvirtxml.DomainDisk
+		wantName     string
+		wantClaim    string
+		wantLeftover bool
+	}{
+		{
+			name: "user alias",
+			disk: libvirtxml.DomainDisk{
+				Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+				Alias:  &libvirtxml.DomainAlias{Name: "ua-root"},
+				Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/images/fedora.qcow2"}},
+				Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2"},
+			},
+			wantName:  "root",
+			wantClaim: "fedora",
+		},
+		{
+			name: "named after the target",
+			disk: libvirtxml.DomainDisk{
+				Target: &libvirtxml.DomainDiskTarget{Dev: "vdb", Bus: "virtio"},
+				Source: &libvirtxml.DomainDiskSource{Block: &libvirtxml.DomainDiskSourceBlock{Dev: "/dev/sdc"}},
+			},
+			wantName:  "vdb",
+			wantClaim: "sdc",
+		},
+		{
+			name: "driver tuning",
+			disk: libvirtxml.DomainDisk{
+				Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+				Alias:  &libvirtxml.DomainAlias{Name: "ua-root"},
+				Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/images/root.img"}},
+				Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "raw", Cache: "none"},
+			},
+			wantName:     "root",
+			wantClaim:    "root",
+			wantLeftover: true,
+		},
+		{
+			name: "iotune",
+			disk: libvirtxml.DomainDisk{
+				Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+				Alias:  &libvirtxml.DomainAlias{Name: "ua-root"},
+				Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/images/root.img"}},
+				IOTune: &libvirtxml.DomainDiskIOTune{TotalIopsSec: 100},
+			},
+			wantName:     "root",
+			wantClaim:    "root",
+			wantLeftover: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := reverseTranslate(t, &libvirtxml.Domain{
+				Devices: &libvirtxml.DomainDeviceList{
+					Disks: []libvirtxml.DomainDisk{tt.disk},
+				},
+			})
+			if len(r.Spec.Devices.Disks) != 1 || len(r.Volumes) != 1 {
+				t.Fatalf("got %d disks and %d volumes, want one each", len(r.Spec.Devices.Disks), len(r.Volumes))
+			}
+			if got := r.Spec.Devices.Disks[0].Name; got != tt.wantName {
+				t.Errorf("got disk %s, want %s", got, tt.wantName)
+			}
+			if got := r.Volumes[0].PersistentVolumeClaim.ClaimName; got != tt.wantClaim {
+				t.Errorf("got claim %s, want %s", got, tt.wantClaim)
+			}
+
+			left := r.Leftover.Devices.Disks
+			if !tt.wantLeftover {
+				if len(left) != 0 {
+					t.Errorf("unexpected leftover disks: %v", left)
+				}
+				return
+			}
+			if len(left) != 1 {
+				t.Fatalf("got %d leftover disks, want 1", len(left))
+			}
+			// the leftover disk must match the translated one in the stage3
+			if left[0].Alias == nil || left[0].Alias.Name != tt.wantName {
+				t.Errorf("got leftover alias %v, want %s", left[0].Alias, tt.wantName)
+			}
+			if left[0].Driver != nil && (left[0].Driver.Name != "" || left[0].Driver.Type != "") {
+				t.Errorf("the leftover driver keeps the translated name and type: %+v", left[0].Driver)
+			}
+			if tt.disk.IOTune != nil && left[0].IOTune != tt.disk.IOTune {
+				t.Errorf("missing the leftover iotune")
+			}
+		})
+	}
+}
+
+func TestReverseTranslateInterfaces(t *testing.T) {
+	mtu := &libvirtxml.DomainInterfaceMTU{Size: 9000}
+	r := reverseTranslate(t, &libvirtxml.Domain{
+		Devices: &libvirtxml.DomainDeviceList{
+			Interfaces: []libvirtxml.DomainInterface{
+				{
+					Alias:  &libvirtxml.DomainAlias{Name: "ua-default"},
+					Source: &libvirtxml.DomainInterfaceSource{Bridge: &libvirtxml.DomainInterfaceSourceBridge{Bridge: "br0"}},
+					MTU:    mtu,
+				},
+				{
+					Source: &libvirtxml.DomainInterfaceSource{Network: &libvirtxml.DomainInterfaceSourceNetwork{Network: "default"}},
+				},
+			},
+		},
+	})
+	ifaces := r.Spec.Devices.Interfaces
+	if len(ifaces) != 2 || ifaces[0].Name != "default" || ifaces[1].Name != "net1" {
+		t.Fatalf("got interfaces %v, want default and net1", ifaces)
+	}
+	for _, iface := range ifaces {
+		if iface.Bridge == nil {
+			t.Errorf("interface %s: got %+v, want the bridge binding", iface.Name, iface.InterfaceBindingMethod)
+		}
+	}
+	if len(r.Networks) != 2 || r.Networks[1].Name != "net1" || r.Networks[1].Pod == nil {
+		t.Errorf("got networks %v, want net1 on the pod network", r.Networks)
+	}
+	if !containsString(r.Unmapped, "devices/interface[net1]/source[@network='default']") {
+		t.Errorf("got unmapped %v, want the libvirt network name", r.Unmapped)
+	}
+	left := r.Leftover.Devices.Interfaces
+	if len(left) != 1 {
+		t.Fatalf("got %d leftover interfaces, want 1", len(left))
+	}
+	if left[0].Alias == nil || left[0].Alias.Name != "default" || left[0].MTU != mtu {
+		t.Errorf("got leftover interface %+v, want the MTU of default", left[0])
+	}
+}
+
+func TestReverseTranslateHugepages(t *testing.T) {
+	tests := []struct {
+		name         string
+		pages        []libvirtxml.DomainMemoryHugepage
+		wantPageSize string
+		wantLeftover bool
+	}{
+		{
+			name:         "single size",
+			pages:        []libvirtxml.DomainMemoryHugepage{{Size: 2048, Unit: "KiB"}},
+			wantPageSize: "2Mi",
+		},
+		{
+			name:         "per node size",
+			pages:        []libvirtxml.DomainMemoryHugepage{{Size: 1, Unit: "G", Nodeset: "0"}},
+			wantPageSize: "1Gi",
+			wantLeftover: true,
+		},
+		{
+			name: "multiple sizes",
+			pages: []libvirtxml.DomainMemoryHugepage{
+				{Size: 1, Unit: "G", Nodeset: "0"},
+				{Size: 2, Unit: "M", Nodeset: "1"},
+			},
+			wantPageSize: "1Gi",
+			wantLeftover: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := reverseTranslate(t, &libvirtxml.Domain{
+				MemoryBacking: &libvirtxml.DomainMemoryBacking{
+					MemoryHugePages: &libvirtxml.DomainMemoryHugepages{Hugepages: tt.pages},
+				},
+			})
+			if r.Spec.Memory == nil || r.Spec.Memory.Hugepages == nil {
+				t.Fatalf("missing the hugepages")
+			}
+			if got := r.Spec.Memory.Hugepages.PageSize; got != tt.wantPageSize {
+				t.Errorf("got page size %s, want %s", got, tt.wantPageSize)
+			}
+			gotLeftover := r.Leftover.MemoryBacking != nil && r.Leftover.MemoryBacking.MemoryHugePages != nil
+			if gotLeftover != tt.wantLeftover {
+				t.Errorf("got leftover hugepages: %v, want %v", gotLeftover, tt.wantLeftover)
+			}
+		})
+	}
+}
+
+func TestReverseTranslateClock(t *testing.T) {
+	tests := []struct {
+		name         string
+		clock        libvirtxml.DomainClock
+		wantUTC      bool
+		wantOffset   int
+		wantTimezone string
+		wantLeftover string
+	}{
+		{
+			name:    "utc",
+			clock:   libvirtxml.DomainClock{Offset: "utc"},
+			wantUTC: true,
+		},
+		{
+			name:       "utc with adjustment",
+			clock:      libvirtxml.DomainClock{Offset: "utc", Adjustment: "3600"},
+			wantUTC:    true,
+			wantOffset: 3600,
+		},
+		{
+			name:         "timezone",
+			clock:        libvirtxml.DomainClock{Offset: "timezone", TimeZone: "Europe/Rome"},
+			wantTimezone: "Europe/Rome",
+		},
+		{
+			name:         "localtime",
+			clock:        libvirtxml.DomainClock{Offset: "localtime"},
+			wantLeftover: "localtime",
+		},
+		{
+			name:         "variable",
+			clock:        libvirtxml.DomainClock{Offset: "variable", Basis: "utc", Adjustment: "-60"},
+			wantLeftover: "variable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := reverseTranslate(t, &libvirtxml.Domain{Clock: &tt.clock})
+			clock := r.Spec.Clock
+			if got := clock.UTC != nil; got != tt.wantUTC {
+				t.Fatalf("got UTC offset: %v, want %v", got, tt.wantUTC)
+			}
+			if tt.wantOffset != 0 && (clock.UTC.OffsetSeconds == nil || *clock.UTC.OffsetSeconds != tt.wantOffset) {
+				t.Errorf("got offset %v, want %d", clock.UTC.OffsetSeconds, tt.wantOffset)
+			}
+			if tt.wantTimezone != "" && (clock.Timezone == nil || string(*clock.Timezone) != tt.wantTimezone) {
+				t.Errorf("got timezone %v, want %s", clock.Timezone, tt.wantTimezone)
+			}
+			if tt.wantLeftover == "" {
+				if r.Leftover.Clock != nil {
+					t.Errorf("unexpected leftover clock %+v", r.Leftover.Clock)
+				}
+				return
+			}
+			left := r.Leftover.Clock
+			if left == nil || left.Offset != tt.wantLeftover || left.Basis != tt.clock.Basis || left.Adjustment != tt.clock.Adjustment {
+				t.Errorf("got leftover clock %+v, want the %s offset", left, tt.wantLeftover)
+			}
+		})
+	}
+}
+
+func TestReverseTranslateClockTimers(t *testing.T) {
+	r := reverseTranslate(t, &libvirtxml.Domain{
+		Clock: &libvirtxml.DomainClock{
+			Offset: "utc",
+			Timer: []libvirtxml.DomainTimer{
+				{Name: "hpet", Present: "no"},
+				{Name: "rtc", TickPolicy: "catchup"},
+				{Name: "tsc", Frequency: 1000000000},
+			},
+		},
+	})
+	timer := r.Spec.Clock.Timer
+	if timer == nil || timer.HPET == nil || *timer.HPET.Enabled {
+		t.Errorf("expected the hpet timer disabled, got %+v", timer)
+	}
+	if timer == nil || timer.RTC == nil || timer.RTC.TickPolicy != "catchup" || !*timer.RTC.Enabled {
+		t.Errorf("expected the rtc timer with the catchup policy, got %+v", timer)
+	}
+	left := r.Leftover.Clock
+	if left == nil || len(left.Timer) != 1 || left.Timer[0].Name != "tsc" {
+		t.Errorf("expected the tsc timer in the leftover, got %+v", left)
+	}
+}
+
+func TestReverseTranslateFeatures(t *testing.T) {
+	on := &libvirtxml.DomainFeatureState{State: "on"}
+	off := &libvirtxml.DomainFeatureState{State: "off"}
+	r := reverseTranslate(t, &libvirtxml.Domain{
+		Features: &libvirtxml.DomainFeatureList{
+			ACPI: &libvirtxml.DomainFeature{},
+			APIC: &libvirtxml.DomainFeatureAPIC{EOI: "on"},
+			HyperV: &libvirtxml.DomainFeatureHyperV{
+				Relaxed:         on,
+				VAPIC:           off,
+				Frequencies:     on,
+				ReEnlightenment: on,
+				TLBFlush:        on,
+				IPI:             off,
+				EVMCS:           on,
+			},
+		},
+	})
+
+	features := r.Spec.Features
+	if features.APIC == nil {
+		t.Errorf("missing the APIC")
+	}
+	hyperv := features.Hyperv
+	if hyperv == nil {
+		t.Fatalf("missing the Hyper-V enlightenments")
+	}
+	yes, no := true, false
+	tests := []struct {
+		name  string
+		state *k6tv1.FeatureState
+		want  *bool
+	}{
+		{"relaxed", hyperv.Relaxed, &yes},
+		{"vapic", hyperv.VAPIC, &no},
+		{"runtime", hyperv.Runtime, nil},
+		{"frequencies", hyperv.Frequencies, &yes},
+		{"reenlightenment", hyperv.Reenlightenment, &yes},
+		{"tlbflush", hyperv.TLBFlush, &yes},
+		{"ipi", hyperv.IPI, &no},
+		{"evmcs", hyperv.EVMCS, &yes},
+	}
+	for _, tt := range tests {
+		if tt.want == nil {
+			if tt.state != nil {
+				t.Errorf("%s: unexpected state %+v", tt.name, tt.state)
+			}
+			continue
+		}
+		if tt.state == nil || tt.state.Enabled == nil || *tt.state.Enabled != *tt.want {
+			t.Errorf("%s: got %+v, want enabled: %v", tt.name, tt.state, *tt.want)
+		}
+	}
+
+	left := r.Leftover.Features
+	if left == nil || left.APIC == nil || left.APIC.EOI != "on" {
+		t.Errorf("expected the APIC EOI in the leftover, got %+v", left)
+	}
+}
+
+func TestDomainMemoryToBytes(t *testing.T) {
+	tests := []struct {
+		value   uint
+		unit    string
+		want    uint64
+		wantErr bool
+	}{
+		{value: 1024, unit: "", want: 1024 * 1024},
+		{value: 1024, unit: "KiB", want: 1024 * 1024},
+		{value: 512, unit: "b", want: 512},
+		{value: 2, unit: "MB", want: 2000 * 1000},
+		{value: 2, unit: "MiB", want: 2 * 1024 * 1024},
+		{value: 1, unit: "G", want: 1024 * 1024 * 1024},
+		{value: 1, unit: "TB", want: 1000 * 1000 * 1000 * 1000},
+		{value: 1, unit: "PiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := domainMemoryToBytes(tt.value, tt.unit)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%d %s: expected an error", tt.value, tt.unit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d %s: unexpected error: %v", tt.value, tt.unit, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%d %s: got %d, want %d", tt.value, tt.unit, got, tt.want)
+		}
+	}
+}
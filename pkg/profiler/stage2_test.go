@@ -0,0 +1,168 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"strings"
+	"testing"
+
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+func TestFormatDeviceName(t *testing.T) {
+	tests := []struct {
+		prefix string
+		index  int
+		want   string
+	}{
+		{"vd", 0, "vda"},
+		{"sd", 25, "sdz"},
+		{"sd", 26, "sdaa"},
+		{"hd", 701, "hdzz"},
+		{"hd", 702, "hdaaa"},
+	}
+	for _, tt := range tests {
+		if got := formatDeviceName(tt.prefix, tt.index); got != tt.want {
+			t.Errorf("formatDeviceName(%s, %d): got %s, want %s", tt.prefix, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateSpecs(t *testing.T) {
+	vmi := newTestVMI()
+	p := newTestProfiler()
+	p.SetVirtualMachine(vmi)
+	dom, warnings, err := p.TranslateSpecs(&vmi.Spec.Domain)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+	if dom.Type != "kvm" || dom.Name != "default_testvmi" {
+		t.Errorf("got domain %s of type %s", dom.Name, dom.Type)
+	}
+	if dom.Memory == nil || dom.Memory.Value != 1024*1024*1024 || dom.Memory.Unit != "B" {
+		t.Errorf("got memory %+v, want 1Gi", dom.Memory)
+	}
+	if dom.CPU.Mode != CPUModeHostModel {
+		t.Errorf("got CPU mode %s, want %s", dom.CPU.Mode, CPUModeHostModel)
+	}
+	if len(dom.Devices.Disks) != 1 {
+		t.Fatalf("got %d disks, want 1", len(dom.Devices.Disks))
+	}
+	disk := dom.Devices.Disks[0]
+	if disk.Target.Dev != "vda" || disk.Alias.Name != "root" || disk.Driver.Type != "raw" {
+		t.Errorf("got disk %s (%s) of type %s", disk.Target.Dev, disk.Alias.Name, disk.Driver.Type)
+	}
+	if disk.Source == nil || disk.Source.File == nil {
+		t.Errorf("got disk source %+v, want a file", disk.Source)
+	}
+	if len(dom.Devices.Serials) != 1 || len(dom.Devices.Consoles) != 1 {
+		t.Errorf("got %d serials and %d consoles, want one each", len(dom.Devices.Serials), len(dom.Devices.Consoles))
+	}
+}
+
+func TestTranslateSpecsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(vmi *k6tv1.VirtualMachineInstance)
+		want   string
+	}{
+		{
+			name: "missing volume",
+			modify: func(vmi *k6tv1.VirtualMachineInstance) {
+				vmi.Spec.Volumes = nil
+			},
+			want: "No matching volume",
+		},
+		{
+			name: "missing network",
+			modify: func(vmi *k6tv1.VirtualMachineInstance) {
+				vmi.Spec.Domain.Devices.Interfaces = []k6tv1.Interface{{Name: "default"}}
+			},
+			want: "failed to find network default",
+		},
+		{
+			name: "LUN on a cloud-init volume",
+			modify: func(vmi *k6tv1.VirtualMachineInstance) {
+				vmi.Spec.Domain.Devices.Disks[0].DiskDevice = k6tv1.DiskDevice{LUN: &k6tv1.LunTarget{Bus: "scsi"}}
+				vmi.Spec.Volumes[0].VolumeSource = k6tv1.VolumeSource{CloudInitNoCloud: &k6tv1.CloudInitNoCloudSource{}}
+			},
+			want: "type lun",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vmi := newTestVMI()
+			tt.modify(vmi)
+			_, _, err := translateTestVMI(vmi)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateSlirpInterface(t *testing.T) {
+	tests := []struct {
+		model       string
+		wantModel   string
+		wantWarning bool
+	}{
+		{model: "e1000", wantModel: "e1000"},
+		{model: "rtl8139", wantModel: "rtl8139"},
+		{model: "virtio", wantModel: "e1000", wantWarning: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			vmi := newTestVMI()
+			vmi.Spec.Domain.Devices.Interfaces = []k6tv1.Interface{
+				{
+					Name:  "default",
+					Model: tt.model,
+					InterfaceBindingMethod: k6tv1.InterfaceBindingMethod{
+						Slirp: &k6tv1.InterfaceSlirp{},
+					},
+				},
+			}
+			vmi.Spec.Networks = []k6tv1.Network{
+				{
+					Name:          "default",
+					NetworkSource: k6tv1.NetworkSource{Pod: &k6tv1.PodNetwork{}},
+				},
+			}
+			dom, warnings, err := translateTestVMI(vmi)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := dom.Devices.Interfaces[0].Model.Type; got != tt.wantModel {
+				t.Errorf("got model %s, want %s", got, tt.wantModel)
+			}
+			if got := containsWarning(warnings, "changed to e1000"); got != tt.wantWarning {
+				t.Errorf("got warnings %v, want the model warning: %v", warnings, tt.wantWarning)
+			}
+			if dom.QEMUCommandline == nil || len(dom.QEMUCommandline.Args) != 2 {
+				t.Fatalf("missing the slirp netdev arguments")
+			}
+			if arg := dom.QEMUCommandline.Args[1].Value; !strings.HasPrefix(arg, "user,id=default") {
+				t.Errorf("got netdev %s", arg)
+			}
+		})
+	}
+}
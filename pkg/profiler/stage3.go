@@ -17,16 +17,164 @@
 package virtprofiles
 
 import (
+	"context"
+	"fmt"
+	"reflect"
+
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 )
 
 // ApplyProfiles applies all the given XML profiles to the stage3 domain specification
-func (p *Profiler) ApplyProfiles(domSpec *libvirtxml.Domain, profiles []string) (*libvirtxml.Domain, error) {
-	// Does'nt really apply them until we figure out how to implement XML profiles.
-	return domSpec, nil
+//
+// A XML profile is a partial libvirt domain document: every setting found in the profile
+// overrides the corresponding setting of the domain. Devices are matched using their alias,
+// or their target device if they have no alias; unmatched devices are added to the domain.
+// Other list elements, like the CPU features and the clock timers, are matched by name.
+func (p *Profiler) ApplyProfiles(domSpec *libvirtxml.Domain, profiles []string) (*libvirtxml.Domain, []string, error) {
+	return p.applyProfiles(context.Background(), domSpec, profiles)
+}
+
+// applyProfiles is ApplyProfiles, stopping before the next profile once the context is done
+func (p *Profiler) applyProfiles(ctx context.Context, domSpec *libvirtxml.Domain, profiles []string) (*libvirtxml.Domain, []string, error) {
+	warnings := []string{}
+	for idx, profile := range profiles {
+		if err := ctx.Err(); err != nil {
+			return nil, warnings, err
+		}
+		profDom := &libvirtxml.Domain{}
+		err := profDom.Unmarshal(profile)
+		if err != nil {
+			return nil, warnings, fmt.Errorf("malformed XML profile #%d: %v", idx, err)
+		}
+		mergeValue(reflect.ValueOf(domSpec).Elem(), reflect.ValueOf(profDom).Elem())
+	}
+	return domSpec, warnings, nil
+}
+
+// completer fills a group of backend settings of the domain, driven by the host and the profiles
+type completer func(dom *libvirtxml.Domain, c *ConverterContext) error
+
+var completers = []completer{
+	completeHostDevices,
+	completeCPUPinning,
+	completeNUMA,
+	completeHugepages,
+	completeFirmware,
+	completePCIAddresses,
 }
 
 // Complete fills the unspecified backend settings with optimal values
-func (p *Profiler) Complete(domSpec *libvirtxml.Domain) (*libvirtxml.Domain, error) {
-	return domSpec, nil
+func (p *Profiler) Complete(domSpec *libvirtxml.Domain) (*libvirtxml.Domain, []string, error) {
+	return p.complete(context.Background(), domSpec, "")
+}
+
+// CompleteWithHostCPUSet is like Complete, but pins the dedicated CPUs on the given host CPU set,
+// like "2-7,10", instead of the one of the Host set with SetHost.
+func (p *Profiler) CompleteWithHostCPUSet(domSpec *libvirtxml.Domain, hostCPUSet string) (*libvirtxml.Domain, []string, error) {
+	return p.complete(context.Background(), domSpec, hostCPUSet)
+}
+
+// complete is Complete, stopping before the next completer once the context is done
+func (p *Profiler) complete(ctx context.Context, domSpec *libvirtxml.Domain, hostCPUSet string) (*libvirtxml.Domain, []string, error) {
+	c := &ConverterContext{
+		VirtualMachine: p.virtualMachine,
+		UseEmulation:   p.useEmulation,
+		Host:           p.host,
+		Profile:        p.effectiveProfile(),
+		Warnings:       []string{},
+	}
+	ensureHost(c)
+	if hostCPUSet != "" {
+		host := *c.Host
+		host.CPUSet = hostCPUSet
+		c.Host = &host
+	}
+	for _, complete := range completers {
+		if err := ctx.Err(); err != nil {
+			return nil, c.Warnings, err
+		}
+		err := complete(domSpec, c)
+		if err != nil {
+			return nil, c.Warnings, err
+		}
+	}
+	return domSpec, c.Warnings, nil
+}
+
+// mergeValue merges all the non-zero settings of src into dst
+func mergeValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(src.Type().Elem()))
+		}
+		mergeValue(dst.Elem(), src.Elem())
+	case reflect.Struct:
+		for i := 0; i < src.NumField(); i++ {
+			field := src.Type().Field(i)
+			if field.PkgPath != "" || field.Name == "XMLName" {
+				continue
+			}
+			mergeValue(dst.Field(i), src.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < src.Len(); i++ {
+			elem := src.Index(i)
+			pos := findElement(dst, elem)
+			if pos == -1 {
+				dst.Set(reflect.Append(dst, elem))
+			} else {
+				mergeValue(dst.Index(pos), elem)
+			}
+		}
+	default:
+		if !isZeroValue(src) {
+			dst.Set(src)
+		}
+	}
+}
+
+// findElement returns the position in the slice of the element matching the given one, or -1.
+// Only elements with a key can be matched.
+func findElement(slice, elem reflect.Value) int {
+	key := elementKey(elem)
+	if key == "" {
+		return -1
+	}
+	for i := 0; i < slice.Len(); i++ {
+		if elementKey(slice.Index(i)) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// elementKey returns the value identifying an element of a list: its alias, or its target
+// device if unaliased, or its name, like for the CPU features and the clock timers.
+// Returns empty string if none is found.
+func elementKey(elem reflect.Value) string {
+	if elem.Kind() != reflect.Struct {
+		return ""
+	}
+	if alias := elem.FieldByName("Alias"); alias.IsValid() && alias.Kind() == reflect.Ptr && !alias.IsNil() {
+		if name := alias.Elem().FieldByName("Name"); name.IsValid() && name.String() != "" {
+			return "alias:" + name.String()
+		}
+	}
+	if target := elem.FieldByName("Target"); target.IsValid() && target.Kind() == reflect.Ptr && !target.IsNil() {
+		if dev := target.Elem().FieldByName("Dev"); dev.IsValid() && dev.Kind() == reflect.String && dev.String() != "" {
+			return "dev:" + dev.String()
+		}
+	}
+	if name := elem.FieldByName("Name"); name.IsValid() && name.Kind() == reflect.String && name.String() != "" {
+		return "name:" + name.String()
+	}
+	return ""
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
 }
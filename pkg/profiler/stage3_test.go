@@ -0,0 +1,169 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestApplyProfiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles []string
+		check    func(t *testing.T, dom *libvirtxml.Domain)
+	}{
+		{
+			name:     "scalar settings are overridden",
+			profiles: []string{`<domain><vcpu>2</vcpu></domain>`, `<domain><vcpu>4</vcpu></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				if dom.VCPU.Value != 4 {
+					t.Errorf("got %d vCPUs, want the 4 of the last profile", dom.VCPU.Value)
+				}
+				if dom.Name != "default_testvmi" || dom.CPU.Mode != CPUModeHostModel {
+					t.Errorf("settings missing in the profiles were changed")
+				}
+			},
+		},
+		{
+			name:     "disk matched by alias",
+			profiles: []string{`<domain><devices><disk><alias name="root"/><driver cache="none"/></disk></devices></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				if len(dom.Devices.Disks) != 2 {
+					t.Fatalf("got %d disks, want 2", len(dom.Devices.Disks))
+				}
+				driver := dom.Devices.Disks[0].Driver
+				if driver.Cache != "none" || driver.Type != "raw" {
+					t.Errorf("got driver %+v, want the cache merged in the raw driver", driver)
+				}
+			},
+		},
+		{
+			name:     "disk matched by target",
+			profiles: []string{`<domain><devices><disk><target dev="vdb"/><driver io="native"/></disk></devices></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				if len(dom.Devices.Disks) != 2 || dom.Devices.Disks[1].Driver.IO != "native" {
+					t.Errorf("got disks %+v, want the io mode merged in vdb", dom.Devices.Disks)
+				}
+			},
+		},
+		{
+			name:     "unmatched disk is added",
+			profiles: []string{`<domain><devices><disk device="cdrom"><target dev="sda" bus="sata"/></disk></devices></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				if len(dom.Devices.Disks) != 3 || dom.Devices.Disks[2].Target.Dev != "sda" {
+					t.Errorf("got disks %+v, want the cdrom added", dom.Devices.Disks)
+				}
+			},
+		},
+		{
+			name:     "timer matched by name",
+			profiles: []string{`<domain><clock><timer name="rtc" tickpolicy="delay"/></clock></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				timers := dom.Clock.Timer
+				if len(timers) != 1 || timers[0].TickPolicy != "delay" {
+					t.Errorf("got timers %+v, want only rtc with the delay policy", timers)
+				}
+			},
+		},
+		{
+			name:     "CPU feature matched by name",
+			profiles: []string{`<domain><cpu><feature policy="disable" name="vmx"/><feature policy="require" name="pdpe1gb"/></cpu></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				want := []libvirtxml.DomainCPUFeature{
+					{Policy: "disable", Name: "vmx"},
+					{Policy: "require", Name: "pdpe1gb"},
+				}
+				if !reflect.DeepEqual(dom.CPU.Features, want) {
+					t.Errorf("got CPU features %+v, want %+v", dom.CPU.Features, want)
+				}
+			},
+		},
+		{
+			name:     "profiles applied twice don't duplicate anything",
+			profiles: []string{`<domain><features><acpi/></features><clock><timer name="hpet" present="no"/></clock></domain>`, `<domain><features><acpi/></features><clock><timer name="hpet" present="no"/></clock></domain>`},
+			check: func(t *testing.T, dom *libvirtxml.Domain) {
+				if dom.Features == nil || dom.Features.ACPI == nil {
+					t.Errorf("missing the ACPI feature")
+				}
+				if len(dom.Clock.Timer) != 2 {
+					t.Errorf("got timers %+v, want rtc and hpet", dom.Clock.Timer)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dom, _, err := NewProfiler("").ApplyProfiles(newProfilesTestDomain(), tt.profiles)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, dom)
+		})
+	}
+}
+
+func TestApplyProfilesMalformed(t *testing.T) {
+	_, _, err := NewProfiler("").ApplyProfiles(newProfilesTestDomain(), []string{`<domain/>`, `<domain>`})
+	if err == nil || !strings.Contains(err.Error(), "malformed XML profile #1") {
+		t.Errorf("got error %v, want the malformed second profile", err)
+	}
+}
+
+func TestElementKey(t *testing.T) {
+	tests := []struct {
+		name string
+		elem interface{}
+		want string
+	}{
+		{
+			name: "alias first",
+			elem: libvirtxml.DomainDisk{
+				Alias:  &libvirtxml.DomainAlias{Name: "root"},
+				Target: &libvirtxml.DomainDiskTarget{Dev: "vda"},
+			},
+			want: "alias:root",
+		},
+		{
+			name: "target without alias",
+			elem: libvirtxml.DomainDisk{Target: &libvirtxml.DomainDiskTarget{Dev: "vda"}},
+			want: "dev:vda",
+		},
+		{
+			name: "name",
+			elem: libvirtxml.DomainTimer{Name: "rtc"},
+			want: "name:rtc",
+		},
+		{
+			name: "keyless",
+			elem: libvirtxml.DomainTimer{TickPolicy: "delay"},
+		},
+		{
+			name: "not a struct",
+			elem: "vda",
+		},
+	}
+	for _, tt := range tests {
+		if got := elementKey(reflect.ValueOf(tt.elem)); got != tt.want {
+			t.Errorf("%s: got key %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
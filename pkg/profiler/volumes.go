@@ -0,0 +1,174 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"fmt"
+	"path/filepath"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+// How the ConfigMap, Secret and ServiceAccount volumes are exposed to the guest
+const (
+	ConfigVolumesISO      = "iso"
+	ConfigVolumesVirtiofs = "virtiofs"
+)
+
+// findClaim looks up a claim registered with AddPersistentVolumeClaim, either by name or by namespace/name
+func (c *ConverterContext) findClaim(name string) (*k8sv1.PersistentVolumeClaim, bool) {
+	if claim, ok := c.Claims[name]; ok {
+		return claim, true
+	}
+	claim, ok := c.Claims[fmt.Sprintf("%s/%s", c.VirtualMachine.Namespace, name)]
+	return claim, ok
+}
+
+func (c *ConverterContext) configVolumesMode() string {
+	if c.Profile.Volumes == nil || c.Profile.Volumes.ConfigVolumes == "" {
+		return ConfigVolumesISO
+	}
+	return c.Profile.Volumes.ConfigVolumes
+}
+
+func isConfigVolume(volume *k6tv1.Volume) bool {
+	return volume.ConfigMap != nil || volume.Secret != nil || volume.ServiceAccount != nil
+}
+
+// convert_v1_PersistentVolumeClaim_To_api_Disk builds a block disk for the block mode claims, and a file disk
+// on the claim filesystem otherwise. Claims not registered are taken as filesystem ones.
+func convert_v1_PersistentVolumeClaim_To_api_Disk(volumeName, claimName string, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	if pv, ok := c.findISCSIVolume(claimName); ok {
+		return convert_v1_ISCSIPersistentVolumeSource_To_api_Disk(pv.Spec.ISCSI, disk, c)
+	}
+
+	claim, ok := c.findClaim(claimName)
+	if !ok || claim.Spec.VolumeMode == nil || *claim.Spec.VolumeMode != k8sv1.PersistentVolumeBlock {
+		return convert_v1_FilesystemVolumeSource_To_api_Disk(volumeName, disk, c)
+	}
+
+	devPath, err := c.Paths.BlockDevice(volumeName)
+	if err != nil {
+		return err
+	}
+	disk.Driver.Type = "raw"
+	disk.Source = &libvirtxml.DomainDiskSource{
+		Block: &libvirtxml.DomainDiskSourceBlock{
+			Dev: devPath,
+		},
+	}
+	return nil
+}
+
+// convert_v1_HostDisk_To_api_Disk uses a disk image found on the host. The images of the DiskOrCreate
+// disks are created by whoever starts the VM, before it runs.
+func convert_v1_HostDisk_To_api_Disk(source *k6tv1.HostDisk, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	if disk.Device == "lun" {
+		return fmt.Errorf("device %s is of type lun. Not compatible with a file based disk", disk.Alias.Name)
+	}
+	if !filepath.IsAbs(source.Path) {
+		return fmt.Errorf("disk %s: the host disk path must be absolute, found %s", disk.Alias.Name, source.Path)
+	}
+	if source.Type == k6tv1.HostDiskExistsOrCreate && source.Capacity.IsZero() {
+		return fmt.Errorf("disk %s: the host disk to create has no capacity", disk.Alias.Name)
+	}
+	disk.Driver.Type = "raw"
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: filepath.Clean(source.Path),
+		},
+	}
+	return nil
+}
+
+// convert_v1_ConfigVolume_To_api_Disk exposes a ConfigMap, Secret or ServiceAccount volume as a read-only ISO image
+func convert_v1_ConfigVolume_To_api_Disk(source *k6tv1.Volume, disk *libvirtxml.DomainDisk, c *ConverterContext) error {
+	if disk.Device == "lun" {
+		return fmt.Errorf("device %s is of type lun. Not compatible with a file based disk", disk.Alias.Name)
+	}
+	mode := c.configVolumesMode()
+	if mode != ConfigVolumesISO {
+		return fmt.Errorf("unknown mode %s for the config volumes", mode)
+	}
+
+	var isoPath string
+	var err error
+	switch {
+	case source.ConfigMap != nil:
+		isoPath, err = c.Paths.ConfigMapISO(source.Name)
+	case source.Secret != nil:
+		isoPath, err = c.Paths.SecretISO(source.Name)
+	default:
+		isoPath, err = c.Paths.ServiceAccountISO()
+	}
+	if err != nil {
+		return err
+	}
+	if disk.ReadOnly == nil {
+		c.warn("The disk %s was made read-only, its volume can't be written", disk.Alias.Name)
+		disk.ReadOnly = toApiReadOnly(true)
+	}
+	disk.Driver.Type = "raw"
+	disk.Source = &libvirtxml.DomainDiskSource{
+		File: &libvirtxml.DomainDiskSourceFile{
+			File: isoPath,
+		},
+	}
+	return nil
+}
+
+// convert_v1_ConfigVolume_To_api_Filesystem shares the directory of a ConfigMap, Secret or ServiceAccount volume
+// with virtiofs, tagged with the volume name. The guest must not write it, but virtiofs can't enforce it.
+func convert_v1_ConfigVolume_To_api_Filesystem(source *k6tv1.Volume, domain *libvirtxml.Domain, c *ConverterContext) error {
+	var dir string
+	var err error
+	switch {
+	case source.ConfigMap != nil:
+		dir, err = c.Paths.ConfigMapDir(source.Name)
+	case source.Secret != nil:
+		dir, err = c.Paths.SecretDir(source.Name)
+	default:
+		dir, err = c.Paths.ServiceAccountDir()
+	}
+	if err != nil {
+		return err
+	}
+
+	domain.Devices.Filesystems = append(domain.Devices.Filesystems, libvirtxml.DomainFilesystem{
+		AccessMode: "passthrough",
+		Driver: &libvirtxml.DomainFilesystemDriver{
+			Type: "virtiofs",
+		},
+		Source: &libvirtxml.DomainFilesystemSource{
+			Mount: &libvirtxml.DomainFilesystemSourceMount{
+				Dir: dir,
+			},
+		},
+		Target: &libvirtxml.DomainFilesystemTarget{
+			Dir: source.Name,
+		},
+		Alias: &libvirtxml.DomainAlias{Name: source.Name},
+	})
+
+	err = shareGuestMemory(domain)
+	if err != nil {
+		return fmt.Errorf("volume %s: virtiofs %v", source.Name, err)
+	}
+	return nil
+}
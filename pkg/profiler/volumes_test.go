@@ -0,0 +1,356 @@
+/*
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Copyright 2018 Red Hat, Inc.
+ */
+
+package virtprofiles
+
+import (
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	k8sv1 "k8s.io/api/core/v1"
+	k8sres "k8s.io/apimachinery/pkg/api/resource"
+	k6tv1 "kubevirt.io/kubevirt/pkg/api/v1"
+)
+
+const testVMIDir = testBaseDiskPath + "/default/testvmi"
+
+func TestConvertPersistentVolumeClaim(t *testing.T) {
+	tests := []struct {
+		name      string
+		claims    map[string]*k8sv1.PersistentVolumeClaim
+		wantFile  string
+		wantBlock string
+	}{
+		{
+			name:     "unknown claim",
+			wantFile: testVMIDir + "/disks/data.img",
+		},
+		{
+			name: "filesystem claim",
+			claims: map[string]*k8sv1.PersistentVolumeClaim{
+				"claim": newTestClaim("default", "claim", k8sv1.PersistentVolumeFilesystem),
+			},
+			wantFile: testVMIDir + "/disks/data.img",
+		},
+		{
+			name: "block claim",
+			claims: map[string]*k8sv1.PersistentVolumeClaim{
+				"claim": newTestClaim("default", "claim", k8sv1.PersistentVolumeBlock),
+			},
+			wantBlock: testVMIDir + "/block/data",
+		},
+		{
+			name: "block claim by namespace and name",
+			claims: map[string]*k8sv1.PersistentVolumeClaim{
+				"default/claim": newTestClaim("default", "claim", k8sv1.PersistentVolumeBlock),
+			},
+			wantBlock: testVMIDir + "/block/data",
+		},
+		{
+			name: "block claim in another namespace",
+			claims: map[string]*k8sv1.PersistentVolumeClaim{
+				"other/claim": newTestClaim("other", "claim", k8sv1.PersistentVolumeBlock),
+			},
+			wantFile: testVMIDir + "/disks/data.img",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestContext(nil)
+			c.Claims = tt.claims
+			disk := newTestDisk("data", "disk", "")
+			if err := convert_v1_PersistentVolumeClaim_To_api_Disk("data", "claim", disk, c); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if disk.Driver.Type != "raw" {
+				t.Errorf("got driver type %s, want raw", disk.Driver.Type)
+			}
+			file, block := "", ""
+			if disk.Source.File != nil {
+				file = disk.Source.File.File
+			}
+			if disk.Source.Block != nil {
+				block = disk.Source.Block.Dev
+			}
+			if file != tt.wantFile || block != tt.wantBlock {
+				t.Errorf("got file %q and block %q, want file %q and block %q", file, block, tt.wantFile, tt.wantBlock)
+			}
+		})
+	}
+}
+
+func TestConvertHostDisk(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   *k6tv1.HostDisk
+		device   string
+		wantFile string
+		wantErr  bool
+	}{
+		{
+			name:     "existing disk",
+			source:   &k6tv1.HostDisk{Path: "/images/data.img", Type: k6tv1.HostDiskExists},
+			device:   "disk",
+			wantFile: "/images/data.img",
+		},
+		{
+			name: "disk to create",
+			source: &k6tv1.HostDisk{
+				Path:     "/images//new/../data.img",
+				Type:     k6tv1.HostDiskExistsOrCreate,
+				Capacity: k8sres.MustParse("1Gi"),
+			},
+			device:   "disk",
+			wantFile: "/images/data.img",
+		},
+		{
+			name:    "disk to create without capacity",
+			source:  &k6tv1.HostDisk{Path: "/images/data.img", Type: k6tv1.HostDiskExistsOrCreate},
+			device:  "disk",
+			wantErr: true,
+		},
+		{
+			name:    "relative path",
+			source:  &k6tv1.HostDisk{Path: "images/data.img", Type: k6tv1.HostDiskExists},
+			device:  "disk",
+			wantErr: true,
+		},
+		{
+			name:    "lun",
+			source:  &k6tv1.HostDisk{Path: "/images/data.img", Type: k6tv1.HostDiskExists},
+			device:  "lun",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := newTestDisk("data", tt.device, "")
+			err := convert_v1_HostDisk_To_api_Disk(tt.source, disk, newTestContext(nil))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if disk.Source == nil || disk.Source.File == nil || disk.Source.File.File != tt.wantFile {
+				t.Errorf("got source %+v, want the file %s", disk.Source, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestConvertConfigVolumeToDisk(t *testing.T) {
+	configMap := k6tv1.Volume{Name: "config", VolumeSource: k6tv1.VolumeSource{ConfigMap: &k6tv1.ConfigMapVolumeSource{}}}
+	secret := k6tv1.Volume{Name: "creds", VolumeSource: k6tv1.VolumeSource{Secret: &k6tv1.SecretVolumeSource{}}}
+	serviceAccount := k6tv1.Volume{Name: "sa", VolumeSource: k6tv1.VolumeSource{ServiceAccount: &k6tv1.ServiceAccountVolumeSource{}}}
+
+	tests := []struct {
+		name        string
+		volume      k6tv1.Volume
+		conf        *VolumesConfig
+		device      string
+		readOnly    bool
+		wantFile    string
+		wantWarning string
+		wantErr     bool
+	}{
+		{
+			name:     "config map",
+			volume:   configMap,
+			device:   "cdrom",
+			readOnly: true,
+			wantFile: testVMIDir + "/config-map/config.iso",
+		},
+		{
+			name:     "secret",
+			volume:   secret,
+			conf:     &VolumesConfig{ConfigVolumes: ConfigVolumesISO},
+			device:   "cdrom",
+			readOnly: true,
+			wantFile: testVMIDir + "/secret/creds.iso",
+		},
+		{
+			name:     "service account",
+			volume:   serviceAccount,
+			device:   "cdrom",
+			readOnly: true,
+			wantFile: testVMIDir + "/service-account/service-account.iso",
+		},
+		{
+			name:        "writable disk made read-only",
+			volume:      configMap,
+			device:      "disk",
+			wantFile:    testVMIDir + "/config-map/config.iso",
+			wantWarning: "The disk data was made read-only",
+		},
+		{
+			name:    "lun",
+			volume:  configMap,
+			device:  "lun",
+			wantErr: true,
+		},
+		{
+			name:    "unknown mode",
+			volume:  configMap,
+			conf:    &VolumesConfig{ConfigVolumes: "nfs"},
+			device:  "cdrom",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disk := newTestDisk("data", tt.device, "")
+			if tt.readOnly {
+				disk.ReadOnly = toApiReadOnly(true)
+			}
+			c := newTestContext(&ProfileSpec{Volumes: tt.conf})
+			err := convert_v1_ConfigVolume_To_api_Disk(&tt.volume, disk, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantWarning == "" {
+				if len(c.Warnings) > 0 {
+					t.Errorf("unexpected warnings %v", c.Warnings)
+				}
+			} else if !containsWarning(c.Warnings, tt.wantWarning) {
+				t.Errorf("got warnings %v, want %q", c.Warnings, tt.wantWarning)
+			}
+			if disk.ReadOnly == nil {
+				t.Errorf("got a writable disk, want it read-only")
+			}
+			if disk.Source == nil || disk.Source.File == nil || disk.Source.File.File != tt.wantFile {
+				t.Errorf("got source %+v, want the file %s", disk.Source, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestConvertConfigVolumeToFilesystem(t *testing.T) {
+	configMap := k6tv1.Volume{Name: "config", VolumeSource: k6tv1.VolumeSource{ConfigMap: &k6tv1.ConfigMapVolumeSource{}}}
+	secret := k6tv1.Volume{Name: "creds", VolumeSource: k6tv1.VolumeSource{Secret: &k6tv1.SecretVolumeSource{}}}
+	serviceAccount := k6tv1.Volume{Name: "sa", VolumeSource: k6tv1.VolumeSource{ServiceAccount: &k6tv1.ServiceAccountVolumeSource{}}}
+
+	tests := []struct {
+		name       string
+		volume     k6tv1.Volume
+		backing    *libvirtxml.DomainMemoryBacking
+		wantDir    string
+		wantSource string
+		wantErr    bool
+	}{
+		{
+			name:       "config map, memfd by default",
+			volume:     configMap,
+			wantDir:    testVMIDir + "/config-map/config",
+			wantSource: "memfd",
+		},
+		{
+			name:       "secret",
+			volume:     secret,
+			wantDir:    testVMIDir + "/secret/creds",
+			wantSource: "memfd",
+		},
+		{
+			name:       "service account",
+			volume:     serviceAccount,
+			wantDir:    testVMIDir + "/service-account/data",
+			wantSource: "memfd",
+		},
+		{
+			name:   "hugepages",
+			volume: configMap,
+			backing: &libvirtxml.DomainMemoryBacking{
+				MemoryHugePages: &libvirtxml.DomainMemoryHugepages{},
+			},
+			wantDir: testVMIDir + "/config-map/config",
+		},
+		{
+			name:   "file memory",
+			volume: configMap,
+			backing: &libvirtxml.DomainMemoryBacking{
+				MemorySource: &libvirtxml.DomainMemorySource{Type: "file"},
+			},
+			wantDir:    testVMIDir + "/config-map/config",
+			wantSource: "file",
+		},
+		{
+			name:   "anonymous memory",
+			volume: configMap,
+			backing: &libvirtxml.DomainMemoryBacking{
+				MemorySource: &libvirtxml.DomainMemorySource{Type: "anonymous"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			domain := &libvirtxml.Domain{
+				Devices:       &libvirtxml.DomainDeviceList{},
+				MemoryBacking: tt.backing,
+			}
+			c := newTestContext(&ProfileSpec{Volumes: &VolumesConfig{ConfigVolumes: ConfigVolumesVirtiofs}})
+			err := convert_v1_ConfigVolume_To_api_Filesystem(&tt.volume, domain, c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(domain.Devices.Filesystems) != 1 {
+				t.Fatalf("got filesystems %+v, want one", domain.Devices.Filesystems)
+			}
+			fs := domain.Devices.Filesystems[0]
+			if fs.Driver == nil || fs.Driver.Type != "virtiofs" {
+				t.Errorf("got driver %+v, want virtiofs", fs.Driver)
+			}
+			if fs.Source == nil || fs.Source.Mount == nil || fs.Source.Mount.Dir != tt.wantDir {
+				t.Errorf("got source %+v, want the directory %s", fs.Source, tt.wantDir)
+			}
+			if fs.Target == nil || fs.Target.Dir != tt.volume.Name {
+				t.Errorf("got target %+v, want the tag %s", fs.Target, tt.volume.Name)
+			}
+
+			backing := domain.MemoryBacking
+			if backing.MemoryAccess == nil || backing.MemoryAccess.Mode != "shared" {
+				t.Errorf("got memory access %+v, want shared", backing.MemoryAccess)
+			}
+			source := ""
+			if backing.MemorySource != nil {
+				source = backing.MemorySource.Type
+			}
+			if source != tt.wantSource {
+				t.Errorf("got memory source %q, want %q", source, tt.wantSource)
+			}
+		})
+	}
+}